@@ -2,7 +2,6 @@
 set -e
 
 cd updater
-go build -o rootca
+go build -o ../rootca-updater .
 cd ../
-mv updater/rootca .
-./rootca
\ No newline at end of file
+./rootca-updater
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/tls-inspector/rootca/updater/rootca"
+)
+
+const AndroidBundleName = "android_ca_bundle"
+const androidVendorID = "android"
+
+// The gitiles URL of the ca-certificates repository
+var androidRepoURL = "https://android.googlesource.com/platform/system/ca-certificates"
+
+// Paths within the ca-certificates repository
+const (
+	androidFilesPath   = "files"
+	androidRemovedPath = "removed"
+)
+
+// androidTagPattern matches the tags of Android platform releases, such as android-14.0.0_r1
+var androidTagPattern = regexp.MustCompile(`^android-([0-9]+(?:\.[0-9]+)*)_r([0-9]+)$`)
+
+// androidTarballLimits restricts how much is extracted from the ca-certificates archive. Each certificate file is only
+// a few kilobytes.
+var androidTarballLimits = tarballLimits{
+	MaxEntrySize: 1 << 20,  // 1 MiB
+	MaxTotalSize: 64 << 20, // 64 MiB
+}
+
+type androidVendor struct{}
+
+func (androidVendor) ID() string         { return androidVendorID }
+func (androidVendor) Name() string       { return "Android" }
+func (androidVendor) BundleName() string { return AndroidBundleName }
+func (androidVendor) Inputs() []string   { return nil }
+
+// LatestVersion will find the last commit to the ca-certificates repository as of the release tag. The tag is either
+// pinned with --android-tag or the most recent platform release.
+func (androidVendor) LatestVersion(bundleDir string) (*VendorVersion, error) {
+	tag := androidTag
+	if tag == "" {
+		latestTag, err := getLatestAndroidTag()
+		if err != nil {
+			return nil, err
+		}
+		tag = latestTag
+	}
+
+	var commits struct {
+		Log []struct {
+			Commit    string `json:"commit"`
+			Committer struct {
+				Time string `json:"time"`
+			} `json:"committer"`
+		} `json:"log"`
+	}
+	if err := gitilesGetJSON(androidRepoURL+"/+log/refs/tags/"+tag+"?format=JSON&n=1", &commits); err != nil {
+		return nil, fmt.Errorf("getting commit: %s", err.Error())
+	}
+	if len(commits.Log) == 0 {
+		return nil, fmt.Errorf("no commits found at %s", tag)
+	}
+
+	date, err := time.Parse("Mon Jan _2 15:04:05 2006 -0700", commits.Log[0].Committer.Time)
+	if err != nil {
+		date = time.Now()
+	}
+
+	return &VendorVersion{Key: commits.Log[0].Commit, Date: date.UTC(), Version: tag}, nil
+}
+
+// Fetch will download the ca-certificates repository at the versions commit and write every certificate in the files
+// directory into dir, excluding any certificate that is also in the removed directory
+func (androidVendor) Fetch(version *VendorVersion, bundleDir, dir string) ([]string, error) {
+	archive, err := httpGet(androidRepoURL + "/+archive/" + version.Key + ".tar.gz")
+	if err != nil {
+		return nil, fmt.Errorf("error downloading archive: %s", err.Error())
+	}
+	defer archive.Close()
+	// Archives from gitiles do not have a top-level directory
+	if err := extractTarball(archive, []string{androidFilesPath, androidRemovedPath}, 0, dir, androidTarballLimits); err != nil {
+		return nil, fmt.Errorf("error extracting archive: %s", err.Error())
+	}
+
+	removed := map[string]bool{}
+	if fileExists(path.Join(dir, androidRemovedPath)) {
+		removedCerts, err := readAndroidCertificates(path.Join(dir, androidRemovedPath))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", androidRemovedPath, err.Error())
+		}
+		for sha := range removedCerts {
+			removed[sha] = true
+		}
+	}
+
+	certs, err := readAndroidCertificates(path.Join(dir, androidFilesPath))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", androidFilesPath, err.Error())
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates")
+	}
+
+	certDir := path.Join(dir, "bundle")
+	if err := os.MkdirAll(certDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	certPaths := []string{}
+	for sha, pemCert := range certs {
+		if removed[sha] {
+			continue
+		}
+		certPath := path.Join(certDir, sha+".crt")
+		if err := os.WriteFile(certPath, pemCert, 0644); err != nil {
+			return nil, err
+		}
+		certPaths = append(certPaths, certPath)
+	}
+
+	return certPaths, nil
+}
+
+func (v androidVendor) Build(version *VendorVersion, bundleDir string, certPaths []string) (*VendorMetadata, error) {
+	return generateVendorBundle(v, version, bundleDir, certPaths)
+}
+
+// readAndroidCertificates will read every certificate file in dir, returning the PEM-encoded certificates keyed by
+// SHA-256 fingerprint. Files are named by the subject hash of the certificate, such as 00673b5b.0, and contain the
+// PEM-encoded certificate along with a text description of it.
+func readAndroidCertificates(dir string) (map[string][]byte, error) {
+	items, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	certs := map[string][]byte{}
+	for _, item := range items {
+		if item.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, item.Name()))
+		if err != nil {
+			return nil, err
+		}
+		pemCerts := rootca.ExtractPEMCertificates(data)
+		if len(pemCerts) != 1 {
+			return nil, fmt.Errorf("%s: unexpected number of certificates. expected 1 got %d", item.Name(), len(pemCerts))
+		}
+		sha, err := getCertPemSHA(pemCerts[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", item.Name(), err.Error())
+		}
+		certs[sha] = pemCerts[0]
+	}
+	return certs, nil
+}
+
+// getLatestAndroidTag returns the most recent Android platform release tag of the ca-certificates repository
+func getLatestAndroidTag() (string, error) {
+	tags := map[string]json.RawMessage{}
+	if err := gitilesGetJSON(androidRepoURL+"/+refs/tags?format=JSON", &tags); err != nil {
+		return "", fmt.Errorf("getting tags: %s", err.Error())
+	}
+
+	latestTag := ""
+	var latestMatch []string
+	for tag := range tags {
+		match := androidTagPattern.FindStringSubmatch(tag)
+		if match == nil {
+			continue
+		}
+		if latestMatch != nil {
+			c := compareVersions(match[1], latestMatch[1])
+			if c < 0 || (c == 0 && compareVersions(match[2], latestMatch[2]) <= 0) {
+				continue
+			}
+		}
+		latestTag = tag
+		latestMatch = match
+	}
+
+	if latestTag == "" {
+		return "", fmt.Errorf("no release tags")
+	}
+	return latestTag, nil
+}
+
+// gitilesGetJSON will request JSON from gitiles and decode it into v. Gitiles prefixes JSON responses with )]}' to
+// prevent them from being executed as JavaScript.
+func gitilesGetJSON(url string, v any) error {
+	data, err := httpGetBytes(url)
+	if err != nil {
+		return err
+	}
+	data = bytes.TrimPrefix(data, []byte(")]}'"))
+	return json.Unmarshal([]byte(strings.TrimSpace(string(data))), v)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+)
+
+func testAndroidServer(t *testing.T, files map[string][]byte) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if data, ok := files[r.URL.RequestURI()]; ok {
+			w.Write(data)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	repoURL := androidRepoURL
+	androidRepoURL = server.URL
+	t.Cleanup(func() {
+		androidRepoURL = repoURL
+	})
+}
+
+// testAndroidCertificateFile returns the contents of a certificate file as found in the ca-certificates repository,
+// which is the PEM-encoded certificate followed by a text description of it
+func testAndroidCertificateFile(cert *testCertificate) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Cert.Raw})) +
+		"Certificate:\n    Data:\n        Subject: CN=" + cert.Cert.Subject.CommonName + "\n"
+}
+
+func TestAndroidFetch(t *testing.T) {
+	kept := generateTestCertificate(t, "Kept Root", nil, nil, nil)
+	removed := generateTestCertificate(t, "Removed Root", nil, nil, nil)
+	onlyRemoved := generateTestCertificate(t, "Only Removed Root", nil, nil, nil)
+
+	testAndroidServer(t, map[string][]byte{
+		"/+archive/abc.tar.gz": buildTarball(t, []testTarEntry{
+			{name: "files/00000001.0", data: testAndroidCertificateFile(kept)},
+			{name: "files/00000002.0", data: testAndroidCertificateFile(removed)},
+			{name: "removed/00000002.0", data: testAndroidCertificateFile(removed)},
+			{name: "removed/00000003.0", data: testAndroidCertificateFile(onlyRemoved)},
+			{name: "README.md", data: "readme"},
+		}),
+	})
+
+	certPaths, err := androidVendor{}.Fetch(&VendorVersion{Key: "abc"}, ".", t.TempDir())
+	if err != nil {
+		t.Fatalf("Error fetching certificates: %s", err.Error())
+	}
+	if len(certPaths) != 1 || path.Base(certPaths[0]) != testCertSHA(kept)+".crt" {
+		t.Errorf("Unexpected certificates %v", certPaths)
+	}
+	if !verifyCertPEMSHA(certPaths[0], testCertSHA(kept)) {
+		t.Errorf("Certificate file does not contain only the certificate")
+	}
+}
+
+func TestReadAndroidCertificates(t *testing.T) {
+	first := generateTestCertificate(t, "First Root", nil, nil, nil)
+	second := generateTestCertificate(t, "Second Root", nil, nil, nil)
+
+	dir := t.TempDir()
+	if err := extractTarball(bytes.NewReader(buildTarball(t, []testTarEntry{
+		{name: "files/00000001.0", data: testAndroidCertificateFile(first)},
+		{name: "files/00000002.0", data: testAndroidCertificateFile(second)},
+		{name: "files/nested/ignored.0", data: "not a certificate"},
+		{name: "invalid/00000003.0", data: testAndroidCertificateFile(first) + testAndroidCertificateFile(second)},
+	})), []string{"files", "invalid"}, 0, dir, androidTarballLimits); err != nil {
+		t.Fatalf("Error extracting tarball: %s", err.Error())
+	}
+
+	certs, err := readAndroidCertificates(path.Join(dir, "files"))
+	if err != nil {
+		t.Fatalf("Error reading certificates: %s", err.Error())
+	}
+	for sha, pemCert := range certs {
+		if sha != testCertSHA(first) && sha != testCertSHA(second) {
+			t.Errorf("Unexpected certificate %s", sha)
+		}
+		if block, rest := pem.Decode(pemCert); block == nil || len(rest) != 0 {
+			t.Errorf("Certificate %s is not a single PEM block", sha)
+		}
+	}
+	if len(certs) != 2 {
+		t.Errorf("Expected 2 certificates, got %d", len(certs))
+	}
+
+	if _, err := readAndroidCertificates(path.Join(dir, "invalid")); err == nil {
+		t.Errorf("No error seen for file with multiple certificates")
+	}
+}
+
+func TestGetLatestAndroidTag(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		tags     string
+		expected string
+	}{
+		{
+			name:     "revision",
+			tags:     `{"android-13.0.0_r9": {}, "android-13.0.0_r10": {}, "android-12.1.0_r27": {}}`,
+			expected: "android-13.0.0_r10",
+		},
+		{
+			name:     "version",
+			tags:     `{"android-9.0.0_r61": {}, "android-14.0.0_r1": {}, "android-13.0.0_r83": {}, "android-14.0.0": {}}`,
+			expected: "android-14.0.0_r1",
+		},
+		{
+			name:     "other tags",
+			tags:     `{"android-platform-15.0.0_r1": {}, "android-security-14.0.0_r1": {}, "android-14.0.0_r2": {}, "android15-release": {}}`,
+			expected: "android-14.0.0_r2",
+		},
+		{
+			name: "no release tags",
+			tags: `{"android-platform-15.0.0_r1": {}}`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			testAndroidServer(t, map[string][]byte{
+				"/+refs/tags?format=JSON": []byte(")]}'\n" + test.tags),
+			})
+
+			tag, err := getLatestAndroidTag()
+			if test.expected == "" {
+				if err == nil {
+					t.Errorf("No error seen")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Error getting tag: %s", err.Error())
+			}
+			if tag != test.expected {
+				t.Errorf("Expected %s, got %s", test.expected, tag)
+			}
+		})
+	}
+}
@@ -1,101 +1,527 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
-	"os/exec"
 	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/tls-inspector/rootca/updater/rootca"
 )
 
 const AppleBundleName = "apple_ca_bundle"
+const AppleAllRootsBundleName = "apple_all_roots_bundle"
+const appleVendorID = "apple"
+const appleAllRootsVendorID = "apple_all_roots"
+
+// Paths within the security_certificates repository
+const (
+	appleRootsPath       = "certificates/roots"
+	appleDistrustedPath  = "certificates/distrusted"
+	appleAllowlistPath   = "certificates/allowlist"
+	appleConstrainedPath = "certificates/constrained"
+	appleEVConfigPath    = "certificates/evroot.config"
+)
+
+// appleTarballLimits restricts how much is extracted from the security_certificates tarball. Root certificates are
+// only a few kilobytes each.
+var appleTarballLimits = tarballLimits{
+	MaxEntrySize: 1 << 20,  // 1 MiB
+	MaxTotalSize: 64 << 20, // 64 MiB
+}
+
+// appleVendor builds a bundle of the roots in Apple's trust store that are neither distrusted nor allowlisted. The
+// repository does not record which purposes a root is trusted for, so roots only used for other purposes than TLS are
+// not excluded. It depends on the all roots vendor so that the tarball it downloads can be reused.
+type appleVendor struct{}
+
+func (appleVendor) ID() string         { return appleVendorID }
+func (appleVendor) Name() string       { return "Apple" }
+func (appleVendor) BundleName() string { return AppleBundleName }
+func (appleVendor) Inputs() []string   { return []string{appleAllRootsVendorID} }
+
+func (appleVendor) LatestVersion(bundleDir string) (*VendorVersion, error) {
+	return getLatestAppleVersion()
+}
+
+// Fetch will download the security_certificates tarball and write every root into dir, excluding distrusted roots and
+// roots only trusted for an allowlist of leaf certificates
+func (appleVendor) Fetch(version *VendorVersion, bundleDir, dir string) ([]string, error) {
+	roots, err := fetchAppleRoots(version.Extra.(string), dir)
+	if err != nil {
+		return nil, err
+	}
+	version.Extra = roots
+
+	return roots.writeCertificates(path.Join(dir, "bundle"), func(root *appleRoot) bool {
+		return !root.Distrusted && !root.Allowlisted
+	})
+}
 
-func buildAppleBundle(metadata *VendorMetadata) (*VendorMetadata, error) {
+func (v appleVendor) Build(version *VendorVersion, bundleDir string, certPaths []string) (*VendorMetadata, error) {
+	return buildAppleBundle(v, version, bundleDir, certPaths)
+}
+
+// appleAllRootsVendor builds a bundle of every root in Apple's trust store, regardless of how they are trusted
+type appleAllRootsVendor struct{}
+
+func (appleAllRootsVendor) ID() string         { return appleAllRootsVendorID }
+func (appleAllRootsVendor) Name() string       { return "Apple (All Roots)" }
+func (appleAllRootsVendor) BundleName() string { return AppleAllRootsBundleName }
+func (appleAllRootsVendor) Inputs() []string   { return nil }
+
+func (appleAllRootsVendor) LatestVersion(bundleDir string) (*VendorVersion, error) {
+	return getLatestAppleVersion()
+}
+
+// Fetch will download the security_certificates tarball and write every root into dir
+func (appleAllRootsVendor) Fetch(version *VendorVersion, bundleDir, dir string) ([]string, error) {
+	roots, err := fetchAppleRoots(version.Extra.(string), dir)
+	if err != nil {
+		return nil, err
+	}
+	version.Extra = roots
+
+	return roots.writeCertificates(path.Join(dir, "bundle"), func(root *appleRoot) bool {
+		return true
+	})
+}
+
+func (v appleAllRootsVendor) Build(version *VendorVersion, bundleDir string, certPaths []string) (*VendorMetadata, error) {
+	return buildAppleBundle(v, version, bundleDir, certPaths)
+}
+
+func getLatestAppleVersion() (*VendorVersion, error) {
 	latestSHA, lastModified, tarballURL, err := getLatestAppleSHA()
 	if err != nil {
 		return nil, err
 	}
 
-	if metadata != nil && !forceUpdate {
-		if lastModified.Before(metadata.MustDate()) {
-			logWarning("Apple bundle has modified date '%s' newer than the most recent vendors date '%s'. Skipping update.", metadata.MustDate(), lastModified)
-			return metadata, nil
+	return &VendorVersion{Key: latestSHA, Date: lastModified, Extra: tarballURL}, nil
+}
+
+// buildAppleBundle will generate the bundle and record the trust of each root in the metadata
+func buildAppleBundle(vendor Vendor, version *VendorVersion, bundleDir string, certPaths []string) (*VendorMetadata, error) {
+	roots := version.Extra.(*appleRoots)
+
+	metadata, err := generateVendorBundle(vendor, version, bundleDir, certPaths)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, certificate := range metadata.Certificates {
+		root, ok := roots.Roots[certificate.SHA256]
+		if !ok {
+			return nil, fmt.Errorf("unknown root %s", certificate.SHA256)
+		}
+
+		trust := &rootca.CertificateTrust{ServerAuth: rootca.TrustTrusted}
+		if root.Distrusted {
+			trust.ServerAuth = rootca.TrustDistrusted
+		} else if root.Allowlisted {
+			trust = nil
 		}
-		if isBundleUpToDate(latestSHA, metadata.Key, AppleBundleName) {
-			logNotice("Apple bundle is up-to-date")
-			return metadata, nil
+		metadata.Certificates[i].Trust = trust
+		if len(root.EVPolicies) > 0 || root.Allowlisted || root.Constrained {
+			metadata.Certificates[i].Apple = &rootca.AppleAttributes{
+				EVPolicies:        root.EVPolicies,
+				Allowlisted:       root.Allowlisted,
+				Constrained:       root.Constrained,
+				PermittedDNSNames: root.PermittedDNSNames,
+				ExcludedDNSNames:  root.ExcludedDNSNames,
+			}
 		}
-		logWarning("Detected changes to Apple bundle. LastSHA='%s' LatestSHA='%s'", metadata.Key, latestSHA)
 	}
-	log.Printf("Building Apple CA bundle")
 
-	tempDir, err := os.MkdirTemp("", "apple")
-	if err != nil {
-		return nil, err
+	return metadata, nil
+}
+
+// appleRoot is a single root certificate from the security_certificates repository
+type appleRoot struct {
+	DER []byte
+	// If the root is also present in the distrusted certificates
+	Distrusted bool
+	// If the root issued any of the allowlisted leaf certificates, and is therefore only trusted for those
+	Allowlisted bool
+	// If the root is present in the constrained certificates, and is therefore only trusted within the name
+	// constraints of that certificate
+	Constrained       bool
+	PermittedDNSNames []string
+	ExcludedDNSNames  []string
+	// The EV policy OIDs from evroot.config
+	EVPolicies []string
+}
+
+// appleRoots are the roots from the security_certificates repository, keyed by uppercase hex SHA-256 fingerprint
+type appleRoots struct {
+	Roots map[string]*appleRoot
+}
+
+// appleRootsCache holds the roots read from each security_certificates tarball, keyed by tarball URL, so that a
+// tarball is only downloaded once even though several vendors are built from it
+var appleRootsCache = struct {
+	sync.Mutex
+	entries map[string]*appleRootsCacheEntry
+}{entries: map[string]*appleRootsCacheEntry{}}
+
+type appleRootsCacheEntry struct {
+	once  sync.Once
+	roots *appleRoots
+	err   error
+}
+
+// fetchAppleRoots will return the roots of the security_certificates tarball at the given URL, downloading and
+// extracting it into dir unless it was already read
+func fetchAppleRoots(tarballURL, dir string) (*appleRoots, error) {
+	appleRootsCache.Lock()
+	entry, ok := appleRootsCache.entries[tarballURL]
+	if !ok {
+		entry = &appleRootsCacheEntry{}
+		appleRootsCache.entries[tarballURL] = entry
 	}
-	defer os.RemoveAll(tempDir)
+	appleRootsCache.Unlock()
 
-	tarballPath := path.Join(tempDir, "apple.tar.gz")
-	if err := downloadFile(tarballURL, tarballPath); err != nil {
+	entry.once.Do(func() {
+		entry.roots, entry.err = readAppleRoots(tarballURL, dir)
+	})
+	return entry.roots, entry.err
+}
+
+// readAppleRoots will download and extract the security_certificates tarball into dir, and read the roots along with
+// their trust from the distrusted certificates, allowlist, constrained certificates, and EV configuration
+func readAppleRoots(tarballURL, dir string) (*appleRoots, error) {
+	tarball, err := httpGet(tarballURL)
+	if err != nil {
 		return nil, fmt.Errorf("error downloading archive: %s", err.Error())
 	}
-
-	exCmd := exec.Command("tar", "-xzf", tarballPath, "--strip", "1")
-	exCmd.Dir = tempDir
-	if err := exCmd.Run(); err != nil {
+	defer tarball.Close()
+	paths := []string{appleRootsPath, appleDistrustedPath, appleAllowlistPath, appleConstrainedPath, appleEVConfigPath}
+	if err := extractTarball(tarball, paths, 1, dir, appleTarballLimits); err != nil {
 		return nil, fmt.Errorf("error extracting archive: %s", err.Error())
 	}
 
-	outputDir := path.Join(tempDir, "bundle")
-	os.Mkdir(outputDir, os.ModePerm)
-	certificateDir := path.Join(tempDir, "certificates", "roots")
-	items, err := os.ReadDir(certificateDir)
+	roots := &appleRoots{Roots: map[string]*appleRoot{}}
+
+	// Roots are the certificate files directly within the roots directory
+	items, err := os.ReadDir(path.Join(dir, appleRootsPath))
 	if err != nil {
 		return nil, fmt.Errorf("error reading certificate directory: %s", err.Error())
 	}
-	certPaths := []string{}
-	for i, item := range items {
-		if !strings.HasSuffix(item.Name(), ".cer") && !strings.HasSuffix(item.Name(), ".der") && !strings.HasSuffix(item.Name(), ".crt") {
+	rootFileNames := map[string]string{}
+	for _, item := range items {
+		if !isAppleCertificateFile(item.Name()) || item.IsDir() {
 			continue
 		}
-		certPath := path.Join(outputDir, fmt.Sprintf("cert_%d.crt", i))
-		_, err := convertDerToPem(path.Join(certificateDir, item.Name()), certPath)
+		der, err := readCertificateFile(path.Join(dir, appleRootsPath, item.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", item.Name(), err.Error())
+		}
+		sha := fmt.Sprintf("%X", sha256.Sum256(der))
+		roots.Roots[sha] = &appleRoot{DER: der}
+		rootFileNames[item.Name()] = sha
+	}
+	if len(roots.Roots) == 0 {
+		return nil, fmt.Errorf("no certificates")
+	}
+
+	// Distrusted certificates are matched by fingerprint
+	distrusted, err := readAppleCertificateTree(path.Join(dir, appleDistrustedPath))
+	if err != nil {
+		return nil, fmt.Errorf("error reading distrusted certificates: %s", err.Error())
+	}
+	for _, der := range distrusted {
+		if root, ok := roots.Roots[fmt.Sprintf("%X", sha256.Sum256(der))]; ok {
+			root.Distrusted = true
+		}
+	}
+
+	// Allowlisted leaf certificates are matched to the root that issued them
+	if fileExists(path.Join(dir, appleAllowlistPath)) {
+		allowlisted, err := readAppleCertificateTree(path.Join(dir, appleAllowlistPath))
 		if err != nil {
-			return nil, fmt.Errorf("error converting certificate: %s", err.Error())
+			return nil, fmt.Errorf("error reading allowlisted certificates: %s", err.Error())
+		}
+		roots.markAllowlisted(allowlisted)
+	} else {
+		log.Printf("No Apple allowlist found at %s", appleAllowlistPath)
+	}
+
+	// Constrained certificates are matched to the root with the same subject and public key
+	if fileExists(path.Join(dir, appleConstrainedPath)) {
+		constrained, err := readAppleCertificateTree(path.Join(dir, appleConstrainedPath))
+		if err != nil {
+			return nil, fmt.Errorf("error reading constrained certificates: %s", err.Error())
+		}
+		if err := roots.markConstrained(constrained); err != nil {
+			return nil, fmt.Errorf("error reading constrained certificates: %s", err.Error())
+		}
+	} else {
+		log.Printf("No Apple constrained certificates found at %s", appleConstrainedPath)
+	}
+
+	evConfig, err := os.ReadFile(path.Join(dir, appleEVConfigPath))
+	if err != nil {
+		return nil, fmt.Errorf("error reading EV configuration: %s", err.Error())
+	}
+	evPolicies, err := parseAppleEVConfig(string(evConfig))
+	if err != nil {
+		return nil, fmt.Errorf("evroot.config: %s", err.Error())
+	}
+	for fileName, oids := range evPolicies {
+		sha, ok := rootFileNames[fileName]
+		if !ok {
+			log.Printf("EV root %s is not in the Apple roots", fileName)
+			continue
+		}
+		roots.Roots[sha].EVPolicies = oids
+	}
+
+	return roots, nil
+}
+
+// markAllowlisted will mark every root that issued any of the allowlisted certificates. Allowlisted certificates may be
+// issued by an intermediate, in which case the chain is followed through the other allowlisted certificates. A chain
+// can only be followed as far as the allowlist contains its intermediates, so a leaf whose intermediate is not in the
+// allowlist will not mark any root.
+func (r *appleRoots) markAllowlisted(allowlisted [][]byte) {
+	allowlistedCerts := []*x509.Certificate{}
+	for _, der := range allowlisted {
+		cert, err := rootca.ParseCertificate(der)
+		if err != nil || cert == nil {
+			continue
+		}
+		allowlistedCerts = append(allowlistedCerts, cert)
+	}
+
+	rootCerts := map[*appleRoot]*x509.Certificate{}
+	for _, root := range r.Roots {
+		rootCert, err := rootca.ParseCertificate(root.DER)
+		if err != nil || rootCert == nil {
+			continue
+		}
+		rootCerts[root] = rootCert
+	}
+
+	for _, cert := range allowlistedCerts {
+		// Each step moves to a different allowlisted certificate, so the chain is at most as long as the allowlist
+		for range len(allowlistedCerts) {
+			found := false
+			for root, rootCert := range rootCerts {
+				if !bytes.Equal(cert.Raw, rootCert.Raw) && appleIssuedBy(cert, rootCert) {
+					root.Allowlisted = true
+					found = true
+				}
+			}
+			if found {
+				break
+			}
+
+			var issuer *x509.Certificate
+			for _, candidate := range allowlistedCerts {
+				if candidate != cert && appleIssuedBy(cert, candidate) {
+					issuer = candidate
+					break
+				}
+			}
+			if issuer == nil {
+				break
+			}
+			cert = issuer
+		}
+	}
+}
+
+// appleIssuedBy returns true if the certificates issuer and authority key identifier, if any, match the subject and
+// subject key identifier of the parent
+func appleIssuedBy(cert, parent *x509.Certificate) bool {
+	if !bytes.Equal(cert.RawIssuer, parent.RawSubject) {
+		return false
+	}
+	return len(cert.AuthorityKeyId) == 0 || bytes.Equal(cert.AuthorityKeyId, parent.SubjectKeyId)
+}
+
+// markConstrained will mark every root with the same subject and public key as any of the constrained certificates,
+// and record the DNS name constraints of that certificate. The constrained certificate may be the root itself or a
+// variant of it that carries the name constraints.
+func (r *appleRoots) markConstrained(constrained [][]byte) error {
+	rootIdentities := map[string]*appleRoot{}
+	for _, root := range r.Roots {
+		rootCert, err := rootca.ParseCertificate(root.DER)
+		if err != nil || rootCert == nil {
+			continue
+		}
+		rootIdentities[certificateIdentity(rootCert)] = root
+	}
+
+	for _, der := range constrained {
+		cert, err := rootca.ParseCertificate(der)
+		if err != nil {
+			return err
+		}
+		if cert == nil {
+			continue
+		}
+		root, ok := rootIdentities[certificateIdentity(cert)]
+		if !ok {
+			log.Printf("Constrained certificate %X is not in the Apple roots", sha256.Sum256(der))
+			continue
+		}
+		root.Constrained = true
+		for _, name := range cert.PermittedDNSDomains {
+			if !sliceContains(root.PermittedDNSNames, name) {
+				root.PermittedDNSNames = append(root.PermittedDNSNames, name)
+			}
+		}
+		for _, name := range cert.ExcludedDNSDomains {
+			if !sliceContains(root.ExcludedDNSNames, name) {
+				root.ExcludedDNSNames = append(root.ExcludedDNSNames, name)
+			}
+		}
+	}
+	return nil
+}
+
+// writeCertificates will write every root matching the filter as a PEM file into dir and return their paths
+func (r *appleRoots) writeCertificates(dir string, filter func(root *appleRoot) bool) ([]string, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	shas := make([]string, 0, len(r.Roots))
+	for sha := range r.Roots {
+		shas = append(shas, sha)
+	}
+	sort.Strings(shas)
+
+	certPaths := []string{}
+	for _, sha := range shas {
+		if !filter(r.Roots[sha]) {
+			continue
+		}
+		certPath := path.Join(dir, sha+".crt")
+		if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: r.Roots[sha].DER}), 0644); err != nil {
+			return nil, err
 		}
 		certPaths = append(certPaths, certPath)
 	}
+	return certPaths, nil
+}
+
+func isAppleCertificateFile(name string) bool {
+	return strings.HasSuffix(name, ".cer") || strings.HasSuffix(name, ".der") || strings.HasSuffix(name, ".crt")
+}
 
-	p7Fingerprints, pemFingerprints, err := generateBundleFromCertificates(certPaths, AppleBundleName)
+// readCertificateFile will read a DER or PEM encoded certificate file and return the DER bytes
+func readCertificateFile(filePath string) ([]byte, error) {
+	data, err := os.ReadFile(filePath)
 	if err != nil {
 		return nil, err
 	}
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("unexpected pem type %s", block.Type)
+		}
+		return block.Bytes, nil
+	}
+	return data, nil
+}
+
+// readAppleCertificateTree will read every certificate file within dir and any subdirectories. Files that are not
+// certificates, such as property lists, are ignored.
+func readAppleCertificateTree(dir string) ([][]byte, error) {
+	certificates := [][]byte{}
+	err := filepath.WalkDir(dir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !isAppleCertificateFile(entry.Name()) {
+			return nil
+		}
+		der, err := readCertificateFile(filePath)
+		if err != nil {
+			return fmt.Errorf("%s: %s", entry.Name(), err.Error())
+		}
+		certificates = append(certificates, der)
+		return nil
+	})
+	return certificates, err
+}
 
-	logNotice("Apple CA bundle generated with %d certificates", len(certPaths))
+// parseAppleEVConfig will parse evroot.config, returning the EV policy OIDs for each root keyed by the file name of the
+// root. Each line of the configuration is an OID followed by the file names of the roots for that OID, separated by
+// whitespace and optionally quoted.
+func parseAppleEVConfig(config string) (map[string][]string, error) {
+	evPolicies := map[string][]string{}
+	for i, line := range strings.Split(config, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
 
-	return &VendorMetadata{
-		Key: latestSHA,
-		Bundles: map[string]BundleFingerprint{
-			AppleBundleName + ".p7b": *p7Fingerprints,
-			AppleBundleName + ".pem": *pemFingerprints,
-		},
-		Date:     lastModified.Format("2006-01-02T15:04:05Z07:00"),
-		NumCerts: len(certPaths),
-	}, nil
+		fields, err := splitAppleEVConfigLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", i+1, err.Error())
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: expected an OID and at least one certificate", i+1)
+		}
+		oid := fields[0]
+		for _, component := range strings.Split(oid, ".") {
+			if _, err := strconv.ParseUint(component, 10, 32); err != nil {
+				return nil, fmt.Errorf("line %d: invalid OID %s", i+1, oid)
+			}
+		}
+		for _, fileName := range fields[1:] {
+			if !sliceContains(evPolicies[fileName], oid) {
+				evPolicies[fileName] = append(evPolicies[fileName], oid)
+			}
+		}
+	}
+	return evPolicies, nil
 }
 
-func getLatestAppleSHA() (string, time.Time, string, error) {
-	type githubTagType struct {
-		Name       string `json:"name"`
-		TarballURL string `json:"tarball_url"`
-		Commit     struct {
-			SHA string `json:"sha"`
-		} `json:"commit"`
+// splitAppleEVConfigLine will split a line of evroot.config into whitespace separated fields. Fields may be quoted with
+// double quotes to include whitespace.
+func splitAppleEVConfigLine(line string) ([]string, error) {
+	fields := []string{}
+	for {
+		line = strings.TrimLeft(line, " \t")
+		if line == "" {
+			return fields, nil
+		}
+
+		if line[0] == '"' {
+			end := strings.IndexByte(line[1:], '"')
+			if end == -1 {
+				return nil, fmt.Errorf("unterminated quote")
+			}
+			fields = append(fields, line[1:end+1])
+			line = line[end+2:]
+			continue
+		}
+
+		end := strings.IndexAny(line, " \t")
+		if end == -1 {
+			end = len(line)
+		}
+		fields = append(fields, line[:end])
+		line = line[end:]
 	}
+}
 
+func getLatestAppleSHA() (string, time.Time, string, error) {
 	type githubCommitType struct {
 		SHA    string `json:"sha"`
 		Commit struct {
@@ -113,14 +539,14 @@ func getLatestAppleSHA() (string, time.Time, string, error) {
 	}
 	defer tagsResp.Close()
 
-	var tags []githubTagType
+	var tags []appleTag
 	if err := json.NewDecoder(tagsResp).Decode(&tags); err != nil {
 		return "", time.Now(), "", err
 	}
 
-	// For that tag, get the last commit that touched anything in certificates/roots folder
-	// (we don't care about the other contents in this repo)
-	commitResp, err := httpGet("https://api.github.com/repos/apple-oss-distributions/security_certificates/commits?path=certificates/roots&sha=" + tags[0].Commit.SHA)
+	// For that tag, get the last commit that touched anything in certificates folder, which contains the roots and
+	// their trust metadata (we don't care about the other contents in this repo)
+	commitResp, err := httpGet("https://api.github.com/repos/apple-oss-distributions/security_certificates/commits?path=certificates&sha=" + tags[0].Commit.SHA)
 	if err != nil {
 		return "", time.Now(), "", fmt.Errorf("getting commit: %s", err)
 	}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// appleReleasesData maps security_certificates tag names to additional OS releases that shipped them, such as
+// {"security_certificates-55286.0.1": ["iOS 17.4"]}. The macOS releases of each tag are derived from the
+// distribution-macOS repository, but Apple does not publish sources for other platforms, so any other releases are
+// maintained by hand from Apple's release notes.
+//
+//go:embed apple_releases.json
+var appleReleasesData []byte
+
+// The distribution-macOS repository has a tag for every macOS release with sources, such as macos-1441 for macOS
+// 14.4.1, and a release.json at each tag listing the tag of every project in that release
+var appleDistributionAPIURL = "https://api.github.com/repos/apple-oss-distributions/distribution-macOS"
+var appleDistributionRawURL = "https://raw.githubusercontent.com/apple-oss-distributions/distribution-macOS"
+
+const appleReleaseVendorIDPrefix = "apple_release_"
+const appleTagPrefix = "security_certificates-"
+
+// appleTag is a single tag of the security_certificates repository
+type appleTag struct {
+	Name       string `json:"name"`
+	TarballURL string `json:"tarball_url"`
+	Commit     struct {
+		SHA string `json:"sha"`
+	} `json:"commit"`
+}
+
+// appleTagVersion returns the version number of the tag, such as 55286.0.1
+func (t appleTag) Version() string {
+	return strings.TrimPrefix(t.Name, appleTagPrefix)
+}
+
+// appleReleaseVendor builds a bundle of the roots Apple trusts for TLS as of a specific security_certificates tag.
+// Tags never change, so a release bundle is only rebuilt if it is missing or an update is forced.
+type appleReleaseVendor struct {
+	tag appleTag
+	// The OS releases that shipped this tag, if known
+	releases []string
+}
+
+func (v appleReleaseVendor) ID() string         { return appleReleaseVendorIDPrefix + v.tag.Version() }
+func (v appleReleaseVendor) Name() string       { return "Apple (" + v.tag.Name + ")" }
+func (v appleReleaseVendor) BundleName() string { return AppleBundleName + "_" + v.tag.Version() }
+func (appleReleaseVendor) Inputs() []string     { return nil }
+
+func (v appleReleaseVendor) LatestVersion(bundleDir string) (*VendorVersion, error) {
+	date, err := getAppleCommitDate(v.tag.Commit.SHA)
+	if err != nil {
+		return nil, err
+	}
+
+	return &VendorVersion{Key: v.tag.Commit.SHA, Date: date, Version: v.tag.Name, Extra: v.tag.TarballURL}, nil
+}
+
+// Fetch will download the tarball for the tag and write every root into dir, excluding distrusted and allowlisted roots
+func (v appleReleaseVendor) Fetch(version *VendorVersion, bundleDir, dir string) ([]string, error) {
+	return appleVendor{}.Fetch(version, bundleDir, dir)
+}
+
+// Build will generate the bundle and record the OS releases that shipped the tag in the metadata
+func (v appleReleaseVendor) Build(version *VendorVersion, bundleDir string, certPaths []string) (*VendorMetadata, error) {
+	metadata, err := buildAppleBundle(v, version, bundleDir, certPaths)
+	if err != nil {
+		return nil, err
+	}
+	metadata.Releases = v.releases
+	return metadata, nil
+}
+
+// isAppleReleaseVendor returns true if the vendor ID is that of a per-release Apple bundle
+func isAppleReleaseVendor(id string) bool {
+	return strings.HasPrefix(id, appleReleaseVendorIDPrefix)
+}
+
+// getAppleReleaseVendors returns a vendor for every security_certificates tag within tagRange. The range is in the
+// form first..last, where either may be omitted, and is inclusive. An empty range includes every tag.
+func getAppleReleaseVendors(tagRange string) ([]Vendor, error) {
+	first, last, err := parseAppleTagRange(tagRange)
+	if err != nil {
+		return nil, err
+	}
+
+	releases, err := getAppleOSReleases()
+	if err != nil {
+		return nil, fmt.Errorf("getting OS releases: %s", err.Error())
+	}
+	additionalReleases := map[string][]string{}
+	if err := json.Unmarshal(appleReleasesData, &additionalReleases); err != nil {
+		return nil, fmt.Errorf("apple_releases.json: %s", err.Error())
+	}
+	for tagName, tagReleases := range additionalReleases {
+		for _, release := range tagReleases {
+			if !sliceContains(releases[tagName], release) {
+				releases[tagName] = append(releases[tagName], release)
+			}
+		}
+	}
+
+	tags, err := getAppleTags()
+	if err != nil {
+		return nil, err
+	}
+
+	vendors := []Vendor{}
+	for _, tag := range tags {
+		if !strings.HasPrefix(tag.Name, appleTagPrefix) {
+			continue
+		}
+		if first != "" && compareVersions(tag.Version(), first) < 0 {
+			continue
+		}
+		if last != "" && compareVersions(tag.Version(), last) > 0 {
+			continue
+		}
+		if _, ok := releases[tag.Name]; !ok {
+			log.Printf("No OS releases known for Apple tag %s", tag.Name)
+		}
+		vendors = append(vendors, appleReleaseVendor{tag: tag, releases: releases[tag.Name]})
+	}
+	if len(vendors) == 0 {
+		return nil, fmt.Errorf("no Apple tags in range '%s'", tagRange)
+	}
+	return vendors, nil
+}
+
+// parseAppleTagRange will parse a range of tags in the form first..last. Each may be either a tag name or its
+// version number.
+func parseAppleTagRange(tagRange string) (first, last string, err error) {
+	if tagRange == "" {
+		return "", "", nil
+	}
+	parts := strings.Split(tagRange, "..")
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("invalid Apple tag range '%s', expected first..last", tagRange)
+	}
+	first = strings.TrimPrefix(parts[0], appleTagPrefix)
+	last = strings.TrimPrefix(parts[1], appleTagPrefix)
+	for _, version := range []string{first, last} {
+		if version == "" {
+			continue
+		}
+		for _, component := range strings.Split(version, ".") {
+			if _, err := strconv.ParseUint(component, 10, 32); err != nil {
+				return "", "", fmt.Errorf("invalid Apple tag version '%s'", version)
+			}
+		}
+	}
+	return first, last, nil
+}
+
+// getAppleOSReleases returns the macOS releases that shipped each security_certificates tag, keyed by tag name
+func getAppleOSReleases() (map[string][]string, error) {
+	tagNames := []string{}
+	for page := 1; ; page++ {
+		resp, err := httpGetBytes(fmt.Sprintf("%s/tags?per_page=100&page=%d", appleDistributionAPIURL, page))
+		if err != nil {
+			return nil, fmt.Errorf("getting tags: %s", err)
+		}
+		pageTags := []appleTag{}
+		if err := json.Unmarshal(resp, &pageTags); err != nil {
+			return nil, err
+		}
+		if len(pageTags) == 0 {
+			break
+		}
+		for _, tag := range pageTags {
+			tagNames = append(tagNames, tag.Name)
+		}
+	}
+
+	type releaseType struct {
+		Projects []struct {
+			Project string `json:"project"`
+			Tag     string `json:"tag"`
+		} `json:"projects"`
+	}
+
+	releases := map[string][]string{}
+	for _, tagName := range tagNames {
+		osRelease, ok := parseMacOSTag(tagName)
+		if !ok {
+			continue
+		}
+
+		resp, err := httpGetBytes(appleDistributionRawURL + "/" + tagName + "/release.json")
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", tagName, err.Error())
+		}
+		release := releaseType{}
+		if err := json.Unmarshal(resp, &release); err != nil {
+			return nil, fmt.Errorf("%s: release.json: %s", tagName, err.Error())
+		}
+
+		found := false
+		for _, project := range release.Projects {
+			if project.Project != "security_certificates" {
+				continue
+			}
+			found = true
+			if !sliceContains(releases[project.Tag], osRelease) {
+				releases[project.Tag] = append(releases[project.Tag], osRelease)
+			}
+		}
+		if !found {
+			log.Printf("No security_certificates project in %s", tagName)
+		}
+	}
+	return releases, nil
+}
+
+// parseMacOSTag returns the macOS release of a distribution-macOS tag, such as "macOS 14.4.1" for macos-1441. The
+// version is the two digit major version followed by the minor and patch versions, where the minor version is two
+// digits for macOS 10 and one digit otherwise.
+func parseMacOSTag(tagName string) (string, bool) {
+	digits, ok := strings.CutPrefix(tagName, "macos-")
+	if !ok || len(digits) < 3 {
+		return "", false
+	}
+	if _, err := strconv.ParseUint(digits, 10, 32); err != nil {
+		return "", false
+	}
+
+	major, rest := digits[:2], digits[2:]
+	minorLength := 1
+	if major == "10" {
+		minorLength = 2
+	}
+	if len(rest) < minorLength {
+		return "", false
+	}
+	minor, patch := strings.TrimPrefix(rest[:minorLength], "0"), rest[minorLength:]
+	if minor == "" {
+		minor = "0"
+	}
+
+	version := major + "." + minor
+	if patch != "" && patch != "0" {
+		version += "." + patch
+	}
+	return "macOS " + version, true
+}
+
+// getAppleTags returns every tag of the security_certificates repository
+func getAppleTags() ([]appleTag, error) {
+	tags := []appleTag{}
+	for page := 1; ; page++ {
+		tagsResp, err := httpGet(fmt.Sprintf("https://api.github.com/repos/apple-oss-distributions/security_certificates/tags?per_page=100&page=%d", page))
+		if err != nil {
+			return nil, fmt.Errorf("getting tags: %s", err)
+		}
+
+		pageTags := []appleTag{}
+		err = json.NewDecoder(tagsResp).Decode(&pageTags)
+		tagsResp.Close()
+		if err != nil {
+			return nil, err
+		}
+		if len(pageTags) == 0 {
+			return tags, nil
+		}
+		tags = append(tags, pageTags...)
+	}
+}
+
+// getAppleCommitDate returns the date of the given commit in the security_certificates repository
+func getAppleCommitDate(sha string) (time.Time, error) {
+	commitResp, err := httpGet("https://api.github.com/repos/apple-oss-distributions/security_certificates/commits/" + sha)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("getting commit: %s", err)
+	}
+	defer commitResp.Close()
+
+	var commit struct {
+		Commit struct {
+			Author struct {
+				Date string `json:"date"`
+			} `json:"author"`
+		} `json:"commit"`
+	}
+	if err := json.NewDecoder(commitResp).Decode(&commit); err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Parse("2006-01-02T15:04:05Z", commit.Commit.Author.Date)
+}
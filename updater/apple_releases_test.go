@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseMacOSTag(t *testing.T) {
+	for tagName, expected := range map[string]string{
+		"macos-1441":  "macOS 14.4.1",
+		"macos-144":   "macOS 14.4",
+		"macos-1100":  "macOS 11.0",
+		"macos-1101":  "macOS 11.0.1",
+		"macos-10154": "macOS 10.15.4",
+		"macos-1015":  "macOS 10.15",
+		"macos-260":   "macOS 26.0",
+	} {
+		release, ok := parseMacOSTag(tagName)
+		if !ok || release != expected {
+			t.Errorf("Unexpected release for %s: '%s', expected '%s'", tagName, release, expected)
+		}
+	}
+
+	for _, tagName := range []string{"macos-14", "macos-10", "ios-174", "macos-14a1", "security_certificates-55286"} {
+		if release, ok := parseMacOSTag(tagName); ok {
+			t.Errorf("Unexpected release '%s' for %s", release, tagName)
+		}
+	}
+}
+
+func TestGetAppleOSReleases(t *testing.T) {
+	releaseJSON := func(tag string) string {
+		return fmt.Sprintf(`{"build":"1A1","projects":[{"project":"Security","tag":"Security-1"},{"project":"security_certificates","tag":"%s"}]}`, tag)
+	}
+	files := map[string]string{
+		"/tags?per_page=100&page=1":   `[{"name":"macos-1441"},{"name":"macos-144"},{"name":"not-a-release"}]`,
+		"/tags?per_page=100&page=2":   `[{"name":"macos-1431"}]`,
+		"/tags?per_page=100&page=3":   `[]`,
+		"/macos-1441/release.json":    releaseJSON("security_certificates-55286.0.1"),
+		"/macos-144/release.json":     releaseJSON("security_certificates-55286.0.1"),
+		"/macos-1431/release.json":    releaseJSON("security_certificates-55274"),
+		"/not-a-release/release.json": releaseJSON("security_certificates-1"),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := files[r.URL.RequestURI()]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(data))
+	}))
+	defer server.Close()
+
+	apiURL, rawURL := appleDistributionAPIURL, appleDistributionRawURL
+	appleDistributionAPIURL, appleDistributionRawURL = server.URL, server.URL
+	defer func() {
+		appleDistributionAPIURL, appleDistributionRawURL = apiURL, rawURL
+	}()
+
+	releases, err := getAppleOSReleases()
+	if err != nil {
+		t.Fatalf("Error getting OS releases: %s", err.Error())
+	}
+	expected := map[string][]string{
+		"security_certificates-55286.0.1": {"macOS 14.4.1", "macOS 14.4"},
+		"security_certificates-55274":     {"macOS 14.3.1"},
+	}
+	if !reflect.DeepEqual(releases, expected) {
+		t.Errorf("Unexpected releases %v", releases)
+	}
+
+	delete(files, "/macos-1431/release.json")
+	if _, err := getAppleOSReleases(); err == nil {
+		t.Errorf("No error seen for missing release.json")
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCertificate is a generated certificate and its private key
+type testCertificate struct {
+	Cert *x509.Certificate
+	Key  *ecdsa.PrivateKey
+}
+
+// generateTestCertificate will generate a certificate with the given common name signed by parent, or a self-signed
+// certificate if parent is nil. If key is nil a new key is generated. The template may set any other fields.
+func generateTestCertificate(t *testing.T, commonName string, template *x509.Certificate, parent *testCertificate, key *ecdsa.PrivateKey) *testCertificate {
+	t.Helper()
+	if key == nil {
+		var err error
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("Error generating key: %s", err.Error())
+		}
+	}
+	if template == nil {
+		template = &x509.Certificate{}
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatalf("Error generating serial: %s", err.Error())
+	}
+	template.SerialNumber = serial
+	template.Subject = pkix.Name{CommonName: commonName}
+	if template.NotBefore.IsZero() {
+		template.NotBefore = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	if template.NotAfter.IsZero() {
+		template.NotAfter = time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+	}
+
+	parentCert, parentKey := template, key
+	if parent != nil {
+		parentCert, parentKey = parent.Cert, parent.Key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("Error creating certificate: %s", err.Error())
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Error parsing certificate: %s", err.Error())
+	}
+	return &testCertificate{Cert: cert, Key: key}
+}
+
+func testAppleRoots(certs ...*testCertificate) *appleRoots {
+	roots := &appleRoots{Roots: map[string]*appleRoot{}}
+	for _, cert := range certs {
+		roots.Roots[fmt.Sprintf("%X", sha256.Sum256(cert.Cert.Raw))] = &appleRoot{DER: cert.Cert.Raw}
+	}
+	return roots
+}
+
+func (r *appleRoots) root(cert *testCertificate) *appleRoot {
+	return r.Roots[fmt.Sprintf("%X", sha256.Sum256(cert.Cert.Raw))]
+}
+
+func TestAppleMarkAllowlisted(t *testing.T) {
+	direct := generateTestCertificate(t, "Direct Root", nil, nil, nil)
+	chained := generateTestCertificate(t, "Chained Root", nil, nil, nil)
+	broken := generateTestCertificate(t, "Broken Root", nil, nil, nil)
+	other := generateTestCertificate(t, "Other Root", nil, nil, nil)
+
+	directLeaf := generateTestCertificate(t, "direct.example.com", nil, direct, nil)
+	intermediate := generateTestCertificate(t, "Intermediate", &x509.Certificate{IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}, chained, nil)
+	chainedLeaf := generateTestCertificate(t, "chained.example.com", nil, intermediate, nil)
+	missingIntermediate := generateTestCertificate(t, "Missing Intermediate", &x509.Certificate{IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}, broken, nil)
+	brokenLeaf := generateTestCertificate(t, "broken.example.com", nil, missingIntermediate, nil)
+
+	roots := testAppleRoots(direct, chained, broken, other)
+	roots.markAllowlisted([][]byte{directLeaf.Cert.Raw, chainedLeaf.Cert.Raw, intermediate.Cert.Raw, brokenLeaf.Cert.Raw, other.Cert.Raw})
+
+	if !roots.root(direct).Allowlisted {
+		t.Errorf("Root of a directly issued leaf is not allowlisted")
+	}
+	if !roots.root(chained).Allowlisted {
+		t.Errorf("Root of a leaf issued by an allowlisted intermediate is not allowlisted")
+	}
+	if roots.root(broken).Allowlisted {
+		t.Errorf("Root of a leaf with a missing intermediate is allowlisted")
+	}
+	if roots.root(other).Allowlisted {
+		t.Errorf("Root in the allowlist is allowlisted")
+	}
+}
+
+func TestAppleMarkConstrained(t *testing.T) {
+	root := generateTestCertificate(t, "Constrained Root", nil, nil, nil)
+	constrained := generateTestCertificate(t, "Constrained Root", &x509.Certificate{
+		PermittedDNSDomains: []string{"example.gov", ".example.gov"},
+		ExcludedDNSDomains:  []string{"private.example.gov"},
+	}, nil, root.Key)
+	unconstrained := generateTestCertificate(t, "Unconstrained Root", nil, nil, nil)
+	unknown := generateTestCertificate(t, "Unknown Root", nil, nil, nil)
+
+	roots := testAppleRoots(root, unconstrained)
+	if err := roots.markConstrained([][]byte{constrained.Cert.Raw, unknown.Cert.Raw}); err != nil {
+		t.Fatalf("Error marking constrained roots: %s", err.Error())
+	}
+
+	constrainedRoot := roots.root(root)
+	if !constrainedRoot.Constrained {
+		t.Fatalf("Root with a constrained variant is not constrained")
+	}
+	if !reflect.DeepEqual(constrainedRoot.PermittedDNSNames, []string{"example.gov", ".example.gov"}) {
+		t.Errorf("Unexpected permitted names %v", constrainedRoot.PermittedDNSNames)
+	}
+	if !reflect.DeepEqual(constrainedRoot.ExcludedDNSNames, []string{"private.example.gov"}) {
+		t.Errorf("Unexpected excluded names %v", constrainedRoot.ExcludedDNSNames)
+	}
+	if roots.root(unconstrained).Constrained {
+		t.Errorf("Unrelated root is constrained")
+	}
+
+	if err := roots.markConstrained([][]byte{[]byte("not a certificate")}); err == nil {
+		t.Errorf("No error seen for invalid certificate")
+	}
+}
+
+func TestAppleFetchDownloadsTarballOnce(t *testing.T) {
+	trusted := generateTestCertificate(t, "Trusted Root", nil, nil, nil)
+	distrusted := generateTestCertificate(t, "Distrusted Root", nil, nil, nil)
+	certificateFile := func(cert *testCertificate) string {
+		return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Cert.Raw}))
+	}
+	tarball := buildTarball(t, []testTarEntry{
+		{name: "security_certificates-abc/certificates/roots/trusted.cer", data: certificateFile(trusted)},
+		{name: "security_certificates-abc/certificates/roots/distrusted.cer", data: certificateFile(distrusted)},
+		{name: "security_certificates-abc/certificates/distrusted/distrusted.cer", data: certificateFile(distrusted)},
+		{name: "security_certificates-abc/certificates/evroot.config", data: ""},
+	})
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write(tarball)
+	}))
+	defer server.Close()
+	tarballURL := server.URL + "/tarball/abc"
+
+	for _, test := range []struct {
+		vendor   Vendor
+		expected []*testCertificate
+	}{
+		{vendor: appleAllRootsVendor{}, expected: []*testCertificate{trusted, distrusted}},
+		{vendor: appleVendor{}, expected: []*testCertificate{trusted}},
+	} {
+		certPaths, err := test.vendor.Fetch(&VendorVersion{Key: "abc", Extra: tarballURL}, ".", t.TempDir())
+		if err != nil {
+			t.Fatalf("%s: error fetching certificates: %s", test.vendor.ID(), err.Error())
+		}
+		fingerprints := []string{}
+		for _, certPath := range certPaths {
+			fingerprints = append(fingerprints, strings.TrimSuffix(path.Base(certPath), ".crt"))
+		}
+		expected := []string{}
+		for _, cert := range test.expected {
+			expected = append(expected, testCertSHA(cert))
+		}
+		sort.Strings(fingerprints)
+		sort.Strings(expected)
+		if !reflect.DeepEqual(fingerprints, expected) {
+			t.Errorf("%s: unexpected certificates %v", test.vendor.ID(), fingerprints)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("Expected 1 request, got %d", requests)
+	}
+}
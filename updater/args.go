@@ -1,16 +1,34 @@
 package main
 
 import (
+	"crypto/x509"
 	"encoding/base64"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
+	"path/filepath"
+
+	"github.com/tls-inspector/rootca/updater/rootca"
 )
 
 var forceUpdate = false
-var opensslPath = ""
-var cabextractPath = ""
+var lastGoodInputs = false
+var dryRun = false
+var diffMode = false
+var diffSources []string
+var diffSigningKey []byte
+var jsonOutput = false
+var noLegacyMetadata = false
+var mozillaSource = mozillaSourceCurl
+var nssTag = ""
+var appleReleases = false
+var appleTagRange = ""
+var chromeVersions = false
+var chromeRefs []string
+var openJDKTag = ""
+var androidTag = ""
+var euLOTLSigners []*x509.Certificate
+var policyFile = ""
 var workdir = "bundles"
 var publicKeyBytes []byte
 var privateKeyBytes []byte
@@ -51,62 +69,181 @@ func parseArgs() {
 				}
 				privateKeyBytes = b
 				i++
-			case "--openssl-path":
+			case "--signing-key":
 				if len(args)-1 == i {
 					fmt.Fprintf(os.Stderr, "Arg %s requires a value\n", arg)
 					os.Exit(1)
 				}
-				opensslPath = args[i+1]
+				b, err := os.ReadFile(args[i+1])
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error reading signing key file %s: %s", args[i+1], err.Error())
+					os.Exit(1)
+				}
+				if _, err := rootca.ParsePublicKey(b); err != nil {
+					fmt.Fprintf(os.Stderr, "Invalid signing key file %s: %s\n", args[i+1], err.Error())
+					os.Exit(1)
+				}
+				diffSigningKey = b
 				i++
-			case "--cabextract-path":
+			case "--force-update":
+				forceUpdate = true
+			case "--last-good-inputs":
+				lastGoodInputs = true
+			case "--dry-run":
+				dryRun = true
+			case "--json":
+				jsonOutput = true
+			case "--mozilla-source":
 				if len(args)-1 == i {
 					fmt.Fprintf(os.Stderr, "Arg %s requires a value\n", arg)
 					os.Exit(1)
 				}
-				cabextractPath = args[i+1]
+				mozillaSource = args[i+1]
+				if mozillaSource != mozillaSourceCurl && mozillaSource != mozillaSourceNSS {
+					fmt.Fprintf(os.Stderr, "Unknown Mozilla source %s\n", mozillaSource)
+					os.Exit(1)
+				}
 				i++
-			case "--force-update":
-				forceUpdate = true
+			case "--nss-tag":
+				if len(args)-1 == i {
+					fmt.Fprintf(os.Stderr, "Arg %s requires a value\n", arg)
+					os.Exit(1)
+				}
+				nssTag = args[i+1]
+				i++
+			case "--apple-releases":
+				appleReleases = true
+			case "--apple-tag-range":
+				if len(args)-1 == i {
+					fmt.Fprintf(os.Stderr, "Arg %s requires a value\n", arg)
+					os.Exit(1)
+				}
+				appleTagRange = args[i+1]
+				i++
+			case "--chrome-versions":
+				chromeVersions = true
+			case "--chrome-ref":
+				if len(args)-1 == i {
+					fmt.Fprintf(os.Stderr, "Arg %s requires a value\n", arg)
+					os.Exit(1)
+				}
+				chromeRefs = append(chromeRefs, args[i+1])
+				i++
+			case "--openjdk-tag":
+				if len(args)-1 == i {
+					fmt.Fprintf(os.Stderr, "Arg %s requires a value\n", arg)
+					os.Exit(1)
+				}
+				openJDKTag = args[i+1]
+				i++
+			case "--android-tag":
+				if len(args)-1 == i {
+					fmt.Fprintf(os.Stderr, "Arg %s requires a value\n", arg)
+					os.Exit(1)
+				}
+				androidTag = args[i+1]
+				i++
+			case "--eu-lotl-signers":
+				if len(args)-1 == i {
+					fmt.Fprintf(os.Stderr, "Arg %s requires a value\n", arg)
+					os.Exit(1)
+				}
+				b, err := os.ReadFile(args[i+1])
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error reading EU LOTL signers file %s: %s", args[i+1], err.Error())
+					os.Exit(1)
+				}
+				certificates, err := rootca.ParsePEMCertificates(b)
+				if err != nil || len(certificates) == 0 {
+					fmt.Fprintf(os.Stderr, "Invalid EU LOTL signers file %s\n", args[i+1])
+					os.Exit(1)
+				}
+				euLOTLSigners = certificates
+				i++
+			case "--policies":
+				if len(args)-1 == i {
+					fmt.Fprintf(os.Stderr, "Arg %s requires a value\n", arg)
+					os.Exit(1)
+				}
+				// The workdir becomes the current directory before policies are loaded
+				p, err := filepath.Abs(args[i+1])
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Invalid policies file %s: %s\n", args[i+1], err.Error())
+					os.Exit(1)
+				}
+				policyFile = p
+				i++
+			case "--no-legacy-metadata":
+				noLegacyMetadata = true
 			case "--help":
-				fmt.Printf(`Usage %s [options] [workdir]
+				fmt.Printf(`Usage %s [plan] [options] [workdir]
 
 Workdir: The directory where the bundles will be saved. Defaults to "bundles". Will create the directory if it does not exist.
 
+Plan: Report which bundles would be updated and which certificates would be added or removed, without modifying any files in the workdir. Same as --dry-run.
+
+Usage %s diff [--json] [--signing-key <path>] <old> <new>
+
+Diff: Report the certificates added or removed between two sets of bundles. Each may be a bundles directory or the name of a release.
+
 Options:
  --public-key-path   Optionally specify a path to a PEM-encoded signing public key.
  --private-key-path  Optionally specify a path to a PEM-encoded signing private key.
- --openssl-path      Optionally specify the path to openssl executable. Defaults to looking in $PATH.
- --cabextract-path   Optionally specify the path to cabextract executable. Defaults to looking in $PATH.
  --force-update      Forcefully trigger an update of all bundles. By default bundles will only be updated if changes are detected.
+ --dry-run           Report changes without modifying any files in the workdir.
+ --json              Print the output of diff as JSON.
+ --signing-key       Optionally specify a path to the PEM-encoded public key used to verify the signatures of both sets of bundles in diff. By default the signing_key.pem included with each set is used, which only detects corruption and not a compromised source.
+ --last-good-inputs  Build derived bundles using the last-known-good bundle of any input vendor that failed. By default derived bundles are skipped if any input failed.
+ --no-legacy-metadata Do not write the version 1 bundle_metadata.json file. Only bundle_metadata_v2.json will be written.
+ --mozilla-source    Where to get the Mozilla certificates from, either "curl" (the default) or "nss". The nss source reads certdata.txt directly and records the trust of each certificate in the metadata.
+ --nss-tag           Pin the nss Mozilla source to a specific NSS release tag, such as NSS_3_110_RTM. By default the latest certdata.txt is used.
+ --apple-releases    Also build an Apple bundle for each security_certificates tag, recording the OS releases that shipped it.
+ --apple-tag-range   Limit --apple-releases to an inclusive range of tags, such as 55188..55286.0.1. Either end may be omitted.
+ --chrome-versions   Also build a Google bundle for every version_major of the Chrome Root Store on Chromium main.
+ --chrome-ref        Also build a Google bundle for the Chrome Root Store at a Chromium tag or branch, such as 124.0.6367.60. May be repeated.
+ --openjdk-tag       Pin the OpenJDK bundle to a specific release tag, such as jdk-21-ga. By default the latest general availability release is used.
+ --android-tag       Pin the Android bundle to a specific platform release tag, such as android-14.0.0_r1. By default the latest platform release is used.
+ --eu-lotl-signers   Optionally specify a path to the PEM-encoded certificates that may sign the EU list of trusted lists, as published in the Official Journal of the EU. By default the certificates in eu_lotl_signers.pem are used.
+ --policies          Optionally specify a path to a bundle policy configuration file defining the derived bundles to build. By default only the TLSInspector bundle is built.
 
 Environment Variables:
  %s   Specify the public key PEM contents. Escape newlines with double backslashes.
  %s   Specify the private key PEM contents. Escape newlines with double backslaces.
  %s   Specify a Github access token used for read-only API requests.
-`, os.Args[0], envSigningPubKey, envSigningPrivKey, envGithubAccessToken)
+`, os.Args[0], os.Args[0], envSigningPubKey, envSigningPrivKey, envGithubAccessToken)
 				os.Exit(0)
 			default:
 				fmt.Fprintf(os.Stderr, "Unknown argument %s\n", arg)
 				os.Exit(1)
 			}
+		} else if arg == "plan" && i == 1 {
+			dryRun = true
+		} else if arg == "diff" && i == 1 {
+			diffMode = true
+		} else if diffMode {
+			diffSources = append(diffSources, arg)
 		} else {
 			workdir = arg
 		}
 	}
 
-	if opensslPath == "" {
-		openssl, err := exec.LookPath("openssl")
-		if err != nil {
-			log.Fatalf("Cannot find openssl in PATH")
-		}
-		opensslPath = openssl
+	if nssTag != "" && mozillaSource != mozillaSourceNSS {
+		fmt.Fprintf(os.Stderr, "--nss-tag requires --mozilla-source nss\n")
+		os.Exit(1)
 	}
-	if cabextractPath == "" {
-		cabextract, err := exec.LookPath("cabextract")
-		if err != nil {
-			log.Fatalf("Cannot find cabextract in PATH")
-		}
-		cabextractPath = cabextract
+
+	if appleTagRange != "" && !appleReleases {
+		fmt.Fprintf(os.Stderr, "--apple-tag-range requires --apple-releases\n")
+		os.Exit(1)
+	}
+	if _, _, err := parseAppleTagRange(appleTagRange); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		os.Exit(1)
+	}
+
+	if diffMode && len(diffSources) != 2 {
+		fmt.Fprintf(os.Stderr, "diff requires exactly two bundle directories or releases\n")
+		os.Exit(1)
 	}
 
 	if len(publicKeyBytes) == 0 && os.Getenv(envSigningPubKey) != "" {
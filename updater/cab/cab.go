@@ -0,0 +1,298 @@
+// Package cab provides a reader for Microsoft Cabinet (MS-CAB) archives, such as those distributed by Windows Update.
+// Only uncompressed and MSZIP compressed folders are supported, and cabinets that span multiple files are not
+// supported. Data block checksums are not verified, so the contents of the cabinet should be verified by other means.
+package cab
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	flagPrevCabinet    = 0x0001
+	flagNextCabinet    = 0x0002
+	flagReservePresent = 0x0004
+)
+
+const (
+	compressionNone  = 0
+	compressionMSZIP = 1
+	compressionMask  = 0x000F
+)
+
+// maxBlockSize is the maximum uncompressed size of a single data block
+const maxBlockSize = 32768
+
+// File describes a single file within a cabinet
+type File struct {
+	// The name of the file, which may include a path using backslashes
+	Name string
+	// The uncompressed size of the file in bytes
+	Size uint32
+	// The modification date of the file in local time
+	Modified time.Time
+
+	folder int
+	offset uint32
+}
+
+// Cabinet is a parsed cabinet archive
+type Cabinet struct {
+	// All files within the cabinet
+	Files []File
+
+	data    []byte
+	folders []folder
+}
+
+type folder struct {
+	dataOffset   uint32
+	numBlocks    uint16
+	compression  uint16
+	reserveBytes uint8
+}
+
+// Parse will parse the headers of the given cabinet archive. Files are not decompressed until they are read.
+func Parse(data []byte) (*Cabinet, error) {
+	r := &reader{data: data}
+
+	if signature := r.bytes(4); !bytes.Equal(signature, []byte("MSCF")) {
+		return nil, fmt.Errorf("cab: invalid signature")
+	}
+	r.skip(4) // reserved1
+	cabinetSize := r.uint32()
+	r.skip(4) // reserved2
+	filesOffset := r.uint32()
+	r.skip(4) // reserved3
+	versionMinor := r.uint8()
+	versionMajor := r.uint8()
+	numFolders := r.uint16()
+	numFiles := r.uint16()
+	flags := r.uint16()
+	r.skip(2) // setID
+	r.skip(2) // iCabinet
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	if versionMajor != 1 || versionMinor != 3 {
+		return nil, fmt.Errorf("cab: unsupported version %d.%d", versionMajor, versionMinor)
+	}
+	if int(cabinetSize) > len(data) {
+		return nil, fmt.Errorf("cab: cabinet size %d exceeds data size %d", cabinetSize, len(data))
+	}
+	if flags&(flagPrevCabinet|flagNextCabinet) != 0 {
+		return nil, fmt.Errorf("cab: multi-cabinet archives are not supported")
+	}
+
+	var folderReserve, dataReserve uint8
+	if flags&flagReservePresent != 0 {
+		headerReserve := r.uint16()
+		folderReserve = r.uint8()
+		dataReserve = r.uint8()
+		r.skip(int(headerReserve))
+	}
+
+	cabinet := &Cabinet{
+		data:    data,
+		folders: make([]folder, numFolders),
+		Files:   make([]File, numFiles),
+	}
+
+	for i := range cabinet.folders {
+		cabinet.folders[i] = folder{
+			dataOffset:   r.uint32(),
+			numBlocks:    r.uint16(),
+			compression:  r.uint16(),
+			reserveBytes: dataReserve,
+		}
+		r.skip(int(folderReserve))
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	r.offset = int(filesOffset)
+	for i := range cabinet.Files {
+		size := r.uint32()
+		offset := r.uint32()
+		folderIdx := r.uint16()
+		date := r.uint16()
+		tm := r.uint16()
+		r.skip(2) // attribs
+		name := r.string()
+		if r.err != nil {
+			return nil, r.err
+		}
+		if int(folderIdx) >= len(cabinet.folders) {
+			return nil, fmt.Errorf("cab: file %s references unsupported folder %d", name, folderIdx)
+		}
+
+		cabinet.Files[i] = File{
+			Name:     name,
+			Size:     size,
+			Modified: dosDateTime(date, tm),
+			folder:   int(folderIdx),
+			offset:   offset,
+		}
+	}
+
+	return cabinet, nil
+}
+
+// ReadFile will return the uncompressed contents of the file with the given name
+func (c *Cabinet) ReadFile(name string) ([]byte, error) {
+	for _, file := range c.Files {
+		if file.Name != name {
+			continue
+		}
+
+		folderData, err := c.readFolder(c.folders[file.folder])
+		if err != nil {
+			return nil, err
+		}
+		end := uint64(file.offset) + uint64(file.Size)
+		if end > uint64(len(folderData)) {
+			return nil, fmt.Errorf("cab: file %s exceeds folder size", name)
+		}
+		return folderData[file.offset:end], nil
+	}
+
+	return nil, fmt.Errorf("cab: no file named %s", name)
+}
+
+// readFolder will return the uncompressed contents of all data blocks in the folder
+func (c *Cabinet) readFolder(f folder) ([]byte, error) {
+	r := &reader{data: c.data, offset: int(f.dataOffset)}
+	compression := f.compression & compressionMask
+	if compression != compressionNone && compression != compressionMSZIP {
+		return nil, fmt.Errorf("cab: unsupported compression type %d", compression)
+	}
+
+	output := []byte{}
+	for i := 0; i < int(f.numBlocks); i++ {
+		r.skip(4) // checksum
+		compressedSize := r.uint16()
+		uncompressedSize := r.uint16()
+		r.skip(int(f.reserveBytes))
+		blockData := r.bytes(int(compressedSize))
+		if r.err != nil {
+			return nil, r.err
+		}
+		if uncompressedSize > maxBlockSize {
+			return nil, fmt.Errorf("cab: block %d too large", i)
+		}
+
+		var block []byte
+		switch compression {
+		case compressionNone:
+			block = blockData
+		case compressionMSZIP:
+			// Each MSZIP block is an independent deflate stream prefixed with "CK", however the history window of
+			// the previous block is used as the dictionary for the next.
+			if len(blockData) < 2 || blockData[0] != 'C' || blockData[1] != 'K' {
+				return nil, fmt.Errorf("cab: invalid mszip block %d", i)
+			}
+			dict := output
+			if len(dict) > maxBlockSize {
+				dict = dict[len(dict)-maxBlockSize:]
+			}
+			inflater := flate.NewReaderDict(bytes.NewReader(blockData[2:]), dict)
+			decompressed, err := io.ReadAll(io.LimitReader(inflater, maxBlockSize+1))
+			inflater.Close()
+			if err != nil {
+				return nil, fmt.Errorf("cab: invalid mszip block %d: %s", i, err.Error())
+			}
+			block = decompressed
+		}
+		if len(block) != int(uncompressedSize) {
+			return nil, fmt.Errorf("cab: block %d decompressed to %d bytes, expected %d", i, len(block), uncompressedSize)
+		}
+		output = append(output, block...)
+	}
+
+	return output, nil
+}
+
+func dosDateTime(date, tm uint16) time.Time {
+	return time.Date(
+		int(date>>9)+1980,
+		time.Month((date>>5)&0xF),
+		int(date&0x1F),
+		int(tm>>11),
+		int((tm>>5)&0x3F),
+		int(tm&0x1F)*2,
+		0,
+		time.Local,
+	)
+}
+
+// reader is a little-endian reader that records the first error encountered
+type reader struct {
+	data   []byte
+	offset int
+	err    error
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || r.offset+n > len(r.data) {
+		r.err = fmt.Errorf("cab: unexpected end of data")
+		return nil
+	}
+	b := r.data[r.offset : r.offset+n]
+	r.offset += n
+	return b
+}
+
+func (r *reader) skip(n int) {
+	r.bytes(n)
+}
+
+func (r *reader) uint8() uint8 {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *reader) uint16() uint16 {
+	b := r.bytes(2)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(b)
+}
+
+func (r *reader) uint32() uint32 {
+	b := r.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (r *reader) string() string {
+	if r.err != nil {
+		return ""
+	}
+	if r.offset > len(r.data) {
+		r.err = fmt.Errorf("cab: unexpected end of data")
+		return ""
+	}
+	end := bytes.IndexByte(r.data[r.offset:], 0)
+	if end == -1 {
+		r.err = fmt.Errorf("cab: unterminated string")
+		return ""
+	}
+	s := string(r.data[r.offset : r.offset+end])
+	r.offset += end + 1
+	return s
+}
@@ -0,0 +1,191 @@
+package cab
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+type testFile struct {
+	name string
+	data []byte
+}
+
+// buildCabinet will build a single folder cabinet containing the given files, split into data blocks of blockSize
+// bytes, and compressed with MSZIP if compress is true
+func buildCabinet(t *testing.T, files []testFile, blockSize int, compress bool) []byte {
+	folderData := []byte{}
+	for _, file := range files {
+		folderData = append(folderData, file.data...)
+	}
+
+	blocks := &bytes.Buffer{}
+	numBlocks := 0
+	for offset := 0; offset < len(folderData); offset += blockSize {
+		end := min(offset+blockSize, len(folderData))
+		block := folderData[offset:end]
+		blockData := block
+		if compress {
+			dict := folderData[max(0, offset-maxBlockSize):offset]
+			compressed := &bytes.Buffer{}
+			compressed.WriteString("CK")
+			w, err := flate.NewWriterDict(compressed, flate.BestCompression, dict)
+			if err != nil {
+				t.Fatalf("Error creating compressor: %s", err.Error())
+			}
+			w.Write(block)
+			w.Close()
+			blockData = compressed.Bytes()
+		}
+		binary.Write(blocks, binary.LittleEndian, uint32(0))
+		binary.Write(blocks, binary.LittleEndian, uint16(len(blockData)))
+		binary.Write(blocks, binary.LittleEndian, uint16(len(block)))
+		blocks.Write(blockData)
+		numBlocks++
+	}
+
+	fileEntries := &bytes.Buffer{}
+	offset := 0
+	for _, file := range files {
+		binary.Write(fileEntries, binary.LittleEndian, uint32(len(file.data)))
+		binary.Write(fileEntries, binary.LittleEndian, uint32(offset))
+		binary.Write(fileEntries, binary.LittleEndian, uint16(0))
+		binary.Write(fileEntries, binary.LittleEndian, uint16((2024-1980)<<9|5<<5|30)) // 2024-05-30
+		binary.Write(fileEntries, binary.LittleEndian, uint16(15<<11|58<<5|3))         // 15:58:06
+		binary.Write(fileEntries, binary.LittleEndian, uint16(0))
+		fileEntries.WriteString(file.name)
+		fileEntries.WriteByte(0)
+		offset += len(file.data)
+	}
+
+	const headerSize = 36
+	const folderSize = 8
+	filesOffset := headerSize + folderSize
+	dataOffset := filesOffset + fileEntries.Len()
+	cabinetSize := dataOffset + blocks.Len()
+
+	compression := uint16(compressionNone)
+	if compress {
+		compression = compressionMSZIP
+	}
+
+	cabinet := &bytes.Buffer{}
+	cabinet.WriteString("MSCF")
+	binary.Write(cabinet, binary.LittleEndian, uint32(0))
+	binary.Write(cabinet, binary.LittleEndian, uint32(cabinetSize))
+	binary.Write(cabinet, binary.LittleEndian, uint32(0))
+	binary.Write(cabinet, binary.LittleEndian, uint32(filesOffset))
+	binary.Write(cabinet, binary.LittleEndian, uint32(0))
+	cabinet.Write([]byte{3, 1})
+	binary.Write(cabinet, binary.LittleEndian, uint16(1))
+	binary.Write(cabinet, binary.LittleEndian, uint16(len(files)))
+	binary.Write(cabinet, binary.LittleEndian, uint16(0))
+	binary.Write(cabinet, binary.LittleEndian, uint16(0))
+	binary.Write(cabinet, binary.LittleEndian, uint16(0))
+	binary.Write(cabinet, binary.LittleEndian, uint32(dataOffset))
+	binary.Write(cabinet, binary.LittleEndian, uint16(numBlocks))
+	binary.Write(cabinet, binary.LittleEndian, compression)
+	cabinet.Write(fileEntries.Bytes())
+	cabinet.Write(blocks.Bytes())
+	return cabinet.Bytes()
+}
+
+func testFiles() []testFile {
+	return []testFile{
+		{name: "authroot.stl", data: bytes.Repeat([]byte("certificate trust list "), 3000)},
+		{name: "dir\\readme.txt", data: []byte("hello world")},
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		blockSize int
+		compress  bool
+	}{
+		{name: "uncompressed", blockSize: maxBlockSize, compress: false},
+		{name: "uncompressed multiple blocks", blockSize: 1000, compress: false},
+		{name: "mszip", blockSize: maxBlockSize, compress: true},
+		{name: "mszip multiple blocks", blockSize: 1000, compress: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			files := testFiles()
+			cabinet, err := Parse(buildCabinet(t, files, test.blockSize, test.compress))
+			if err != nil {
+				t.Fatalf("Error parsing cabinet: %s", err.Error())
+			}
+			if len(cabinet.Files) != len(files) {
+				t.Fatalf("Expected %d files, got %d", len(files), len(cabinet.Files))
+			}
+			expectedDate := time.Date(2024, 5, 30, 15, 58, 6, 0, time.Local)
+			for i, file := range files {
+				if cabinet.Files[i].Name != file.name {
+					t.Errorf("Expected file name %s, got %s", file.name, cabinet.Files[i].Name)
+				}
+				if !cabinet.Files[i].Modified.Equal(expectedDate) {
+					t.Errorf("Expected modified date %s, got %s", expectedDate, cabinet.Files[i].Modified)
+				}
+				data, err := cabinet.ReadFile(file.name)
+				if err != nil {
+					t.Fatalf("Error reading %s: %s", file.name, err.Error())
+				}
+				if !bytes.Equal(data, file.data) {
+					t.Errorf("Incorrect contents of %s", file.name)
+				}
+			}
+		})
+	}
+}
+
+func TestReadFileMissing(t *testing.T) {
+	cabinet, err := Parse(buildCabinet(t, testFiles(), maxBlockSize, false))
+	if err != nil {
+		t.Fatalf("Error parsing cabinet: %s", err.Error())
+	}
+	if _, err := cabinet.ReadFile("missing.txt"); err == nil {
+		t.Errorf("No error seen for missing file")
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	valid := buildCabinet(t, testFiles(), maxBlockSize, false)
+
+	multiCabinet := bytes.Clone(valid)
+	binary.LittleEndian.PutUint16(multiCabinet[30:], flagNextCabinet)
+
+	badVersion := bytes.Clone(valid)
+	badVersion[25] = 2
+
+	for _, test := range []struct {
+		name string
+		data []byte
+	}{
+		{name: "signature", data: append([]byte("XXXX"), valid[4:]...)},
+		{name: "truncated", data: valid[:20]},
+		{name: "cabinet size", data: valid[:len(valid)-1]},
+		{name: "multiple cabinets", data: multiCabinet},
+		{name: "version", data: badVersion},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := Parse(test.data); err == nil {
+				t.Errorf("No error seen for invalid cabinet")
+			}
+		})
+	}
+}
+
+func TestReadFileCorruptBlock(t *testing.T) {
+	data := buildCabinet(t, testFiles(), maxBlockSize, true)
+	cabinet, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Error parsing cabinet: %s", err.Error())
+	}
+	// Replace the "CK" signature of the first block
+	dataOffset := binary.LittleEndian.Uint32(data[36:])
+	data[dataOffset+8] = 'X'
+	if _, err := cabinet.ReadFile("authroot.stl"); err == nil {
+		t.Errorf("No error seen for corrupt block")
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tls-inspector/rootca/updater/rootca"
+)
+
+const changelogJSONName = "changelog.json"
+const changelogMarkdownName = "CHANGES.md"
+
+// changelog describes the changes made to all bundles in a single run
+type changelog struct {
+	Date    string                     `json:"date"`
+	Vendors map[string]vendorChangelog `json:"vendors"`
+}
+
+// vendorChangelog describes the changes made to a single vendors bundle
+type vendorChangelog struct {
+	Name    string `json:"name"`
+	LastKey string `json:"last_key"`
+	Key     string `json:"key"`
+	bundleDiff
+}
+
+// HasChanges returns true if any vendor had certificates added or removed
+func (c changelog) HasChanges() bool {
+	for _, vendor := range c.Vendors {
+		if vendor.HasChanges() {
+			return true
+		}
+	}
+	return false
+}
+
+// readVendorCertificates will read the certificates from the current bundle of every vendor
+func readVendorCertificates() (map[string][]*x509.Certificate, error) {
+	certs := map[string][]*x509.Certificate{}
+	for _, vendor := range vendors {
+		vendorCerts, err := readBundleCertificates(vendor.BundleName() + ".p7b")
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", vendor.ID(), err.Error())
+		}
+		certs[vendor.ID()] = vendorCerts
+	}
+	return certs, nil
+}
+
+// changelogFromResults will generate a changelog for all updated vendors by comparing the given certificates from
+// before the update with the current bundles
+func changelogFromResults(results []vendorResult, oldMetadata, newMetadata BundleMetadata, oldCerts map[string][]*x509.Certificate) (*changelog, error) {
+	c := &changelog{
+		Date:    time.Now().UTC().Format("2006-01-02T15:04:05Z07:00"),
+		Vendors: map[string]vendorChangelog{},
+	}
+
+	for _, result := range results {
+		if result.Status != vendorUpdated {
+			continue
+		}
+		vendor := result.Vendor
+		newCerts, err := readBundleCertificates(vendor.BundleName() + ".p7b")
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", vendor.ID(), err.Error())
+		}
+		c.Vendors[vendor.ID()] = vendorChangelog{
+			Name:       vendor.Name(),
+			LastKey:    oldMetadata[vendor.ID()].Key,
+			Key:        newMetadata[vendor.ID()].Key,
+			bundleDiff: diffCertificates(oldCerts[vendor.ID()], newCerts),
+		}
+	}
+
+	return c, nil
+}
+
+// changelogFromBundles will generate a changelog describing the differences between two sets of published bundles
+func changelogFromBundles(oldBundles, newBundles *rootca.Bundles) changelog {
+	c := changelog{
+		Date:    time.Now().UTC().Format("2006-01-02T15:04:05Z07:00"),
+		Vendors: map[string]vendorChangelog{},
+	}
+
+	vendorIDs := map[string]bool{}
+	for id := range oldBundles.Vendors {
+		vendorIDs[id] = true
+	}
+	for id := range newBundles.Vendors {
+		vendorIDs[id] = true
+	}
+
+	for id := range vendorIDs {
+		var oldCerts, newCerts []*x509.Certificate
+		vendorChanges := vendorChangelog{Name: id}
+		if oldVendor, ok := oldBundles.Vendors[id]; ok {
+			oldCerts = oldVendor.Certificates
+			vendorChanges.LastKey = oldVendor.Metadata.Key
+		}
+		if newVendor, ok := newBundles.Vendors[id]; ok {
+			newCerts = newVendor.Certificates
+			vendorChanges.Key = newVendor.Metadata.Key
+		}
+		if vendor := getVendor(id); vendor != nil {
+			vendorChanges.Name = vendor.Name()
+		}
+		vendorChanges.bundleDiff = diffCertificates(oldCerts, newCerts)
+		if !vendorChanges.HasChanges() {
+			continue
+		}
+		c.Vendors[id] = vendorChanges
+	}
+
+	return c
+}
+
+// Markdown returns a human readable description of the changelog
+func (c changelog) Markdown() string {
+	vendorIDs := make([]string, 0, len(c.Vendors))
+	for id := range c.Vendors {
+		vendorIDs = append(vendorIDs, id)
+	}
+	sort.Strings(vendorIDs)
+
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "## %s\n", c.Date)
+	for _, id := range vendorIDs {
+		vendor := c.Vendors[id]
+		fmt.Fprintf(b, "\n### %s\n\n", vendor.Name)
+		if !vendor.HasChanges() {
+			fmt.Fprintf(b, "No certificates added or removed.\n")
+			continue
+		}
+		for _, cert := range vendor.Added {
+			fmt.Fprintf(b, "- Added `%s` %s (expires %s)\n", cert.SHA256, cert.Subject, cert.NotAfter.Format("2006-01-02"))
+		}
+		for _, cert := range vendor.Removed {
+			fmt.Fprintf(b, "- Removed `%s` %s (expires %s)\n", cert.SHA256, cert.Subject, cert.NotAfter.Format("2006-01-02"))
+		}
+	}
+	return b.String()
+}
+
+// writeChangelog will write the changelog JSON file and add the changelog to the top of the markdown file
+func writeChangelog(c *changelog) error {
+	changelogJSON, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	const markdownHeader = "# Changes\n\n"
+	existing, err := os.ReadFile(changelogMarkdownName)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	existing = bytes.TrimPrefix(existing, []byte(markdownHeader))
+
+	markdown := []byte(markdownHeader + c.Markdown())
+	if len(existing) > 0 {
+		markdown = append(markdown, '\n')
+		markdown = append(markdown, existing...)
+	}
+
+	return writeSignedFiles(map[string][]byte{
+		changelogJSONName:     append(changelogJSON, '\n'),
+		changelogMarkdownName: markdown,
+	})
+}
+
+// How the signatures of a set of bundles were verified when comparing them
+const (
+	diffVerifiedPinnedKey = "pinned_key"
+	diffVerifiedSourceKey = "source_key"
+	diffVerifiedNone      = "none"
+)
+
+// bundlesDiff is the output of the diff command
+type bundlesDiff struct {
+	changelog
+	// How the signatures of each source were verified, keyed by source
+	Verification map[string]string `json:"verification"`
+}
+
+// Markdown returns a human readable description of the changes, followed by a note for every source whose signatures
+// were not verified with a pinned key
+func (d bundlesDiff) Markdown() string {
+	sources := make([]string, 0, len(d.Verification))
+	for source := range d.Verification {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	b := &strings.Builder{}
+	b.WriteString(d.changelog.Markdown())
+	for _, source := range sources {
+		switch d.Verification[source] {
+		case diffVerifiedSourceKey:
+			fmt.Fprintf(b, "\n> Signatures of %s were verified with the signing key included in %s, which only detects corruption. Use --signing-key to verify with a pinned key.\n", source, source)
+		case diffVerifiedNone:
+			fmt.Fprintf(b, "\n> Signatures of %s were not verified, as it does not include a signing key.\n", source)
+		}
+	}
+	return b.String()
+}
+
+// diffBundles will print the differences between two sets of published bundles. Each source is either a path to a
+// bundles directory or the name of a release. If signingKey is set, the signatures of both sources are verified with
+// it, otherwise each source is verified with its own signing key.
+func diffBundles(oldSource, newSource string, signingKey []byte, asJSON bool) error {
+	d := bundlesDiff{Verification: map[string]string{}}
+	oldBundles, verification, err := loadBundlesForDiff(oldSource, signingKey)
+	if err != nil {
+		return fmt.Errorf("%s: %s", oldSource, err.Error())
+	}
+	d.Verification[oldSource] = verification
+	newBundles, verification, err := loadBundlesForDiff(newSource, signingKey)
+	if err != nil {
+		return fmt.Errorf("%s: %s", newSource, err.Error())
+	}
+	d.Verification[newSource] = verification
+
+	d.changelog = changelogFromBundles(oldBundles, newBundles)
+	if asJSON {
+		j := json.NewEncoder(os.Stdout)
+		j.SetIndent("", "  ")
+		return j.Encode(d)
+	}
+	fmt.Print(d.Markdown())
+	return nil
+}
+
+// loadBundlesForDiff will load and verify the bundles from the given source, returning how the signatures were
+// verified
+func loadBundlesForDiff(source string, signingKey []byte) (*rootca.Bundles, string, error) {
+	var src rootca.Source
+	if info, err := os.Stat(source); err == nil && info.IsDir() {
+		src = rootca.DirSource(source)
+	} else {
+		releaseSource := rootca.ReleaseSource(source)
+		releaseSource.UserAgent = fmt.Sprintf("rootca/%s (github.com/tlsinspector/rootca)", Version)
+		src = releaseSource
+	}
+
+	// Signatures are always verified with a pinned key. Otherwise they are verified whenever the source includes a
+	// signing key, such as with releases, though a key from the same source cannot detect a compromised source.
+	// Fingerprints are always verified.
+	options := &rootca.Options{PublicKey: signingKey}
+	verification := diffVerifiedPinnedKey
+	if len(signingKey) == 0 {
+		verification = diffVerifiedSourceKey
+		if _, err := src.ReadFile(rootca.SigningKeyFileName); err != nil {
+			logWarning("No signing key found for %s, signatures will not be verified", source)
+			options.SkipSignatures = true
+			verification = diffVerifiedNone
+		} else {
+			logWarning("Verifying signatures of %s with its own signing key", source)
+		}
+	}
+	bundles, err := rootca.Load(src, options)
+	if err != nil {
+		return nil, "", err
+	}
+	return bundles, verification, nil
+}
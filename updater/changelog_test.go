@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tls-inspector/rootca/updater/rootca"
+)
+
+func TestDiffCertificates(t *testing.T) {
+	removed := generateTestCertificate(t, "Removed Root", &x509.Certificate{NotAfter: time.Date(2030, 6, 1, 12, 0, 0, 0, time.UTC)}, nil, nil)
+	unchanged := generateTestCertificate(t, "Unchanged Root", nil, nil, nil)
+	added := generateTestCertificate(t, "Added Root", &x509.Certificate{NotAfter: time.Date(2045, 1, 1, 0, 0, 0, 0, time.UTC)}, nil, nil)
+
+	diff := diffCertificates([]*x509.Certificate{removed.Cert, unchanged.Cert}, []*x509.Certificate{unchanged.Cert, added.Cert})
+	expected := bundleDiff{
+		Added:   []certificateChange{{SHA256: testCertSHA(added), Subject: "CN=Added Root", NotAfter: time.Date(2045, 1, 1, 0, 0, 0, 0, time.UTC)}},
+		Removed: []certificateChange{{SHA256: testCertSHA(removed), Subject: "CN=Removed Root", NotAfter: time.Date(2030, 6, 1, 12, 0, 0, 0, time.UTC)}},
+	}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Errorf("Unexpected diff %+v", diff)
+	}
+
+	if diff := diffCertificates([]*x509.Certificate{unchanged.Cert}, []*x509.Certificate{unchanged.Cert}); diff.HasChanges() || diff.Added == nil || diff.Removed == nil {
+		t.Errorf("Unexpected diff for unchanged bundle %+v", diff)
+	}
+}
+
+func testChangelog(date string) *changelog {
+	return &changelog{
+		Date: date,
+		Vendors: map[string]vendorChangelog{
+			"mozilla": {
+				Name:    "Mozilla",
+				LastKey: "old",
+				Key:     "new",
+				bundleDiff: bundleDiff{
+					Added:   []certificateChange{{SHA256: "AA", Subject: "CN=Added Root", NotAfter: time.Date(2045, 1, 1, 0, 0, 0, 0, time.UTC)}},
+					Removed: []certificateChange{{SHA256: "BB", Subject: "CN=Removed Root", NotAfter: time.Date(2030, 6, 1, 0, 0, 0, 0, time.UTC)}},
+				},
+			},
+			"apple": {
+				Name:       "Apple",
+				LastKey:    "old",
+				Key:        "new",
+				bundleDiff: bundleDiff{Added: []certificateChange{}, Removed: []certificateChange{}},
+			},
+		},
+	}
+}
+
+func TestChangelogMarkdown(t *testing.T) {
+	expected := "## 2024-01-01T00:00:00Z\n" +
+		"\n### Apple\n\n" +
+		"No certificates added or removed.\n" +
+		"\n### Mozilla\n\n" +
+		"- Added `AA` CN=Added Root (expires 2045-01-01)\n" +
+		"- Removed `BB` CN=Removed Root (expires 2030-06-01)\n"
+	if markdown := testChangelog("2024-01-01T00:00:00Z").Markdown(); markdown != expected {
+		t.Errorf("Unexpected markdown:\n%s", markdown)
+	}
+}
+
+func TestWriteChangelogPrependsMarkdown(t *testing.T) {
+	t.Chdir(t.TempDir())
+	signingPrivateKey, signingPublicKey = nil, nil
+
+	first := testChangelog("2024-01-01T00:00:00Z")
+	second := testChangelog("2024-02-01T00:00:00Z")
+	for _, c := range []*changelog{first, second} {
+		if err := writeChangelog(c); err != nil {
+			t.Fatalf("Error writing changelog: %s", err.Error())
+		}
+	}
+
+	markdown, err := os.ReadFile(changelogMarkdownName)
+	if err != nil {
+		t.Fatalf("Error reading markdown: %s", err.Error())
+	}
+	if expected := "# Changes\n\n" + second.Markdown() + "\n" + first.Markdown(); string(markdown) != expected {
+		t.Errorf("Unexpected markdown:\n%s", markdown)
+	}
+
+	// The JSON changelog only describes the most recent run
+	changelogJSON, err := os.ReadFile(changelogJSONName)
+	if err != nil {
+		t.Fatalf("Error reading changelog: %s", err.Error())
+	}
+	c := &changelog{}
+	if err := json.Unmarshal(changelogJSON, c); err != nil {
+		t.Fatalf("Error parsing changelog: %s", err.Error())
+	}
+	if !reflect.DeepEqual(c, second) {
+		t.Errorf("Unexpected changelog %+v", c)
+	}
+}
+
+func TestLoadBundlesForDiffVerification(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	generateSigningKey := func() (*ecdsa.PrivateKey, []byte) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("Error generating key: %s", err.Error())
+		}
+		publicKeyDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		if err != nil {
+			t.Fatalf("Error encoding key: %s", err.Error())
+		}
+		return key, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyDER})
+	}
+	key, publicKey := generateSigningKey()
+	_, otherPublicKey := generateSigningKey()
+
+	signingPrivateKey, signingPublicKey = key, &key.PublicKey
+	defer func() {
+		signingPrivateKey, signingPublicKey = nil, nil
+	}()
+
+	cert := generateTestCertificate(t, "Root", nil, nil, nil)
+	if err := os.WriteFile("root.crt", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Cert.Raw}), 0644); err != nil {
+		t.Fatalf("Error writing certificate: %s", err.Error())
+	}
+	fingerprints, err := generateBundleFromCertificates([]string{"root.crt"}, ".", MozillaBundleName, true)
+	if err != nil {
+		t.Fatalf("Error generating bundle: %s", err.Error())
+	}
+	if err := writeMetadata(BundleMetadata{
+		"mozilla": {Date: "2024-01-01T00:00:00Z", Key: "key", Bundles: fingerprints, NumCerts: 1},
+	}); err != nil {
+		t.Fatalf("Error writing metadata: %s", err.Error())
+	}
+
+	for _, test := range []struct {
+		name         string
+		sourceKey    []byte
+		pinnedKey    []byte
+		verification string
+		fails        bool
+	}{
+		{name: "pinned", sourceKey: otherPublicKey, pinnedKey: publicKey, verification: diffVerifiedPinnedKey},
+		{name: "wrong pinned", sourceKey: publicKey, pinnedKey: otherPublicKey, fails: true},
+		{name: "source", sourceKey: publicKey, verification: diffVerifiedSourceKey},
+		{name: "none", verification: diffVerifiedNone},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			os.Remove(rootca.SigningKeyFileName)
+			if test.sourceKey != nil {
+				if err := os.WriteFile(rootca.SigningKeyFileName, test.sourceKey, 0644); err != nil {
+					t.Fatalf("Error writing signing key: %s", err.Error())
+				}
+			}
+
+			bundles, verification, err := loadBundlesForDiff(".", test.pinnedKey)
+			if test.fails {
+				if err == nil {
+					t.Errorf("No error seen")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Error loading bundles: %s", err.Error())
+			}
+			if verification != test.verification {
+				t.Errorf("Expected verification %s, got %s", test.verification, verification)
+			}
+			if len(bundles.Vendors["mozilla"].Certificates) != 1 {
+				t.Errorf("Unexpected certificates in bundle")
+			}
+		})
+	}
+}
+
+func TestBundlesDiffMarkdown(t *testing.T) {
+	d := bundlesDiff{
+		changelog: changelog{Date: "2024-01-01T00:00:00Z", Vendors: map[string]vendorChangelog{}},
+		Verification: map[string]string{
+			"latest": diffVerifiedSourceKey,
+			"old":    diffVerifiedNone,
+			"pinned": diffVerifiedPinnedKey,
+		},
+	}
+	expected := "## 2024-01-01T00:00:00Z\n" +
+		"\n> Signatures of latest were verified with the signing key included in latest, which only detects corruption. Use --signing-key to verify with a pinned key.\n" +
+		"\n> Signatures of old were not verified, as it does not include a signing key.\n"
+	if markdown := d.Markdown(); markdown != expected {
+		t.Errorf("Unexpected markdown:\n%s", markdown)
+	}
+
+	data, err := json.Marshal(d)
+	if err != nil {
+		t.Fatalf("Error encoding diff: %s", err.Error())
+	}
+	if expected := `{"date":"2024-01-01T00:00:00Z","vendors":{},"verification":{"latest":"source_key","old":"none","pinned":"pinned_key"}}`; string(data) != expected {
+		t.Errorf("Unexpected JSON %s", data)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/tls-inspector/rootca/updater/pkcs7"
+	"github.com/tls-inspector/rootca/updater/rootca"
+)
+
+// certificateChange describes a certificate that was added to or removed from a bundle
+type certificateChange struct {
+	SHA256   string    `json:"sha256"`
+	Subject  string    `json:"subject"`
+	NotAfter time.Time `json:"not_after"`
+}
+
+// bundleDiff describes the differences between two versions of a bundle
+type bundleDiff struct {
+	Added   []certificateChange `json:"added"`
+	Removed []certificateChange `json:"removed"`
+}
+
+// HasChanges returns true if any certificates were added or removed
+func (d bundleDiff) HasChanges() bool {
+	return len(d.Added) > 0 || len(d.Removed) > 0
+}
+
+// diffCertificates will return the certificates that are in newCerts but not oldCerts, and the certificates that are
+// in oldCerts but not newCerts. Certificates are compared by their SHA-256 fingerprint.
+func diffCertificates(oldCerts, newCerts []*x509.Certificate) bundleDiff {
+	oldMap := map[string]*x509.Certificate{}
+	for _, cert := range oldCerts {
+		oldMap[fmt.Sprintf("%X", sha256.Sum256(cert.Raw))] = cert
+	}
+	newMap := map[string]*x509.Certificate{}
+	for _, cert := range newCerts {
+		newMap[fmt.Sprintf("%X", sha256.Sum256(cert.Raw))] = cert
+	}
+
+	diff := bundleDiff{
+		Added:   []certificateChange{},
+		Removed: []certificateChange{},
+	}
+	for sha, cert := range newMap {
+		if _, ok := oldMap[sha]; !ok {
+			diff.Added = append(diff.Added, newCertificateChange(sha, cert))
+		}
+	}
+	for sha, cert := range oldMap {
+		if _, ok := newMap[sha]; !ok {
+			diff.Removed = append(diff.Removed, newCertificateChange(sha, cert))
+		}
+	}
+	sort.Slice(diff.Added, func(i, j int) bool {
+		return diff.Added[i].SHA256 < diff.Added[j].SHA256
+	})
+	sort.Slice(diff.Removed, func(i, j int) bool {
+		return diff.Removed[i].SHA256 < diff.Removed[j].SHA256
+	})
+	return diff
+}
+
+func newCertificateChange(sha string, cert *x509.Certificate) certificateChange {
+	return certificateChange{
+		SHA256:   sha,
+		Subject:  cert.Subject.ToRDNSequence().String(),
+		NotAfter: cert.NotAfter.UTC(),
+	}
+}
+
+// readBundleCertificates will read all certificates from the given PKCS#7 bundle. If the bundle does not exist, no
+// certificates are returned.
+func readBundleCertificates(p7Path string) ([]*x509.Certificate, error) {
+	p7Data, err := os.ReadFile(p7Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []*x509.Certificate{}, nil
+		}
+		return nil, err
+	}
+	derCerts, err := pkcs7.Decode(p7Data)
+	if err != nil {
+		return nil, err
+	}
+
+	certs := []*x509.Certificate{}
+	for _, derCert := range derCerts {
+		cert, err := rootca.ParseCertificate(derCert)
+		if err != nil {
+			return nil, err
+		}
+		if cert == nil {
+			continue
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	_ "embed"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tls-inspector/rootca/updater/rootca"
+	"github.com/tls-inspector/rootca/updater/xmldsig"
+)
+
+const EUQWACBundleName = "eu_qwac_bundle"
+const euQWACVendorID = "eu_qwac"
+
+const euLOTLURL = "https://ec.europa.eu/tools/lotl/eu-lotl.xml"
+
+// euLOTLSignersData are the PEM-encoded certificates that may sign the list of trusted lists, as published in the
+// Official Journal of the EU. They are used unless other certificates are given with --eu-lotl-signers.
+//
+//go:embed eu_lotl_signers.pem
+var euLOTLSignersData []byte
+
+// URIs from ETSI TS 119 612
+const (
+	tslTypeLOTL               = "http://uri.etsi.org/TrstSvc/TrustedList/TSLType/EUlistofthelists"
+	tslTypeGeneric            = "http://uri.etsi.org/TrstSvc/TrustedList/TSLType/EUgeneric"
+	tslMimeTypeXML            = "application/vnd.etsi.tsl+xml"
+	tslServiceTypeCAQC        = "http://uri.etsi.org/TrstSvc/Svctype/CA/QC"
+	tslServiceStatusGranted   = "http://uri.etsi.org/TrstSvc/TrustedList/Svcstatus/granted"
+	tslServiceInfoWebsiteAuth = "http://uri.etsi.org/TrstSvc/TrustedList/SvcInfoExt/ForWebSiteAuthentication"
+)
+
+// euQWACVendor builds a bundle of the certificates of qualified trust services that issue qualified certificates for
+// website authentication (QWACs), from the EU list of trusted lists and the trusted lists of each member state
+type euQWACVendor struct{}
+
+func (euQWACVendor) ID() string         { return euQWACVendorID }
+func (euQWACVendor) Name() string       { return "EU Trusted Lists (QWAC)" }
+func (euQWACVendor) BundleName() string { return EUQWACBundleName }
+func (euQWACVendor) Inputs() []string   { return nil }
+
+// LatestVersion will download and verify the list of trusted lists and every member state trusted list. The key of the
+// version is the checksum of all of the lists, as member states update their lists independently of the list of
+// trusted lists.
+func (euQWACVendor) LatestVersion(bundleDir string) (*VendorVersion, error) {
+	lists, err := getEUTrustedLists()
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.New()
+	var date time.Time
+	for _, list := range lists {
+		fmt.Fprintf(h, "%s:%s\n", list.Territory, list.SHA256)
+		if list.IssueDate.After(date) {
+			date = list.IssueDate
+		}
+	}
+
+	return &VendorVersion{Key: fmt.Sprintf("%x", h.Sum(nil)), Date: date, Extra: lists}, nil
+}
+
+// Fetch will write the certificate of every QWAC service that is currently granted into dir. The services of each
+// certificate are saved to the versions extra data.
+func (euQWACVendor) Fetch(version *VendorVersion, bundleDir, dir string) ([]string, error) {
+	lists := version.Extra.([]*euTrustedList)
+
+	certs := map[string][]byte{}
+	services := map[string][]rootca.EUTrustService{}
+	for _, list := range lists {
+		for _, provider := range list.Document.Providers {
+			for _, service := range provider.Services {
+				information := service.Information
+				if information.ServiceType != tslServiceTypeCAQC || information.Status != tslServiceStatusGranted || !sliceContains(information.AdditionalInformation, tslServiceInfoWebsiteAuth) {
+					continue
+				}
+
+				trustService := rootca.EUTrustService{
+					Country:            list.Territory,
+					Provider:           tslEnglishName(provider.Names),
+					Name:               tslEnglishName(information.Names),
+					Status:             tslURIName(information.Status),
+					StatusStartingTime: information.StatusStartingTime,
+				}
+				for _, history := range service.History {
+					trustService.History = append(trustService.History, rootca.EUTrustServiceStatus{
+						Status:             tslURIName(history.Status),
+						StatusStartingTime: history.StatusStartingTime,
+					})
+				}
+
+				for _, certData := range information.Certificates {
+					der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(certData), ""))
+					if err != nil {
+						return nil, fmt.Errorf("%s: %s: invalid certificate: %s", list.Territory, trustService.Name, err.Error())
+					}
+					pemCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+					sha, err := getCertPemSHA(pemCert)
+					if err != nil {
+						return nil, fmt.Errorf("%s: %s: %s", list.Territory, trustService.Name, err.Error())
+					}
+					certs[sha] = pemCert
+					services[sha] = append(services[sha], trustService)
+				}
+			}
+		}
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates")
+	}
+
+	certPaths := []string{}
+	for sha, pemCert := range certs {
+		certPath := path.Join(dir, sha+".crt")
+		if err := os.WriteFile(certPath, pemCert, 0644); err != nil {
+			return nil, err
+		}
+		certPaths = append(certPaths, certPath)
+	}
+
+	version.Extra = services
+	return certPaths, nil
+}
+
+// Build will generate the bundle and record the trust services of each certificate in the metadata
+func (v euQWACVendor) Build(version *VendorVersion, bundleDir string, certPaths []string) (*VendorMetadata, error) {
+	services := version.Extra.(map[string][]rootca.EUTrustService)
+
+	metadata, err := generateVendorBundle(v, version, bundleDir, certPaths)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, certificate := range metadata.Certificates {
+		certificateServices, ok := services[certificate.SHA256]
+		if !ok {
+			return nil, fmt.Errorf("no trust service for certificate %s", certificate.SHA256)
+		}
+		metadata.Certificates[i].EU = &rootca.EUTrustedListAttributes{Services: certificateServices}
+	}
+
+	return metadata, nil
+}
+
+// euTrustedList is a verified member state trusted list
+type euTrustedList struct {
+	Territory string
+	// The uppercase hex SHA-256 checksum of the list
+	SHA256    string
+	IssueDate time.Time
+	Document  *tslDocument
+}
+
+// tslDocument is the subset of an ETSI TS 119 612 trust service status list used by the updater
+type tslDocument struct {
+	Type              string       `xml:"SchemeInformation>TSLType"`
+	Territory         string       `xml:"SchemeInformation>SchemeTerritory"`
+	ListIssueDateTime string       `xml:"SchemeInformation>ListIssueDateTime"`
+	Pointers          []tslPointer `xml:"SchemeInformation>PointersToOtherTSL>OtherTSLPointer"`
+	Providers         []struct {
+		Names    []tslName    `xml:"TSPInformation>TSPName>Name"`
+		Services []tslService `xml:"TSPServices>TSPService"`
+	} `xml:"TrustServiceProviderList>TrustServiceProvider"`
+}
+
+// tslPointer is a pointer to another trusted list, along with the certificates that may sign it
+type tslPointer struct {
+	Certificates []string `xml:"ServiceDigitalIdentities>ServiceDigitalIdentity>DigitalId>X509Certificate"`
+	Location     string   `xml:"TSLLocation"`
+	Information  []struct {
+		Type      string `xml:"TSLType"`
+		Territory string `xml:"SchemeTerritory"`
+		MimeType  string `xml:"MimeType"`
+	} `xml:"AdditionalInformation>OtherInformation"`
+}
+
+type tslName struct {
+	Lang  string `xml:"lang,attr"`
+	Value string `xml:",chardata"`
+}
+
+type tslService struct {
+	Information tslServiceInformation   `xml:"ServiceInformation"`
+	History     []tslServiceInformation `xml:"ServiceHistory>ServiceHistoryInstance"`
+}
+
+// tslServiceInformation is the information of a service, or a previous instance of the service
+type tslServiceInformation struct {
+	ServiceType           string    `xml:"ServiceTypeIdentifier"`
+	Names                 []tslName `xml:"ServiceName>Name"`
+	Certificates          []string  `xml:"ServiceDigitalIdentity>DigitalId>X509Certificate"`
+	Status                string    `xml:"ServiceStatus"`
+	StatusStartingTime    string    `xml:"StatusStartingTime"`
+	AdditionalInformation []string  `xml:"ServiceInformationExtensions>Extension>AdditionalServiceInformation>URI"`
+}
+
+// pointerInformation returns the type, territory, and MIME type of the list the pointer refers to
+func (p tslPointer) pointerInformation() (listType, territory, mimeType string) {
+	for _, information := range p.Information {
+		if information.Type != "" {
+			listType = information.Type
+		}
+		if information.Territory != "" {
+			territory = information.Territory
+		}
+		if information.MimeType != "" {
+			mimeType = information.MimeType
+		}
+	}
+	return
+}
+
+// certificates returns the certificates that may sign the list the pointer refers to
+func (p tslPointer) certificates() ([]*x509.Certificate, error) {
+	certificates := []*x509.Certificate{}
+	for _, certData := range p.Certificates {
+		der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(certData), ""))
+		if err != nil {
+			return nil, err
+		}
+		certificate, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, certificate)
+	}
+	return certificates, nil
+}
+
+// getEUTrustedLists will download and verify the list of trusted lists, then download and verify every member state
+// trusted list it points to. The list of trusted lists must be signed by one of the certificates given with
+// --eu-lotl-signers, or if none were given, one of the embedded certificates from the Official Journal of the EU. The
+// list is never verified with the certificates in its pointer to itself, as anyone can create a list that points to
+// their own certificate. Member state lists must be signed by one of the certificates in their pointer.
+//
+// If any member state list cannot be verified then no lists are returned, rather than omitting that member state. A
+// bundle without a member state would remove all of its services, and would be published as a new version even if the
+// failure was only temporary.
+func getEUTrustedLists() ([]*euTrustedList, error) {
+	signers := euLOTLSigners
+	if len(signers) == 0 {
+		embeddedSigners, err := rootca.ParsePEMCertificates(euLOTLSignersData)
+		if err != nil {
+			return nil, fmt.Errorf("eu_lotl_signers.pem: %s", err.Error())
+		}
+		signers = embeddedSigners
+	}
+	if len(signers) == 0 {
+		return nil, fmt.Errorf("no signing certificates for the list of trusted lists. Add the certificates published in the Official Journal of the EU to eu_lotl_signers.pem or give them with --eu-lotl-signers")
+	}
+
+	lotl, err := getEUTrustedList(euLOTLURL, "EU", tslTypeLOTL, signers)
+	if err != nil {
+		return nil, fmt.Errorf("list of trusted lists: %s", err.Error())
+	}
+
+	lists := []*euTrustedList{}
+	failedTerritories := []string{}
+	for _, pointer := range lotl.Document.Pointers {
+		listType, territory, mimeType := pointer.pointerInformation()
+		if listType != tslTypeGeneric || mimeType != tslMimeTypeXML {
+			continue
+		}
+		signers, err := pointer.certificates()
+		if err != nil {
+			logError("EU trusted list %s has an invalid signing certificate: %s", territory, err.Error())
+			failedTerritories = append(failedTerritories, territory)
+			continue
+		}
+		list, err := getEUTrustedList(pointer.Location, territory, tslTypeGeneric, signers)
+		if err != nil {
+			logError("Error getting EU trusted list %s: %s", territory, err.Error())
+			failedTerritories = append(failedTerritories, territory)
+			continue
+		}
+		lists = append(lists, list)
+	}
+	if len(failedTerritories) > 0 {
+		return nil, fmt.Errorf("error getting trusted lists of %s", strings.Join(failedTerritories, ", "))
+	}
+	if len(lists) == 0 {
+		return nil, fmt.Errorf("no trusted lists")
+	}
+
+	sort.Slice(lists, func(i, j int) bool {
+		return lists[i].Territory < lists[j].Territory
+	})
+	return lists, nil
+}
+
+// getEUTrustedList will download a trusted list, verify that it is signed by one of the given certificates, and check
+// that it is the expected type of list for the expected territory
+func getEUTrustedList(url, territory, listType string, signers []*x509.Certificate) (*euTrustedList, error) {
+	if len(signers) == 0 {
+		return nil, fmt.Errorf("no signing certificates")
+	}
+
+	data, err := httpGetBytes(url)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := xmldsig.Verify(data, xmldsig.VerifyOptions{Certificates: signers}); err != nil {
+		return nil, err
+	}
+
+	document := &tslDocument{}
+	if err := xml.Unmarshal(data, document); err != nil {
+		return nil, err
+	}
+	if document.Type != listType {
+		return nil, fmt.Errorf("unexpected list type %s", document.Type)
+	}
+	if document.Territory != territory {
+		return nil, fmt.Errorf("unexpected territory %s", document.Territory)
+	}
+	issueDate, err := time.Parse("2006-01-02T15:04:05Z07:00", document.ListIssueDateTime)
+	if err != nil {
+		return nil, fmt.Errorf("invalid issue date %s", document.ListIssueDateTime)
+	}
+
+	return &euTrustedList{
+		Territory: territory,
+		SHA256:    fmt.Sprintf("%X", sha256.Sum256(data)),
+		IssueDate: issueDate,
+		Document:  document,
+	}, nil
+}
+
+// tslEnglishName returns the English name from a list of multilingual names, or the first name if there is none
+func tslEnglishName(names []tslName) string {
+	for _, name := range names {
+		if strings.EqualFold(name.Lang, "en") {
+			return strings.TrimSpace(name.Value)
+		}
+	}
+	if len(names) > 0 {
+		return strings.TrimSpace(names[0].Value)
+	}
+	return ""
+}
+
+// tslURIName returns the last path component of a URI, such as granted for
+// http://uri.etsi.org/TrstSvc/TrustedList/Svcstatus/granted
+func tslURIName(uri string) string {
+	return uri[strings.LastIndex(uri, "/")+1:]
+}
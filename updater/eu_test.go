@@ -0,0 +1,28 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGetEUTrustedListsNoSigners(t *testing.T) {
+	signers, signersData := euLOTLSigners, euLOTLSignersData
+	euLOTLSigners, euLOTLSignersData = nil, []byte("No certificates\n")
+	defer func() {
+		euLOTLSigners, euLOTLSignersData = signers, signersData
+	}()
+
+	// The list of trusted lists must never be downloaded without a pinned signer, as it would otherwise need to be
+	// verified with the certificates it lists for itself
+	_, err := getEUTrustedLists()
+	if err == nil {
+		t.Fatalf("No error seen")
+	}
+	if !strings.Contains(err.Error(), "eu_lotl_signers.pem") {
+		t.Errorf("Unexpected error %s", err.Error())
+	}
+
+	if _, err := getEUTrustedList("http://127.0.0.1:0/", "EU", tslTypeLOTL, nil); err == nil {
+		t.Errorf("No error seen for list without signers")
+	}
+}
@@ -1,4 +1,4 @@
-module github.com/tlsinspector/rootca
+module github.com/tls-inspector/rootca/updater
 
 go 1.26.0
 
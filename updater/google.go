@@ -3,97 +3,288 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"os"
 	"path"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/tls-inspector/rootca/updater/rootca"
 )
 
 const GoogleBundleName = "google_ca_bundle"
+const googleVendorID = "google"
+
+const (
+	chromeRootStorePath      = "net/data/ssl/chrome_root_store"
+	chromeRootStoreCerts     = chromeRootStorePath + "/root_store.certs"
+	chromeRootStoreTextproto = chromeRootStorePath + "/root_store.textproto"
+)
+
+// The GitHub API and raw file URLs of the Chromium repository
+var chromiumAPIURL = "https://api.github.com/repos/chromium/chromium"
+var chromiumRawURL = "https://raw.githubusercontent.com/chromium/chromium"
+
+type googleVendor struct{}
 
-func buildGoogleBundle(metadata *VendorMetadata) (*VendorMetadata, error) {
+func (googleVendor) ID() string         { return googleVendorID }
+func (googleVendor) Name() string       { return "Google" }
+func (googleVendor) BundleName() string { return GoogleBundleName }
+func (googleVendor) Inputs() []string   { return nil }
+
+func (googleVendor) LatestVersion(bundleDir string) (*VendorVersion, error) {
 	latestSHA, lastModified, err := getLatestGoogleSHA()
 	if err != nil {
 		return nil, err
 	}
 
-	if metadata != nil && !forceUpdate {
-		if lastModified.Before(metadata.MustDate()) {
-			logWarning("Google bundle has modified date '%s' newer than the most recent vendors date '%s'. Skipping update.", metadata.MustDate(), lastModified)
-			return metadata, nil
-		}
-		if isBundleUpToDate(latestSHA, metadata.Key, GoogleBundleName) {
-			logNotice("Google bundle is up-to-date")
-			return metadata, nil
-		}
-		logWarning("Detected changes to Google bundle. LastSHA='%s' LatestSHA='%s'", metadata.Key, latestSHA)
-	}
-	log.Printf("Building Google CA bundle")
+	return &VendorVersion{Key: latestSHA, Date: lastModified}, nil
+}
 
-	pemData, err := httpGetBytes("https://raw.githubusercontent.com/chromium/chromium/main/net/data/ssl/chrome_root_store/root_store.certs")
+// Fetch will download the Chrome Root Store certificates and textproto at the version's commit, verify that they
+// describe exactly the same certificates, and write each TLS trust anchor into dir. The attributes of each anchor are
+// saved to the version's extra data and the root store version is saved as the version.
+func (googleVendor) Fetch(version *VendorVersion, bundleDir, dir string) ([]string, error) {
+	pemData, err := httpGetBytes(chromiumRawURL + "/" + version.Key + "/" + chromeRootStoreCerts)
+	if err != nil {
+		return nil, err
+	}
+	textprotoData, err := httpGetString(chromiumRawURL + "/" + version.Key + "/" + chromeRootStoreTextproto)
 	if err != nil {
 		return nil, err
 	}
 
-	pemCerts := extractPemCerts(pemData)
+	rootStore, err := parseChromeRootStore(textprotoData)
+	if err != nil {
+		return nil, fmt.Errorf("root_store.textproto: %s", err.Error())
+	}
 
+	pemCerts := rootca.ExtractPEMCertificates(pemData)
 	if len(pemCerts) == 0 {
 		return nil, fmt.Errorf("no certificates")
 	}
 
-	tempDir, err := os.MkdirTemp("", "google")
-	if err != nil {
+	certMap := map[string][]byte{}
+	for _, pemCert := range pemCerts {
+		sha, err := getCertPemSHA(pemCert)
+		if err != nil {
+			return nil, fmt.Errorf("root_store.certs: %s", err.Error())
+		}
+		certMap[sha] = pemCert
+	}
+	if err := rootStore.verifyCertificates(certMap); err != nil {
 		return nil, err
 	}
-	defer os.RemoveAll(tempDir)
 
-	certPaths := make([]string, len(pemCerts))
-	for i := 0; i < len(pemCerts); i++ {
-		certPaths[i] = path.Join(tempDir, fmt.Sprintf("cert_%d.crt", i))
-		if err := os.WriteFile(certPaths[i], []byte(pemCerts[i]), 0644); err != nil {
+	certPaths := []string{}
+	for _, sha := range rootStore.tlsAnchors() {
+		certPath := path.Join(dir, sha+".crt")
+		if err := os.WriteFile(certPath, certMap[sha], 0644); err != nil {
 			return nil, err
 		}
+		certPaths = append(certPaths, certPath)
 	}
 
-	p7Fingerprints, pemFingerprints, err := generateBundleFromCertificates(certPaths, GoogleBundleName)
+	version.Version = rootStore.VersionMajor
+	version.Extra = rootStore
+	return certPaths, nil
+}
+
+func (v googleVendor) Build(version *VendorVersion, bundleDir string, certPaths []string) (*VendorMetadata, error) {
+	return buildGoogleBundle(v, version, bundleDir, certPaths)
+}
+
+// buildGoogleBundle will generate the bundle and record the Chrome Root Store attributes of each certificate in the
+// metadata
+func buildGoogleBundle(vendor Vendor, version *VendorVersion, bundleDir string, certPaths []string) (*VendorMetadata, error) {
+	rootStore := version.Extra.(*chromeRootStore)
+
+	metadata, err := generateVendorBundle(vendor, version, bundleDir, certPaths)
 	if err != nil {
 		return nil, err
 	}
 
-	logNotice("Google CA bundle generated with %d certificates", len(certPaths))
+	for i, certificate := range metadata.Certificates {
+		anchor, ok := rootStore.Anchors[certificate.SHA256]
+		if !ok {
+			return nil, fmt.Errorf("no trust anchor for certificate %s", certificate.SHA256)
+		}
+		if len(anchor.Attributes.EVPolicies) > 0 || len(anchor.Attributes.Constraints) > 0 {
+			attributes := anchor.Attributes
+			metadata.Certificates[i].Chrome = &attributes
+		}
+	}
+
+	return metadata, nil
+}
 
-	return &VendorMetadata{
-		Key: latestSHA,
-		Bundles: map[string]BundleFingerprint{
-			GoogleBundleName + ".p7b": *p7Fingerprints,
-			GoogleBundleName + ".pem": *pemFingerprints,
-		},
-		Date:     lastModified.Format("2006-01-02T15:04:05Z07:00"),
-		NumCerts: len(pemCerts),
-	}, nil
+// chromeAnchor is a certificate in the Chrome Root Store
+type chromeAnchor struct {
+	// If the certificate is trusted for TLS server authentication
+	TLSTrustAnchor bool
+	Attributes     rootca.ChromeAttributes
 }
 
-func getLatestGoogleSHA() (string, time.Time, error) {
-	resp, err := httpGetString("https://api.github.com/repos/chromium/chromium/commits?path=net/data/ssl/chrome_root_store/root_store.certs")
+// chromeRootStore is the parsed root_store.textproto, with every certificate keyed by its uppercase hex SHA-256
+// fingerprint
+type chromeRootStore struct {
+	VersionMajor string
+	Anchors      map[string]chromeAnchor
+}
+
+// parseChromeRootStore will parse the trust anchors and additional certificates in a Chrome root_store.textproto
+func parseChromeRootStore(textprotoData string) (*chromeRootStore, error) {
+	message, err := parseTextproto(textprotoData)
 	if err != nil {
-		return "", time.Now(), err
+		return nil, err
 	}
 
-	ghResponse := []map[string]interface{}{}
-	if err := json.Unmarshal([]byte(resp), &ghResponse); err != nil {
-		return "", time.Now(), err
+	rootStore := &chromeRootStore{
+		VersionMajor: message.Value("version_major"),
+		Anchors:      map[string]chromeAnchor{},
+	}
+	if rootStore.VersionMajor == "" {
+		return nil, fmt.Errorf("no version_major")
 	}
 
-	if len(ghResponse) < 1 {
-		return "", time.Now(), fmt.Errorf("no commit")
+	formatSeconds := func(value string) (string, error) {
+		if value == "" {
+			return "", nil
+		}
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid time %s", value)
+		}
+		return time.Unix(seconds, 0).UTC().Format("2006-01-02T15:04:05Z07:00"), nil
 	}
 
-	sha := ghResponse[0]["sha"].(string)
-	dateStr := ghResponse[0]["commit"].(map[string]interface{})["author"].(map[string]interface{})["date"].(string)
-	date, err := time.Parse("2006-01-02T15:04:05Z", dateStr)
-	if err != nil {
-		date = time.Now()
+	addAnchor := func(anchorMessage *textprotoMessage, tlsTrustAnchor bool) error {
+		sha := strings.ToUpper(anchorMessage.Value("sha256_hex"))
+		if len(sha) != 64 {
+			return fmt.Errorf("invalid sha256_hex '%s'", sha)
+		}
+		if _, duplicate := rootStore.Anchors[sha]; duplicate {
+			return fmt.Errorf("duplicate certificate %s", sha)
+		}
+
+		anchor := chromeAnchor{
+			TLSTrustAnchor: tlsTrustAnchor,
+			Attributes: rootca.ChromeAttributes{
+				EVPolicies: anchorMessage.Values("ev_policy_oids"),
+			},
+		}
+		if len(anchor.Attributes.EVPolicies) == 0 {
+			anchor.Attributes.EVPolicies = nil
+		}
+
+		for _, constraintMessage := range anchorMessage.Messages("constraints") {
+			constraint := rootca.ChromeConstraint{
+				MinVersion:          constraintMessage.Value("min_version"),
+				MaxVersionExclusive: constraintMessage.Value("max_version_exclusive"),
+				PermittedDNSNames:   constraintMessage.Values("permitted_dns_names"),
+			}
+			if len(constraint.PermittedDNSNames) == 0 {
+				constraint.PermittedDNSNames = nil
+			}
+			if constraint.SCTNotAfter, err = formatSeconds(constraintMessage.Value("sct_not_after_sec")); err != nil {
+				return fmt.Errorf("%s: %s", sha, err.Error())
+			}
+			if constraint.SCTAllAfter, err = formatSeconds(constraintMessage.Value("sct_all_after_sec")); err != nil {
+				return fmt.Errorf("%s: %s", sha, err.Error())
+			}
+			anchor.Attributes.Constraints = append(anchor.Attributes.Constraints, constraint)
+		}
+
+		rootStore.Anchors[sha] = anchor
+		return nil
+	}
+
+	for _, anchorMessage := range message.Messages("trust_anchors") {
+		if err := addAnchor(anchorMessage, true); err != nil {
+			return nil, err
+		}
+	}
+	// Additional certificates are included in root_store.certs, but are only trusted for TLS if marked as such
+	for _, certMessage := range message.Messages("additional_certs") {
+		if err := addAnchor(certMessage, certMessage.Value("tls_trust_anchor") == "true"); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(rootStore.Anchors) == 0 {
+		return nil, fmt.Errorf("no trust anchors")
+	}
+
+	return rootStore, nil
+}
+
+// verifyCertificates ensures that the given certificates, keyed by SHA-256 fingerprint, are exactly those in the root
+// store
+func (s *chromeRootStore) verifyCertificates(certMap map[string][]byte) error {
+	for sha := range certMap {
+		if _, ok := s.Anchors[sha]; !ok {
+			return fmt.Errorf("certificate %s in root_store.certs is not in root_store.textproto", sha)
+		}
+	}
+	for sha := range s.Anchors {
+		if _, ok := certMap[sha]; !ok {
+			return fmt.Errorf("certificate %s in root_store.textproto is not in root_store.certs", sha)
+		}
+	}
+	return nil
+}
+
+// tlsAnchors returns the sorted SHA-256 fingerprints of every certificate trusted for TLS
+func (s *chromeRootStore) tlsAnchors() []string {
+	shas := []string{}
+	for sha, anchor := range s.Anchors {
+		if anchor.TLSTrustAnchor {
+			shas = append(shas, sha)
+		}
+	}
+	sort.Strings(shas)
+	return shas
+}
+
+// chromiumCommit is a commit in the Chromium repository, as returned by the GitHub API
+type chromiumCommit struct {
+	SHA    string `json:"sha"`
+	Commit struct {
+		Author struct {
+			Date string `json:"date"`
+		} `json:"author"`
+	} `json:"commit"`
+}
+
+// getLatestGoogleSHA returns the SHA and date of the most recent commit to either root_store.certs or
+// root_store.textproto
+func getLatestGoogleSHA() (string, time.Time, error) {
+	latestSHA := ""
+	var latestDate time.Time
+	for _, filePath := range []string{chromeRootStoreCerts, chromeRootStoreTextproto} {
+		resp, err := httpGetBytes(chromiumAPIURL + "/commits?per_page=1&path=" + filePath)
+		if err != nil {
+			return "", time.Now(), err
+		}
+
+		commits := []chromiumCommit{}
+		if err := json.Unmarshal(resp, &commits); err != nil {
+			return "", time.Now(), err
+		}
+		if len(commits) < 1 {
+			return "", time.Now(), fmt.Errorf("no commit for %s", filePath)
+		}
+
+		date, err := time.Parse("2006-01-02T15:04:05Z", commits[0].Commit.Author.Date)
+		if err != nil {
+			date = time.Now()
+		}
+		if latestSHA == "" || date.After(latestDate) {
+			latestSHA = commits[0].SHA
+			latestDate = date
+		}
 	}
 
-	return sha, date, nil
+	return latestSHA, latestDate, nil
 }
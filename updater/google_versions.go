@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const chromeRootStoreVendorIDPrefix = googleVendorID + "_"
+
+// chromeRootStoreVendor builds a bundle of the Chrome Root Store as of a specific Chromium commit, such as the last
+// commit of a root store version or a Chrome release tag
+type chromeRootStoreVendor struct {
+	// The suffix of the vendor ID and bundle name, such as v30 or 124.0.6367.60
+	suffix string
+	// The Chromium commit SHA, tag, or branch to build the root store from
+	ref string
+	// The date of the commit, if the ref is already a commit SHA
+	date time.Time
+	// The Chrome releases that shipped this root store, if known
+	releases []string
+}
+
+func (v chromeRootStoreVendor) ID() string         { return chromeRootStoreVendorIDPrefix + v.suffix }
+func (v chromeRootStoreVendor) Name() string       { return "Google (" + v.suffix + ")" }
+func (v chromeRootStoreVendor) BundleName() string { return GoogleBundleName + "_" + v.suffix }
+func (chromeRootStoreVendor) Inputs() []string     { return nil }
+
+// LatestVersion returns the commit of the vendor, resolving the ref to a commit SHA if needed. The ref may be a branch,
+// so unlike per-release Apple bundles the commit may change between runs.
+func (v chromeRootStoreVendor) LatestVersion(bundleDir string) (*VendorVersion, error) {
+	if !v.date.IsZero() {
+		return &VendorVersion{Key: v.ref, Date: v.date}, nil
+	}
+
+	commit, err := getChromiumCommit(v.ref)
+	if err != nil {
+		return nil, err
+	}
+	date, err := time.Parse("2006-01-02T15:04:05Z", commit.Commit.Author.Date)
+	if err != nil {
+		date = time.Now()
+	}
+	return &VendorVersion{Key: commit.SHA, Date: date}, nil
+}
+
+// Fetch will download and verify the Chrome Root Store at the versions commit and write each TLS trust anchor into dir
+func (chromeRootStoreVendor) Fetch(version *VendorVersion, bundleDir, dir string) ([]string, error) {
+	return googleVendor{}.Fetch(version, bundleDir, dir)
+}
+
+// Build will generate the bundle and record the Chrome releases that shipped the root store in the metadata
+func (v chromeRootStoreVendor) Build(version *VendorVersion, bundleDir string, certPaths []string) (*VendorMetadata, error) {
+	metadata, err := buildGoogleBundle(v, version, bundleDir, certPaths)
+	if err != nil {
+		return nil, err
+	}
+	metadata.Releases = v.releases
+	return metadata, nil
+}
+
+// restoreChromeRootStoreVendor returns the versioned Chrome Root Store vendor that built the given metadata, or nil if
+// the metadata was not built by one. The vendor is rebuilt from the vendor ID along with the root store version or
+// Chromium ref recorded in the metadata, and only matches if it has the same ID.
+func restoreChromeRootStoreVendor(id string, metadata VendorMetadata) Vendor {
+	date, err := metadata.ParseDate()
+	if err != nil {
+		return nil
+	}
+
+	vendor := chromeRootStoreVendor{suffix: "v" + metadata.Version, ref: metadata.Key, date: date}
+	if metadata.Version == "" || vendor.ID() != id {
+		if len(metadata.Releases) != 1 || !strings.HasPrefix(metadata.Releases[0], "Chromium ") {
+			return nil
+		}
+		refVendors, err := getChromeRefVendors([]string{strings.TrimPrefix(metadata.Releases[0], "Chromium ")})
+		if err != nil {
+			return nil
+		}
+		vendor = refVendors[0].(chromeRootStoreVendor)
+	}
+	if vendor.ID() != id {
+		return nil
+	}
+	return vendor
+}
+
+var chromiumRefPattern = regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
+var chromiumRefSuffixPattern = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// getChromeRefVendors returns a vendor for the Chrome Root Store at each of the given Chromium refs, such as the
+// 124.0.6367.60 release tag
+func getChromeRefVendors(refs []string) ([]Vendor, error) {
+	vendors := []Vendor{}
+	for _, ref := range refs {
+		if !chromiumRefPattern.MatchString(ref) {
+			return nil, fmt.Errorf("invalid Chromium ref '%s'", ref)
+		}
+		vendors = append(vendors, chromeRootStoreVendor{
+			suffix:   chromiumRefSuffixPattern.ReplaceAllString(ref, "_"),
+			ref:      ref,
+			releases: []string{"Chromium " + ref},
+		})
+	}
+	return vendors, nil
+}
+
+// getChromeVersionVendors returns a vendor for every version of the Chrome Root Store on Chromium main, keyed by the
+// version_major of root_store.textproto. Each vendor is built from the last commit with that version. Versions that
+// were already built are restored from the metadata, and only commits since the most recent of them are fetched.
+func getChromeVersionVendors(metadata BundleMetadata) ([]Vendor, error) {
+	cached := map[string]chromeRootStoreVendor{}
+	var since time.Time
+	for id, vendorMetadata := range metadata {
+		vendor, ok := restoreChromeRootStoreVendor(id, vendorMetadata).(chromeRootStoreVendor)
+		if !ok || vendor.suffix != "v"+vendorMetadata.Version {
+			continue
+		}
+		cached[vendorMetadata.Version] = vendor
+		if vendor.date.After(since) {
+			since = vendor.date
+		}
+	}
+
+	url := chromiumAPIURL + "/commits?per_page=100&path=" + chromeRootStoreTextproto
+	if !since.IsZero() {
+		// The last commit of the most recent cached version is included, in case that version had no further commits
+		url += "&since=" + since.UTC().Format("2006-01-02T15:04:05Z")
+	}
+
+	vendors := []Vendor{}
+	seen := map[string]bool{}
+	for page := 1; ; page++ {
+		resp, err := httpGetBytes(fmt.Sprintf("%s&page=%d", url, page))
+		if err != nil {
+			return nil, err
+		}
+		commits := []chromiumCommit{}
+		if err := json.Unmarshal(resp, &commits); err != nil {
+			return nil, err
+		}
+		if len(commits) == 0 {
+			break
+		}
+
+		// Commits are returned newest first, so the first commit seen for each version is its last
+		for _, commit := range commits {
+			versionMajor := ""
+			for version, vendor := range cached {
+				if vendor.ref == commit.SHA {
+					versionMajor = version
+				}
+			}
+			if versionMajor == "" {
+				textprotoData, err := httpGetString(chromiumRawURL + "/" + commit.SHA + "/" + chromeRootStoreTextproto)
+				if err != nil {
+					return nil, err
+				}
+				message, err := parseTextproto(textprotoData)
+				if err != nil {
+					log.Printf("Skipping Chromium commit %s: root_store.textproto: %s", commit.SHA, err.Error())
+					continue
+				}
+				versionMajor = message.Value("version_major")
+				if versionMajor == "" {
+					log.Printf("Skipping Chromium commit %s: no version_major", commit.SHA)
+					continue
+				}
+			}
+			if seen[versionMajor] {
+				continue
+			}
+			seen[versionMajor] = true
+
+			date, err := time.Parse("2006-01-02T15:04:05Z", commit.Commit.Author.Date)
+			if err != nil {
+				return nil, fmt.Errorf("commit %s: %s", commit.SHA, err.Error())
+			}
+			vendors = append(vendors, chromeRootStoreVendor{
+				suffix: "v" + versionMajor,
+				ref:    commit.SHA,
+				date:   date,
+			})
+		}
+	}
+
+	// Cached versions without any newer commits are unchanged
+	cachedVersions := make([]string, 0, len(cached))
+	for version := range cached {
+		cachedVersions = append(cachedVersions, version)
+	}
+	sort.Slice(cachedVersions, func(i, j int) bool {
+		return compareVersions(cachedVersions[i], cachedVersions[j]) > 0
+	})
+	for _, version := range cachedVersions {
+		if !seen[version] {
+			vendors = append(vendors, cached[version])
+		}
+	}
+
+	if len(vendors) == 0 {
+		return nil, fmt.Errorf("no Chrome Root Store versions")
+	}
+	return vendors, nil
+}
+
+// getChromiumCommit returns the commit for the given Chromium ref
+func getChromiumCommit(ref string) (*chromiumCommit, error) {
+	resp, err := httpGetBytes(chromiumAPIURL + "/commits/" + ref)
+	if err != nil {
+		return nil, err
+	}
+	commit := &chromiumCommit{}
+	if err := json.Unmarshal(resp, commit); err != nil {
+		return nil, err
+	}
+	if commit.SHA == "" {
+		return nil, fmt.Errorf("no commit for ref %s", ref)
+	}
+	return commit, nil
+}
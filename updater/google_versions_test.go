@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRestoreChromeRootStoreVendor(t *testing.T) {
+	date := "2024-05-01T12:00:00Z"
+	for _, test := range []struct {
+		name     string
+		id       string
+		metadata VendorMetadata
+		suffix   string
+	}{
+		{name: "version", id: "google_v30", metadata: VendorMetadata{Key: "abc", Date: date, Version: "30"}, suffix: "v30"},
+		{name: "ref", id: "google_124.0.6367.60", metadata: VendorMetadata{Key: "abc", Date: date, Version: "28", Releases: []string{"Chromium 124.0.6367.60"}}, suffix: "124.0.6367.60"},
+		{name: "branch ref", id: "google_refs_branch-heads_6367", metadata: VendorMetadata{Key: "abc", Date: date, Version: "28", Releases: []string{"Chromium refs/branch-heads/6367"}}, suffix: "refs_branch-heads_6367"},
+		{name: "policy vendor", id: "google_tls", metadata: VendorMetadata{Key: "abc", Date: date}},
+		{name: "mismatched version", id: "google_v31", metadata: VendorMetadata{Key: "abc", Date: date, Version: "30"}},
+		{name: "google", id: "google", metadata: VendorMetadata{Key: "abc", Date: date, Version: "30"}},
+		{name: "invalid date", id: "google_v30", metadata: VendorMetadata{Key: "abc", Version: "30"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			vendor, ok := restoreChromeRootStoreVendor(test.id, test.metadata).(chromeRootStoreVendor)
+			if test.suffix == "" {
+				if ok {
+					t.Errorf("Unexpected vendor %+v", vendor)
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("No vendor restored")
+			}
+			if vendor.suffix != test.suffix || vendor.ID() != test.id {
+				t.Errorf("Unexpected vendor %+v", vendor)
+			}
+		})
+	}
+}
+
+func TestGetChromeVersionVendorsCached(t *testing.T) {
+	commit := func(sha, date string) string {
+		return fmt.Sprintf(`{"sha":"%s","commit":{"author":{"date":"%s"}}}`, sha, date)
+	}
+	requests := map[string]int{}
+	files := map[string]string{
+		"/commits?per_page=100&path=" + chromeRootStoreTextproto + "&since=2024-05-01T12:00:00Z&page=1": "[" +
+			commit("new2", "2024-07-01T00:00:00Z") + "," +
+			commit("new1", "2024-06-01T00:00:00Z") + "," +
+			commit("cached30", "2024-05-01T12:00:00Z") + "]",
+		"/commits?per_page=100&path=" + chromeRootStoreTextproto + "&since=2024-05-01T12:00:00Z&page=2": "[]",
+		"/new2/" + chromeRootStoreTextproto: "version_major: 31",
+		"/new1/" + chromeRootStoreTextproto: "version_major: 30",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.RequestURI()]++
+		data, ok := files[r.URL.RequestURI()]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(data))
+	}))
+	defer server.Close()
+
+	apiURL, rawURL := chromiumAPIURL, chromiumRawURL
+	chromiumAPIURL, chromiumRawURL = server.URL, server.URL
+	defer func() {
+		chromiumAPIURL, chromiumRawURL = apiURL, rawURL
+	}()
+
+	metadata := BundleMetadata{
+		"google":     {Key: "latest", Date: "2024-07-01T00:00:00Z", Version: "31"},
+		"google_v29": {Key: "cached29", Date: "2024-04-01T00:00:00Z", Version: "29"},
+		"google_v30": {Key: "cached30", Date: "2024-05-01T12:00:00Z", Version: "30"},
+	}
+	vendors, err := getChromeVersionVendors(metadata)
+	if err != nil {
+		t.Fatalf("Error getting versions: %s", err.Error())
+	}
+
+	expected := []chromeRootStoreVendor{
+		{suffix: "v31", ref: "new2", date: time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{suffix: "v30", ref: "new1", date: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{suffix: "v29", ref: "cached29", date: time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	if len(vendors) != len(expected) {
+		t.Fatalf("Expected %d vendors, got %d", len(expected), len(vendors))
+	}
+	for i, vendor := range vendors {
+		v := vendor.(chromeRootStoreVendor)
+		if v.suffix != expected[i].suffix || v.ref != expected[i].ref || !v.date.Equal(expected[i].date) {
+			t.Errorf("Unexpected vendor %d %+v", i, v)
+		}
+	}
+
+	for uri, count := range requests {
+		if _, ok := files[uri]; !ok {
+			t.Errorf("Unexpected request %s", uri)
+		} else if count != 1 {
+			t.Errorf("Requested %s %d times", uri, count)
+		}
+	}
+}
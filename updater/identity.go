@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tls-inspector/rootca/updater/rootca"
+)
+
+// certificateGroup is every certificate found in a set of bundles that shares the same subject and public key.
+// Vendors may ship different certificates for the same root, such as a root that was re-issued with a different
+// validity period or signature algorithm. Each distinct certificate is a variant of the group.
+type certificateGroup struct {
+	// The uppercase hex SHA-256 of the DER-encoded subject and of the subject public key info, separated by a colon
+	identity string
+	variants []*certificateVariant
+}
+
+// certificateVariant is a distinct certificate within a certificateGroup
+type certificateVariant struct {
+	cert *x509.Certificate
+	sha  string
+	// The IDs of the vendors whose bundle contains exactly this certificate, in input order
+	vendors []string
+}
+
+// certificateIdentity returns the identity of the certificate, which is the same for any certificate with the same
+// subject and public key
+func certificateIdentity(cert *x509.Certificate) string {
+	return fmt.Sprintf("%X:%X", sha256.Sum256(cert.RawSubject), sha256.Sum256(cert.RawSubjectPublicKeyInfo))
+}
+
+// groupCertificates will group the certificates of each vendor by their identity. Groups are sorted by identity and
+// variants by SHA-256 fingerprint.
+func groupCertificates(vendorIDs []string, vendorCerts map[string][]x509.Certificate) []*certificateGroup {
+	groupMap := map[string]*certificateGroup{}
+	variantMap := map[string]*certificateVariant{}
+	for _, vendorID := range vendorIDs {
+		for i := range vendorCerts[vendorID] {
+			cert := &vendorCerts[vendorID][i]
+			sha := fmt.Sprintf("%X", sha256.Sum256(cert.Raw))
+			variant, ok := variantMap[sha]
+			if !ok {
+				variant = &certificateVariant{cert: cert, sha: sha}
+				variantMap[sha] = variant
+
+				identity := certificateIdentity(cert)
+				group, ok := groupMap[identity]
+				if !ok {
+					group = &certificateGroup{identity: identity}
+					groupMap[identity] = group
+				}
+				group.variants = append(group.variants, variant)
+			}
+			if !sliceContains(variant.vendors, vendorID) {
+				variant.vendors = append(variant.vendors, vendorID)
+			}
+		}
+	}
+
+	groups := make([]*certificateGroup, 0, len(groupMap))
+	for _, group := range groupMap {
+		sort.Slice(group.variants, func(i, j int) bool {
+			return group.variants[i].sha < group.variants[j].sha
+		})
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].identity < groups[j].identity
+	})
+	return groups
+}
+
+// vendors returns the IDs of the vendors whose bundle contains any variant of the group, in the given vendor order
+func (g *certificateGroup) vendors(vendorIDs []string) []string {
+	vendors := []string{}
+	for _, vendorID := range vendorIDs {
+		for _, variant := range g.variants {
+			if sliceContains(variant.vendors, vendorID) {
+				vendors = append(vendors, vendorID)
+				break
+			}
+		}
+	}
+	return vendors
+}
+
+// ambiguous returns true if vendors ship more than one distinct certificate for the group
+func (g *certificateGroup) ambiguous() bool {
+	return len(g.variants) > 1
+}
+
+// canonical returns the canonical variant of the group out of the given candidates. The canonical variant is the one
+// present in the most vendors, then the one that expires last, then the one issued last, and finally the one with the
+// lowest SHA-256 fingerprint.
+func (g *certificateGroup) canonical(candidates []*certificateVariant) *certificateVariant {
+	if len(candidates) == 0 {
+		return nil
+	}
+	sorted := make([]*certificateVariant, len(candidates))
+	copy(sorted, candidates)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if len(a.vendors) != len(b.vendors) {
+			return len(a.vendors) > len(b.vendors)
+		}
+		if !a.cert.NotAfter.Equal(b.cert.NotAfter) {
+			return a.cert.NotAfter.After(b.cert.NotAfter)
+		}
+		if !a.cert.NotBefore.Equal(b.cert.NotBefore) {
+			return a.cert.NotBefore.After(b.cert.NotBefore)
+		}
+		return a.sha < b.sha
+	})
+	return sorted[0]
+}
+
+// identityAttributes describes the group for the metadata of the given selected variant
+func (g *certificateGroup) identityAttributes(selected *certificateVariant, vendorIDs []string) *rootca.IdentityAttributes {
+	attributes := &rootca.IdentityAttributes{
+		Vendors: g.vendors(vendorIDs),
+	}
+	for _, variant := range g.variants {
+		if variant == selected {
+			continue
+		}
+		attributes.Variants = append(attributes.Variants, rootca.CertificateVariant{
+			SHA256:  variant.sha,
+			Vendors: variant.vendors,
+		})
+	}
+	return attributes
+}
+
+// describe returns a human readable description of the variants of the group, such as
+// "CN=Example Root: 0A1B... (apple, google), 2C3D... (mozilla)"
+func (g *certificateGroup) describe() string {
+	variants := make([]string, len(g.variants))
+	for i, variant := range g.variants {
+		variants[i] = fmt.Sprintf("%s (%s)", variant.sha, strings.Join(variant.vendors, ", "))
+	}
+	return g.variants[0].cert.Subject.String() + ": " + strings.Join(variants, ", ")
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/x509"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tls-inspector/rootca/updater/rootca"
+)
+
+func TestGroupCertificates(t *testing.T) {
+	root := generateTestCertificate(t, "Example Root", nil, nil, nil)
+	reissued := generateTestCertificate(t, "Example Root", &x509.Certificate{NotAfter: time.Date(2045, 1, 1, 0, 0, 0, 0, time.UTC)}, nil, root.Key)
+	sameKeyOtherSubject := generateTestCertificate(t, "Other Root", nil, nil, root.Key)
+	sameSubjectOtherKey := generateTestCertificate(t, "Example Root", nil, nil, nil)
+
+	vendorIDs := []string{"mozilla", "apple", "google"}
+	groups := groupCertificates(vendorIDs, map[string][]x509.Certificate{
+		"mozilla": {*root.Cert, *sameKeyOtherSubject.Cert},
+		"apple":   {*reissued.Cert, *root.Cert, *root.Cert},
+		"google":  {*sameSubjectOtherKey.Cert},
+	})
+	if len(groups) != 3 {
+		t.Fatalf("Expected 3 groups, got %d", len(groups))
+	}
+	for i := 1; i < len(groups); i++ {
+		if groups[i-1].identity >= groups[i].identity {
+			t.Errorf("Groups are not sorted by identity")
+		}
+	}
+
+	var group *certificateGroup
+	for _, g := range groups {
+		if g.identity == certificateIdentity(root.Cert) {
+			group = g
+		} else if g.ambiguous() {
+			t.Errorf("Group with a single certificate is ambiguous")
+		}
+	}
+	if group == nil {
+		t.Fatalf("No group for the root")
+	}
+	if !group.ambiguous() || len(group.variants) != 2 {
+		t.Fatalf("Expected 2 variants, got %d", len(group.variants))
+	}
+	if group.variants[0].sha >= group.variants[1].sha {
+		t.Errorf("Variants are not sorted by fingerprint")
+	}
+	for _, variant := range group.variants {
+		expected := []string{"mozilla", "apple"}
+		if variant.cert.Equal(reissued.Cert) {
+			expected = []string{"apple"}
+		}
+		if !reflect.DeepEqual(variant.vendors, expected) {
+			t.Errorf("Unexpected vendors %v for variant %s", variant.vendors, variant.sha)
+		}
+	}
+	if vendors := group.vendors(vendorIDs); !reflect.DeepEqual(vendors, []string{"mozilla", "apple"}) {
+		t.Errorf("Unexpected group vendors %v", vendors)
+	}
+	if description := group.describe(); !strings.HasPrefix(description, "CN=Example Root: ") || !strings.Contains(description, "(mozilla, apple)") || !strings.Contains(description, "(apple)") {
+		t.Errorf("Unexpected description %s", description)
+	}
+}
+
+func TestCertificateGroupCanonical(t *testing.T) {
+	variant := func(sha string, notBefore, notAfter time.Time, vendors ...string) *certificateVariant {
+		return &certificateVariant{
+			cert:    &x509.Certificate{NotBefore: notBefore, NotAfter: notAfter},
+			sha:     sha,
+			vendors: vendors,
+		}
+	}
+	early := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	late := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	expiresEarly := time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC)
+	expiresLate := time.Date(2045, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, test := range []struct {
+		name       string
+		candidates []*certificateVariant
+		expected   string
+	}{
+		{
+			name:       "most vendors",
+			candidates: []*certificateVariant{variant("A", late, expiresLate, "apple"), variant("B", early, expiresEarly, "apple", "mozilla")},
+			expected:   "B",
+		},
+		{
+			name:       "expires last",
+			candidates: []*certificateVariant{variant("A", late, expiresEarly, "apple"), variant("B", early, expiresLate, "mozilla")},
+			expected:   "B",
+		},
+		{
+			name:       "issued last",
+			candidates: []*certificateVariant{variant("A", early, expiresLate, "apple"), variant("B", late, expiresLate, "mozilla")},
+			expected:   "B",
+		},
+		{
+			name:       "lowest fingerprint",
+			candidates: []*certificateVariant{variant("B", early, expiresLate, "apple"), variant("A", early, expiresLate, "mozilla")},
+			expected:   "A",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			group := &certificateGroup{variants: test.candidates}
+			if selected := group.canonical(test.candidates); selected.sha != test.expected {
+				t.Errorf("Selected %s, expected %s", selected.sha, test.expected)
+			}
+			if test.candidates[0].sha != group.variants[0].sha {
+				t.Errorf("Candidates were reordered")
+			}
+		})
+	}
+
+	if selected := (&certificateGroup{}).canonical(nil); selected != nil {
+		t.Errorf("Selected a variant without candidates")
+	}
+}
+
+func TestCertificateGroupIdentityAttributes(t *testing.T) {
+	a := &certificateVariant{sha: "A", vendors: []string{"apple"}}
+	b := &certificateVariant{sha: "B", vendors: []string{"mozilla", "google"}}
+	c := &certificateVariant{sha: "C", vendors: []string{"google"}}
+	group := &certificateGroup{variants: []*certificateVariant{a, b, c}}
+
+	attributes := group.identityAttributes(b, []string{"google", "mozilla", "apple", "microsoft"})
+	expected := &rootca.IdentityAttributes{
+		Vendors: []string{"google", "mozilla", "apple"},
+		Variants: []rootca.CertificateVariant{
+			{SHA256: "A", Vendors: []string{"apple"}},
+			{SHA256: "C", Vendors: []string{"google"}},
+		},
+	}
+	if !reflect.DeepEqual(attributes, expected) {
+		t.Errorf("Unexpected attributes %+v", attributes)
+	}
+
+	single := &certificateGroup{variants: []*certificateVariant{a}}
+	if attributes := single.identityAttributes(a, []string{"apple"}); attributes.Variants != nil {
+		t.Errorf("Unexpected variants %+v", attributes.Variants)
+	}
+}
@@ -0,0 +1,29 @@
+// Package testcert provides the self-signed certificates shared by the truststore tests. They were generated with:
+//
+//	openssl req -x509 -newkey ec -pkeyopt ec_paramgen_curve:P-256 -nodes -keyout /dev/null -out root_a.pem \
+//		-days 7300 -subj "/CN=Test Root A" -addext basicConstraints=critical,CA:TRUE \
+//		-addext keyUsage=critical,keyCertSign,cRLSign
+package testcert
+
+import (
+	"embed"
+	"encoding/pem"
+	"testing"
+)
+
+//go:embed *.pem
+var certificates embed.FS
+
+// Certificate returns the DER-encoded certificate with the given name, either "root_a" or "root_b"
+func Certificate(t testing.TB, name string) []byte {
+	t.Helper()
+	pemData, err := certificates.ReadFile(name + ".pem")
+	if err != nil {
+		t.Fatalf("Error reading certificate: %s", err.Error())
+	}
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		t.Fatalf("Invalid pem data")
+	}
+	return block.Bytes
+}
@@ -0,0 +1,207 @@
+// Package jks provides a reader and writer for Java KeyStore truststores, which contain only trusted certificate
+// entries. The output is deterministic and can be read by keytool and any Java release.
+package jks
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	magic                 = 0xFEEDFEED
+	version               = 2
+	tagTrustedCertificate = 2
+	certificateType       = "X.509"
+	integrityDigestPhrase = "Mighty Aphrodite"
+	maxModifiedUTF8Length = 0xFFFF
+	integrityDigestLength = sha1.Size
+	headerLength          = 12
+)
+
+// TrustedCertificate is a trusted certificate entry in a truststore
+type TrustedCertificate struct {
+	// The alias of the entry, which must be unique within the truststore. Java treats aliases as case-insensitive and
+	// always lowercases them, so aliases must be lowercase.
+	Alias string
+	// When the entry was created
+	Date time.Time
+	// The DER-encoded certificate
+	Certificate []byte
+}
+
+// Encode will return a Java KeyStore containing the given certificates in the order they are provided, protected by
+// the given password.
+func Encode(certificates []TrustedCertificate, password string) ([]byte, error) {
+	if len(certificates) == 0 {
+		return nil, fmt.Errorf("jks: no certificates")
+	}
+
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.BigEndian, uint32(magic))
+	binary.Write(buf, binary.BigEndian, uint32(version))
+	binary.Write(buf, binary.BigEndian, uint32(len(certificates)))
+
+	aliases := map[string]bool{}
+	for i, certificate := range certificates {
+		if certificate.Alias == "" || certificate.Alias != strings.ToLower(certificate.Alias) {
+			return nil, fmt.Errorf("jks: invalid alias at index %d", i)
+		}
+		if aliases[certificate.Alias] {
+			return nil, fmt.Errorf("jks: duplicate alias %s", certificate.Alias)
+		}
+		aliases[certificate.Alias] = true
+		if len(certificate.Certificate) == 0 {
+			return nil, fmt.Errorf("jks: invalid certificate at index %d", i)
+		}
+
+		binary.Write(buf, binary.BigEndian, uint32(tagTrustedCertificate))
+		if err := writeUTF(buf, certificate.Alias); err != nil {
+			return nil, err
+		}
+		binary.Write(buf, binary.BigEndian, certificate.Date.UnixMilli())
+		if err := writeUTF(buf, certificateType); err != nil {
+			return nil, err
+		}
+		binary.Write(buf, binary.BigEndian, uint32(len(certificate.Certificate)))
+		buf.Write(certificate.Certificate)
+	}
+
+	buf.Write(integrityDigest(buf.Bytes(), password))
+	return buf.Bytes(), nil
+}
+
+// Decode will verify the integrity of the given Java KeyStore and return its certificates. Only keystores that
+// contain trusted certificate entries are supported.
+func Decode(data []byte, password string) ([]TrustedCertificate, error) {
+	if len(data) < headerLength+integrityDigestLength {
+		return nil, fmt.Errorf("jks: truncated data")
+	}
+	content := data[:len(data)-integrityDigestLength]
+	if subtle.ConstantTimeCompare(integrityDigest(content, password), data[len(content):]) != 1 {
+		return nil, fmt.Errorf("jks: incorrect password or corrupt data")
+	}
+
+	r := bytes.NewReader(content)
+	var header [3]uint32
+	binary.Read(r, binary.BigEndian, &header)
+	if header[0] != magic {
+		return nil, fmt.Errorf("jks: invalid magic")
+	}
+	if header[1] != version {
+		return nil, fmt.Errorf("jks: unsupported version %d", header[1])
+	}
+
+	certificates := []TrustedCertificate{}
+	for i := uint32(0); i < header[2]; i++ {
+		var tag uint32
+		if err := binary.Read(r, binary.BigEndian, &tag); err != nil {
+			return nil, fmt.Errorf("jks: truncated data")
+		}
+		if tag != tagTrustedCertificate {
+			return nil, fmt.Errorf("jks: unsupported entry type %d", tag)
+		}
+		alias, err := readUTF(r)
+		if err != nil {
+			return nil, err
+		}
+		var date int64
+		if err := binary.Read(r, binary.BigEndian, &date); err != nil {
+			return nil, fmt.Errorf("jks: truncated data")
+		}
+		certType, err := readUTF(r)
+		if err != nil {
+			return nil, err
+		}
+		if certType != certificateType {
+			return nil, fmt.Errorf("jks: unsupported certificate type %s", certType)
+		}
+		var length uint32
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return nil, fmt.Errorf("jks: truncated data")
+		}
+		if int64(length) > int64(r.Len()) {
+			return nil, fmt.Errorf("jks: truncated data")
+		}
+		certificate := make([]byte, length)
+		r.Read(certificate)
+
+		certificates = append(certificates, TrustedCertificate{
+			Alias:       alias,
+			Date:        time.UnixMilli(date).UTC(),
+			Certificate: certificate,
+		})
+	}
+	if r.Len() > 0 {
+		return nil, fmt.Errorf("jks: trailing data")
+	}
+	return certificates, nil
+}
+
+// integrityDigest returns the SHA-1 digest of the password as UTF-16, the phrase "Mighty Aphrodite", and the data
+func integrityDigest(data []byte, password string) []byte {
+	h := sha1.New()
+	for _, unit := range utf16.Encode([]rune(password)) {
+		h.Write([]byte{byte(unit >> 8), byte(unit)})
+	}
+	h.Write([]byte(integrityDigestPhrase))
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// writeUTF writes s in the modified UTF-8 encoding used by Java's DataOutput.writeUTF
+func writeUTF(buf *bytes.Buffer, s string) error {
+	encoded := []byte{}
+	for _, unit := range utf16.Encode([]rune(s)) {
+		switch {
+		case unit != 0 && unit < 0x80:
+			encoded = append(encoded, byte(unit))
+		case unit < 0x800:
+			encoded = append(encoded, byte(0xC0|unit>>6), byte(0x80|unit&0x3F))
+		default:
+			encoded = append(encoded, byte(0xE0|unit>>12), byte(0x80|(unit>>6)&0x3F), byte(0x80|unit&0x3F))
+		}
+	}
+	if len(encoded) > maxModifiedUTF8Length {
+		return fmt.Errorf("jks: string too long")
+	}
+	binary.Write(buf, binary.BigEndian, uint16(len(encoded)))
+	buf.Write(encoded)
+	return nil
+}
+
+// readUTF reads a string in the modified UTF-8 encoding used by Java's DataInput.readUTF
+func readUTF(r *bytes.Reader) (string, error) {
+	var length uint16
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return "", fmt.Errorf("jks: truncated data")
+	}
+	if int(length) > r.Len() {
+		return "", fmt.Errorf("jks: truncated data")
+	}
+	encoded := make([]byte, length)
+	r.Read(encoded)
+
+	units := []uint16{}
+	for i := 0; i < len(encoded); {
+		switch b := encoded[i]; {
+		case b < 0x80:
+			units = append(units, uint16(b))
+			i++
+		case b&0xE0 == 0xC0 && i+1 < len(encoded):
+			units = append(units, uint16(b&0x1F)<<6|uint16(encoded[i+1]&0x3F))
+			i += 2
+		case b&0xF0 == 0xE0 && i+2 < len(encoded):
+			units = append(units, uint16(b&0x0F)<<12|uint16(encoded[i+1]&0x3F)<<6|uint16(encoded[i+2]&0x3F))
+			i += 3
+		default:
+			return "", fmt.Errorf("jks: invalid string")
+		}
+	}
+	return string(utf16.Decode(units)), nil
+}
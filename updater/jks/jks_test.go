@@ -0,0 +1,110 @@
+package jks
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tls-inspector/rootca/updater/internal/testcert"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	certificates := []TrustedCertificate{
+		{Alias: "root a", Date: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), Certificate: testcert.Certificate(t, "root_a")},
+		{Alias: "räksmörgås \U0001F512", Date: time.Date(2024, 5, 1, 12, 30, 0, 123000000, time.UTC), Certificate: testcert.Certificate(t, "root_b")},
+	}
+
+	data, err := Encode(certificates, "changeit")
+	if err != nil {
+		t.Fatalf("Error encoding keystore: %s", err.Error())
+	}
+	again, err := Encode(certificates, "changeit")
+	if err != nil {
+		t.Fatalf("Error encoding keystore: %s", err.Error())
+	}
+	if !bytes.Equal(data, again) {
+		t.Errorf("Keystore is not deterministic")
+	}
+
+	decoded, err := Decode(data, "changeit")
+	if err != nil {
+		t.Fatalf("Error decoding keystore: %s", err.Error())
+	}
+	if !reflect.DeepEqual(decoded, certificates) {
+		t.Errorf("Decoded certificates do not match\n%+v\n%+v", decoded, certificates)
+	}
+
+	if _, err := Decode(data, "password"); err == nil {
+		t.Errorf("No error seen for incorrect password")
+	}
+	corrupt := bytes.Clone(data)
+	corrupt[len(corrupt)/2] ^= 0xFF
+	if _, err := Decode(corrupt, "changeit"); err == nil {
+		t.Errorf("No error seen for corrupt data")
+	}
+	if _, err := Decode(data[:headerLength], "changeit"); err == nil {
+		t.Errorf("No error seen for truncated data")
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	certificate := testcert.Certificate(t, "root_a")
+	for _, test := range []struct {
+		name         string
+		certificates []TrustedCertificate
+	}{
+		{name: "no certificates", certificates: nil},
+		{name: "empty alias", certificates: []TrustedCertificate{{Certificate: certificate}}},
+		{name: "uppercase alias", certificates: []TrustedCertificate{{Alias: "Root", Certificate: certificate}}},
+		{name: "duplicate alias", certificates: []TrustedCertificate{{Alias: "root", Certificate: certificate}, {Alias: "root", Certificate: certificate}}},
+		{name: "empty certificate", certificates: []TrustedCertificate{{Alias: "root"}}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := Encode(test.certificates, "changeit"); err == nil {
+				t.Errorf("No error seen")
+			}
+		})
+	}
+}
+
+// TestDecodeKeytool reads a keystore created by keytool from the certificates in the testcert package with:
+//
+//	keytool -importcert -noprompt -keystore keytool.jks -storetype JKS -storepass changeit -alias "root a" -file root_a.pem
+//	keytool -importcert -noprompt -keystore keytool.jks -storetype JKS -storepass changeit -alias "root b" -file root_b.pem
+//
+// As the entry dates are read from the keystore, encoding its entries must reproduce it exactly.
+func TestDecodeKeytool(t *testing.T) {
+	fixture, err := os.ReadFile("testdata/keytool.jks")
+	if os.IsNotExist(err) {
+		t.Skip("No keystore created by keytool")
+	}
+	if err != nil {
+		t.Fatalf("Error reading keystore: %s", err.Error())
+	}
+	decoded, err := Decode(fixture, "changeit")
+	if err != nil {
+		t.Fatalf("Error decoding keystore: %s", err.Error())
+	}
+	certificates := map[string][]byte{
+		"root a": testcert.Certificate(t, "root_a"),
+		"root b": testcert.Certificate(t, "root_b"),
+	}
+	if len(decoded) != len(certificates) {
+		t.Fatalf("Expected %d certificates, got %d", len(certificates), len(decoded))
+	}
+	for _, certificate := range decoded {
+		if !bytes.Equal(certificate.Certificate, certificates[certificate.Alias]) {
+			t.Errorf("Unexpected certificate for %s", certificate.Alias)
+		}
+	}
+
+	data, err := Encode(decoded, "changeit")
+	if err != nil {
+		t.Fatalf("Error encoding keystore: %s", err.Error())
+	}
+	if !bytes.Equal(data, fixture) {
+		t.Errorf("Encoded keystore does not match keytool")
+	}
+}
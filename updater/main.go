@@ -4,7 +4,6 @@ import (
 	"log"
 	"os"
 	"path/filepath"
-	"sync"
 	"time"
 )
 
@@ -17,7 +16,17 @@ func main() {
 
 	log.Printf("rootca version %s\n", Version)
 
-	if len(publicKeyBytes) > 0 && len(privateKeyBytes) > 0 {
+	if diffMode {
+		if err := diffBundles(diffSources[0], diffSources[1], diffSigningKey, jsonOutput); err != nil {
+			logFatal("Error comparing bundles: %s", err.Error())
+		}
+		return
+	}
+
+	if err := loadSigningKeys(); err != nil {
+		logFatal("Error loading signing keys: %s", err.Error())
+	}
+	if signingPrivateKey != nil {
 		log.Printf("signing enabled, using public key:\n%s", publicKeyBytes)
 	}
 
@@ -25,7 +34,9 @@ func main() {
 
 	if _, err := os.Stat(".force_update"); err == nil {
 		forceUpdate = true
-		os.Remove(".force_update")
+		if !dryRun {
+			os.Remove(".force_update")
+		}
 	}
 
 	metadata, err := readMetadata()
@@ -33,123 +44,109 @@ func main() {
 		logFatal("Error reading bundle metadata file: %s", err.Error())
 	}
 
-	var mozillaMetadata *VendorMetadata
-	var microsoftMetadata *VendorMetadata
-	var googleMetadata *VendorMetadata
-	var appleMetadata *VendorMetadata
-	var tlsinspectorMetadata *VendorMetadata
-
-	wg := &sync.WaitGroup{}
-	wg.Add(4)
-
-	go func() {
-		defer wg.Done()
-		if metadata != nil {
-			mozillaMetadata = &metadata.Mozilla
+	if appleReleases {
+		releaseVendors, err := getAppleReleaseVendors(appleTagRange)
+		if err != nil {
+			logFatal("Error listing Apple releases: %s", err.Error())
 		}
-
-		newMozillaMetadata, err := buildMozillaBundle(mozillaMetadata)
+		vendors = append(vendors, releaseVendors...)
+	}
+	if chromeVersions {
+		versionVendors, err := getChromeVersionVendors(metadata)
 		if err != nil {
-			logFatal("Error updating mozilla bundle: %s", err.Error())
+			logFatal("Error listing Chrome Root Store versions: %s", err.Error())
 		}
-
-		if err := signBundle(MozillaBundleName); err != nil {
-			logFatal("Error signing mozilla bundle: %s", err.Error())
+		vendors = append(vendors, versionVendors...)
+	}
+	if len(chromeRefs) > 0 {
+		refVendors, err := getChromeRefVendors(chromeRefs)
+		if err != nil {
+			logFatal("Error reading Chromium refs: %s", err.Error())
 		}
+		vendors = append(vendors, refVendors...)
+	}
 
-		mozillaMetadata = newMozillaMetadata
-	}()
-
-	go func() {
-		defer wg.Done()
-		if metadata != nil {
-			microsoftMetadata = &metadata.Microsoft
-		}
+	policyVendors, err := loadPolicyVendors(policyFile)
+	if err != nil {
+		logFatal("Error loading bundle policies: %s", err.Error())
+	}
+	vendors = append(vendors, policyVendors...)
 
-		newMicrosoftMetadata, err := buildMicrosoftBundle(microsoftMetadata)
+	if dryRun {
+		plans, err := planVendors(vendors, metadata)
 		if err != nil {
-			logFatal("Error updating microsoft bundle: %s", err.Error())
+			logFatal("Error planning bundles: %s", err.Error())
 		}
-
-		if err := signBundle(MicrosoftBundleName); err != nil {
-			logFatal("Error signing microsoft bundle: %s", err.Error())
+		printVendorPlans(plans)
+		log.Printf("Finished in %s\n", time.Since(start).String())
+		for _, plan := range plans {
+			if plan.Result.Status == vendorFailed {
+				os.Exit(1)
+			}
 		}
+		return
+	}
 
-		microsoftMetadata = newMicrosoftMetadata
-	}()
-
-	go func() {
-		defer wg.Done()
-		if metadata != nil {
-			googleMetadata = &metadata.Google
-		}
+	oldCerts, err := readVendorCertificates()
+	if err != nil {
+		logFatal("Error reading existing bundles: %s", err.Error())
+	}
 
-		newGoogleMetadata, err := buildGoogleBundle(googleMetadata)
-		if err != nil {
-			logFatal("Error updating google bundle: %s", err.Error())
-		}
+	newMetadata, results, err := buildVendors(vendors, metadata, ".")
+	if err != nil {
+		logFatal("Error building bundles: %s", err.Error())
+	}
 
-		if err := signBundle(GoogleBundleName); err != nil {
-			logFatal("Error signing google bundle: %s", err.Error())
+	// Per-release Apple bundles and versioned Chrome Root Store bundles are only built when requested, but are kept
+	// otherwise
+	for id, vendorMetadata := range metadata {
+		if _, ok := newMetadata[id]; ok {
+			continue
 		}
-
-		googleMetadata = newGoogleMetadata
-	}()
-
-	go func() {
-		defer wg.Done()
-		if metadata != nil {
-			appleMetadata = &metadata.Apple
+		if _, ok := restoreChromeRootStoreVendor(id, vendorMetadata).(chromeRootStoreVendor); ok || isAppleReleaseVendor(id) {
+			newMetadata[id] = vendorMetadata
 		}
+	}
 
-		newAppleMetadata, err := buildAppleBundle(appleMetadata)
-		if err != nil {
-			logFatal("Error updating apple bundle: %s", err.Error())
+	// Tags never change, but more OS releases may ship a tag after its bundle was built, so the releases of unchanged
+	// per-release Apple bundles are refreshed
+	for _, vendor := range vendors {
+		releaseVendor, ok := vendor.(appleReleaseVendor)
+		if !ok {
+			continue
 		}
-
-		if err := signBundle(AppleBundleName); err != nil {
-			logFatal("Error signing apple bundle: %s", err.Error())
+		if vendorMetadata, ok := newMetadata[vendor.ID()]; ok {
+			vendorMetadata.Releases = releaseVendor.releases
+			newMetadata[vendor.ID()] = vendorMetadata
 		}
-
-		appleMetadata = newAppleMetadata
-	}()
-
-	wg.Wait()
-
-	// Build TLS Inspector bundle after all others
-	if metadata != nil {
-		tlsinspectorMetadata = &metadata.TLSInspector
 	}
-	newTLSInspectorMetadata, err := buildTLSInspectorBundle(tlsinspectorMetadata)
+
+	changes, err := changelogFromResults(results, metadata, newMetadata, oldCerts)
 	if err != nil {
-		logFatal("Error updating tlsinspector bundle: %s", err.Error())
+		logFatal("Error generating changelog: %s", err.Error())
 	}
-	if err := signBundle(TLSInspectorBundleName); err != nil {
-		logFatal("Error signing tlsinspector bundle: %s", err.Error())
-	}
-	tlsinspectorMetadata = newTLSInspectorMetadata
-
-	newMetadata := BundleMetadata{
-		Apple:        *appleMetadata,
-		Google:       *googleMetadata,
-		Microsoft:    *microsoftMetadata,
-		Mozilla:      *mozillaMetadata,
-		TLSInspector: *tlsinspectorMetadata,
+	if len(changes.Vendors) > 0 {
+		if err := writeChangelog(changes); err != nil {
+			logFatal("Error writing changelog: %s", err.Error())
+		}
 	}
 
 	if err := writeMetadata(newMetadata); err != nil {
 		logFatal("Error writing metadata file: %s", err.Error())
 	}
 
-	if err := signFile(BundleMetadataName); err != nil {
-		logFatal("Error signing bundle metadata: %s", err.Error())
-	}
-
-	if err := ExportReport(); err != nil {
+	if err := ExportReport(newMetadata); err != nil {
 		logFatal("Error exporting certificate report: %s", err.Error())
 	}
 
+	logVendorResults(results)
 	log.Printf("Finished in %s\n", time.Since(start).String())
+
+	for _, result := range results {
+		if result.Status == vendorFailed {
+			os.Exit(1)
+		}
+	}
 }
 
 func validateWorkdir() {
@@ -1,79 +1,108 @@
 package main
 
 import (
-	"encoding/json"
+	"encoding/pem"
+	"fmt"
 	"os"
-	"time"
+	"path"
+	"sort"
+
+	"github.com/tls-inspector/rootca/updater/rootca"
 )
 
-const BundleMetadataName = "bundle_metadata.json"
+const BundleMetadataName = rootca.MetadataFileName
+const BundleMetadataV2Name = rootca.MetadataV2FileName
 
-type BundleMetadata struct {
-	Mozilla      VendorMetadata `json:"mozilla"`
-	Microsoft    VendorMetadata `json:"microsoft"`
-	Google       VendorMetadata `json:"google"`
-	Apple        VendorMetadata `json:"apple"`
-	TLSInspector VendorMetadata `json:"tls_inspector"`
-}
+type BundleMetadata = rootca.BundleMetadata
+type VendorMetadata = rootca.VendorMetadata
+type BundleFingerprint = rootca.BundleFingerprint
+type CertificateMetadata = rootca.CertificateMetadata
 
-type VendorMetadata struct {
-	Date     string                       `json:"date"`
-	Key      string                       `json:"key"`
-	Bundles  map[string]BundleFingerprint `json:"bundles"`
-	NumCerts int                          `json:"num_certs"`
-}
+// readMetadata will read the version 2 metadata file, falling back to the version 1 file if it does not exist. Returns
+// nil if neither file exists.
+func readMetadata() (BundleMetadata, error) {
+	for _, fileName := range []string{BundleMetadataV2Name, BundleMetadataName} {
+		if _, err := os.Stat(fileName); err != nil {
+			continue
+		}
 
-func (m VendorMetadata) MustDate() time.Time {
-	d, err := time.Parse("2006-01-02T15:04:05Z07:00", m.Date)
-	if err != nil {
-		panic(err)
+		data, err := os.ReadFile(fileName)
+		if err != nil {
+			return nil, err
+		}
+
+		metadata, _, err := rootca.ParseMetadata(data)
+		if err != nil {
+			return nil, err
+		}
+		return metadata, nil
 	}
-	return d
-}
 
-type BundleFingerprint struct {
-	SHA1   string `json:"sha1"`
-	SHA256 string `json:"sha256"`
-	SHA512 string `json:"sha512"`
+	return nil, nil
 }
 
-func readMetadata() (*BundleMetadata, error) {
-	if _, err := os.Stat(BundleMetadataName); err != nil {
-		return nil, nil
+// writeMetadata will write and sign the version 2 metadata file, and the version 1 metadata file unless legacy
+// metadata is disabled. Any vendor without certificate metadata, such as those read from a version 1 file, will have
+// it populated from its existing bundle.
+func writeMetadata(metadata BundleMetadata) error {
+	for id, vendorMetadata := range metadata {
+		if vendorMetadata.Certificates != nil {
+			continue
+		}
+		vendor := getVendor(id)
+		if vendor == nil {
+			continue
+		}
+		certificates, err := readCertificateMetadata(".", vendor.BundleName())
+		if err != nil {
+			return err
+		}
+		vendorMetadata.Certificates = certificates
+		metadata[id] = vendorMetadata
 	}
 
-	f, err := os.OpenFile(BundleMetadataName, os.O_RDONLY, 0644)
+	files := map[string][]byte{}
+
+	v2Data, err := rootca.MarshalMetadata(metadata, rootca.MetadataSchemaVersion)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer f.Close()
+	files[BundleMetadataV2Name] = v2Data
 
-	metadata := BundleMetadata{}
-	if err := json.NewDecoder(f).Decode(&metadata); err != nil {
-		return nil, err
+	if !noLegacyMetadata {
+		v1Data, err := rootca.MarshalMetadata(metadata, 1)
+		if err != nil {
+			return err
+		}
+		files[BundleMetadataName] = v1Data
 	}
 
-	return &metadata, nil
+	return writeSignedFiles(files)
 }
 
-func writeMetadata(metadata BundleMetadata) error {
-	f, err := os.OpenFile(BundleMetadataName+"_atomic", os.O_CREATE|os.O_RDWR, 0644)
+// readCertificateMetadata will describe every certificate in the PEM bundle with the given name in bundleDir, sorted by
+// SHA-256
+func readCertificateMetadata(bundleDir, bundleName string) ([]CertificateMetadata, error) {
+	pemData, err := os.ReadFile(path.Join(bundleDir, bundleName+".pem"))
 	if err != nil {
-		return err
-	}
-
-	j := json.NewEncoder(f)
-	j.SetIndent("", "  ")
-
-	if err := j.Encode(metadata); err != nil {
-		f.Close()
-		return err
+		return nil, err
 	}
-	f.Close()
 
-	if err := os.Rename(BundleMetadataName+"_atomic", BundleMetadataName); err != nil {
-		return err
+	certificates := []CertificateMetadata{}
+	for _, certPem := range rootca.ExtractPEMCertificates(pemData) {
+		block, _ := pem.Decode(certPem)
+		if block == nil {
+			return nil, fmt.Errorf("%s.pem: invalid certificate", bundleName)
+		}
+		certificate, err := rootca.NewCertificateMetadata(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, certificate)
 	}
+	sort.Slice(certificates, func(i, j int) bool {
+		return certificates[i].SHA256 < certificates[j].SHA256
+	})
 
-	return nil
+	return certificates, nil
 }
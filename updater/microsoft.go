@@ -3,16 +3,21 @@ package main
 import (
 	"crypto/sha256"
 	"crypto/x509"
+	_ "embed"
+	"encoding/asn1"
 	"encoding/json"
 	"fmt"
 	"log"
+	"math/big"
 	"os"
-	"os/exec"
 	"path"
 	"strings"
 	"time"
 
 	"github.com/tls-inspector/authrootstl"
+	"github.com/tls-inspector/rootca/updater/cab"
+	"github.com/tls-inspector/rootca/updater/pkcs7"
+	"github.com/tls-inspector/rootca/updater/rootca"
 )
 
 type microsoftBundleCacheType struct {
@@ -20,30 +25,48 @@ type microsoftBundleCacheType struct {
 }
 
 const MicrosoftBundleName = "microsoft_ca_bundle"
+const microsoftVendorID = "microsoft"
 const microsoftBundleCacheName = ".microsoft_cache.json"
 
-func buildMicrosoftBundle(metadata *VendorMetadata) (*VendorMetadata, error) {
-	bundleCache := loadMicrosoftBundleCache()
-	subjects, currentSHA, err := getMicrosoftSubjects()
+type microsoftVendor struct{}
+
+// microsoftVersion holds the subjects and EV policies from the authroot.stl and the bundle cache for a version of the
+// Microsoft bundle
+type microsoftVersion struct {
+	subjects    []authrootstl.Subject
+	evPolicies  map[string][]string
+	bundleCache microsoftBundleCacheType
+}
+
+func (microsoftVendor) ID() string         { return microsoftVendorID }
+func (microsoftVendor) Name() string       { return "Microsoft" }
+func (microsoftVendor) BundleName() string { return MicrosoftBundleName }
+func (microsoftVendor) Inputs() []string   { return nil }
+
+func (microsoftVendor) LatestVersion(bundleDir string) (*VendorVersion, error) {
+	subjects, evPolicies, currentSHA, err := getMicrosoftSubjects()
 	if err != nil {
 		return nil, fmt.Errorf("unable to get microsoft subjects: %s", err.Error())
 	}
-	if metadata != nil && !forceUpdate {
-		if isBundleUpToDate(currentSHA, metadata.Key, MicrosoftBundleName) {
-			logNotice("Microsoft bundle is up-to-date")
-			return metadata, nil
-		}
-		logWarning("Detected changes to Microsoft bundle. LastSHA='%s' LatestSHA='%s'", metadata.Key, currentSHA)
-	}
-	log.Printf("Building Microsoft CA bundle")
 
-	tmpDir, err := os.MkdirTemp("", "microsoft")
-	if err != nil {
-		panic(err)
-	}
-	defer os.RemoveAll(tmpDir)
-	if fileExists(MicrosoftBundleName + ".p7b") {
-		if err := extractP7B(MicrosoftBundleName+".p7b", tmpDir); err != nil {
+	return &VendorVersion{
+		Key: currentSHA,
+		Extra: &microsoftVersion{
+			subjects:    subjects,
+			evPolicies:  evPolicies,
+			bundleCache: loadMicrosoftBundleCache(bundleDir),
+		},
+	}, nil
+}
+
+// Fetch will download all trusted certificates for the subjects in the authroot.stl. Certificates from the existing
+// bundle are reused when possible, and expired certificates are added to the bundle cache.
+func (microsoftVendor) Fetch(version *VendorVersion, bundleDir, dir string) ([]string, error) {
+	msVersion := version.Extra.(*microsoftVersion)
+	bundleCache := &msVersion.bundleCache
+
+	if bundlePath := path.Join(bundleDir, MicrosoftBundleName+".p7b"); fileExists(bundlePath) {
+		if err := extractP7B(bundlePath, dir); err != nil {
 			return nil, fmt.Errorf("error extracting microsoft certificates: %s", err.Error())
 		}
 	}
@@ -51,7 +74,7 @@ func buildMicrosoftBundle(metadata *VendorMetadata) (*VendorMetadata, error) {
 	thumbprintMap := map[string]bool{}
 
 	certPaths := []string{}
-	for _, subject := range subjects {
+	for _, subject := range msVersion.subjects {
 		if subject.DisabledDate != nil {
 			continue
 		}
@@ -70,7 +93,7 @@ func buildMicrosoftBundle(metadata *VendorMetadata) (*VendorMetadata, error) {
 		}
 		thumbprintMap[subject.SHA256Fingerprint] = true
 
-		certPath := path.Join(tmpDir, subject.SHA256Fingerprint+".crt")
+		certPath := path.Join(dir, subject.SHA256Fingerprint+".crt")
 
 		if fileExists(certPath) {
 			if !verifyCertPEMSHA(certPath, subject.SHA256Fingerprint) {
@@ -83,7 +106,7 @@ func buildMicrosoftBundle(metadata *VendorMetadata) (*VendorMetadata, error) {
 			}
 		}
 
-		derCertPath := path.Join(tmpDir, subject.SHA1Fingerprint+".bin")
+		derCertPath := path.Join(dir, subject.SHA1Fingerprint+".bin")
 
 		if err := downloadFile(fmt.Sprintf("http://ctldl.windowsupdate.com/msdownload/update/v3/static/trustedr/en/%s.crt", subject.SHA1Fingerprint), derCertPath); err != nil {
 			return nil, err
@@ -112,7 +135,7 @@ func buildMicrosoftBundle(metadata *VendorMetadata) (*VendorMetadata, error) {
 		certPaths = append(certPaths, certPath)
 	}
 
-	certFiles, err := os.ReadDir(tmpDir)
+	certFiles, err := os.ReadDir(dir)
 	if err != nil {
 		return nil, err
 	}
@@ -124,59 +147,254 @@ func buildMicrosoftBundle(metadata *VendorMetadata) (*VendorMetadata, error) {
 		sha = sha[0 : len(sha)-4]
 
 		if !thumbprintMap[sha] {
-			os.Remove(path.Join(tmpDir, certFile.Name()))
+			os.Remove(path.Join(dir, certFile.Name()))
 			log.Printf("Removed unused Microsoft certificate %s", certFile.Name())
 		}
 	}
 
-	p7Fingerprints, pemFingerprints, err := generateBundleFromCertificates(certPaths, MicrosoftBundleName)
+	return certPaths, nil
+}
+
+// Build will generate the bundle and record the trust list attributes of each certificate in the metadata
+func (v microsoftVendor) Build(version *VendorVersion, bundleDir string, certPaths []string) (*VendorMetadata, error) {
+	msVersion := version.Extra.(*microsoftVersion)
+
+	metadata, err := generateVendorBundle(v, version, bundleDir, certPaths)
 	if err != nil {
 		return nil, err
 	}
 
-	saveMicrosoftBundleCache(&bundleCache)
-	logNotice("Microsoft CA bundle generated with %d certificates", len(certPaths))
+	subjectMap := map[string]authrootstl.Subject{}
+	for _, subject := range msVersion.subjects {
+		subjectMap[subject.SHA256Fingerprint] = subject
+	}
+	for i, certificate := range metadata.Certificates {
+		subject, ok := subjectMap[certificate.SHA256]
+		if !ok {
+			return nil, fmt.Errorf("no subject for certificate %s", certificate.SHA256)
+		}
+		metadata.Certificates[i].Microsoft = newMicrosoftAttributes(subject, msVersion.evPolicies[subject.SHA1Fingerprint])
+		metadata.Certificates[i].Trust = newMicrosoftTrust(subject)
+	}
 
-	return &VendorMetadata{
-		Date: time.Now().UTC().Format("2006-01-02T15:04:05Z07:00"),
-		Key:  currentSHA,
-		Bundles: map[string]BundleFingerprint{
-			MicrosoftBundleName + ".p7b": *p7Fingerprints,
-			MicrosoftBundleName + ".pem": *pemFingerprints,
-		},
-		NumCerts: len(certPaths),
-	}, nil
+	saveMicrosoftBundleCache(bundleDir, &msVersion.bundleCache)
+	return metadata, nil
 }
 
-func getMicrosoftSubjects() ([]authrootstl.Subject, string, error) {
-	dlDir, err := os.MkdirTemp("", "authrootstl")
-	if err != nil {
-		return nil, "", fmt.Errorf("error making download directory for authrootstl: %s", err.Error())
+func oidStrings(oids []asn1.ObjectIdentifier) []string {
+	values := make([]string, len(oids))
+	for i, oid := range oids {
+		values[i] = oid.String()
 	}
-	defer os.RemoveAll(dlDir)
+	return values
+}
 
-	cabPath := path.Join(dlDir, "authrootstl.cab")
-	stlPath := path.Join(dlDir, "authroot.stl")
-	if err := downloadFile("http://ctldl.windowsupdate.com/msdownload/update/v3/static/trustedr/en/authrootstl.cab", cabPath); err != nil {
-		return nil, "", fmt.Errorf("error downloading authrootstl.cab: %s", err.Error())
+func newMicrosoftAttributes(subject authrootstl.Subject, evPolicies []string) *rootca.MicrosoftAttributes {
+	attributes := &rootca.MicrosoftAttributes{
+		FriendlyName: subject.FriendlyName,
+		EKUs:         oidStrings(subject.MicrosoftExtendedKeyUsage),
+		EVPolicies:   evPolicies,
 	}
-	if err := exec.Command(cabextractPath, "-d", dlDir, cabPath).Run(); err != nil {
-		return nil, "", fmt.Errorf("eror extracting authrootstl.cab: %s", err.Error())
+	if subject.NotBefore != nil {
+		attributes.NotBefore = subject.NotBefore.UTC().Format("2006-01-02T15:04:05Z07:00")
+		attributes.NotBeforeEKUs = oidStrings(subject.NotBeforeEKU)
 	}
-	if _, err := os.Stat(stlPath); err != nil {
-		return nil, "", fmt.Errorf("authroot.stl does not exist or not accessable: %s", err.Error())
+	return attributes
+}
+
+// newMicrosoftTrust will describe the trust of the subject for server authentication, email protection, and code
+// signing. A NotBefore date on the subject applies as a distrust-after date to the NotBeforeEKUs, or to all trusted
+// usages if there are none.
+func newMicrosoftTrust(subject authrootstl.Subject) *rootca.CertificateTrust {
+	hasEKU := func(ekus []asn1.ObjectIdentifier, eku asn1.ObjectIdentifier) bool {
+		for _, e := range ekus {
+			if e.Equal(eku) {
+				return true
+			}
+		}
+		return false
+	}
+
+	trust := &rootca.CertificateTrust{}
+	if hasEKU(subject.MicrosoftExtendedKeyUsage, authrootstl.MicrosoftEKUServerAuthentication) {
+		trust.ServerAuth = rootca.TrustTrusted
+	}
+	if hasEKU(subject.MicrosoftExtendedKeyUsage, authrootstl.MicrosoftEKUSecureEmail) {
+		trust.EmailProtection = rootca.TrustTrusted
+	}
+	if hasEKU(subject.MicrosoftExtendedKeyUsage, authrootstl.MicrosoftEKUCodeSigning) {
+		trust.CodeSigning = rootca.TrustTrusted
+	}
+
+	if subject.NotBefore != nil {
+		notBefore := subject.NotBefore.UTC().Format("2006-01-02T15:04:05Z07:00")
+		if trust.ServerAuth != "" && (len(subject.NotBeforeEKU) == 0 || hasEKU(subject.NotBeforeEKU, authrootstl.MicrosoftEKUServerAuthentication)) {
+			trust.ServerDistrustAfter = notBefore
+		}
+		if trust.EmailProtection != "" && (len(subject.NotBeforeEKU) == 0 || hasEKU(subject.NotBeforeEKU, authrootstl.MicrosoftEKUSecureEmail)) {
+			trust.EmailDistrustAfter = notBefore
+		}
 	}
 
-	data, err := os.ReadFile(stlPath)
+	return trust
+}
+
+// getMicrosoftSubjects will download and parse the authroot.stl, returning the subjects, the EV policies of each subject
+// keyed by SHA-1 fingerprint, and the SHA-256 checksum of the authroot.stl
+func getMicrosoftSubjects() ([]authrootstl.Subject, map[string][]string, string, error) {
+	data, err := downloadMicrosoftTrustList("authrootstl.cab", "authroot.stl")
 	if err != nil {
-		return nil, "", fmt.Errorf("unable to read authroot.stl: %s", err.Error())
+		return nil, nil, "", err
 	}
+	return parseMicrosoftSubjects(data)
+}
+
+// parseMicrosoftSubjects will verify and parse the subjects of the given authroot.stl, returning the subjects, the EV
+// policies of each subject keyed by SHA-1 fingerprint, and the SHA-256 hash of the trust list
+func parseMicrosoftSubjects(data []byte) ([]authrootstl.Subject, map[string][]string, string, error) {
 	hash := fmt.Sprintf("%X", sha256.Sum256(data))
 	subjects, err := authrootstl.Parse(data)
 	if err != nil {
-		return nil, "", err
+		return nil, nil, "", err
+	}
+	// The signature was verified by authrootstl.Parse, so the EV policies are read without verifying it again
+	_, content, err := pkcs7.Content(data)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("authroot.stl: %s", err.Error())
+	}
+	trustList, err := parseMicrosoftTrustListContent(content)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("authroot.stl: %s", err.Error())
+	}
+	evPolicies, err := trustList.evPolicies()
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("authroot.stl: %s", err.Error())
+	}
+	return subjects, evPolicies, hash, nil
+}
+
+// downloadMicrosoftTrustList will download the given cab file from Windows Update and return the contents of the trust
+// list file within it
+func downloadMicrosoftTrustList(cabName, fileName string) ([]byte, error) {
+	cabData, err := httpGetBytes("http://ctldl.windowsupdate.com/msdownload/update/v3/static/trustedr/en/" + cabName)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading %s: %s", cabName, err.Error())
+	}
+	cabinet, err := cab.Parse(cabData)
+	if err != nil {
+		return nil, fmt.Errorf("error extracting %s: %s", cabName, err.Error())
+	}
+	data, err := cabinet.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %s", fileName, err.Error())
+	}
+	return data, nil
+}
+
+// Microsoft trust list subject attributes that are not exposed by the authrootstl package
+const (
+	microsoftAttributeEVPolicies = "1.3.6.1.4.1.311.10.11.83"
+	microsoftAttributeSHA256     = "1.3.6.1.4.1.311.10.11.98"
+)
+
+//go:embed microsoft_root_ca_2010.crt
+var microsoftRootCAData []byte
+
+// microsoftTrustList is a Microsoft certificate trust list, such as authroot.stl or disallowedcert.stl
+type microsoftTrustList struct {
+	ThisUpdate time.Time
+	Subjects   []microsoftTrustListSubject
+}
+
+// microsoftTrustListSubject is a single subject in a certificate trust list
+type microsoftTrustListSubject struct {
+	// The uppercase hex SHA-1 fingerprint of the certificate
+	SHA1 string
+	// The first value of each attribute of the subject, keyed by OID
+	Attributes map[string][]byte
+}
+
+// parseMicrosoftTrustList will verify the signature of the given certificate trust list and parse its subjects
+func parseMicrosoftTrustList(data []byte) (*microsoftTrustList, error) {
+	rootCA, err := x509.ParseCertificate(microsoftRootCAData)
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(rootCA)
+
+	// The signing certificate of trust lists may expire before Microsoft publishes an update, which Windows accepts
+	signedContent, err := pkcs7.Verify(data, pkcs7.VerifyOptions{Roots: roots, IgnoreExpiry: true})
+	if err != nil {
+		return nil, err
+	}
+	return parseMicrosoftTrustListContent(signedContent.Content)
+}
+
+// parseMicrosoftTrustListContent will parse the subjects of the given DER-encoded certificate trust list content
+func parseMicrosoftTrustListContent(content []byte) (*microsoftTrustList, error) {
+	var ctlInfo struct {
+		Version         int `asn1:"optional,default:0"`
+		Usage           asn1.RawValue
+		ListIdentifier  asn1.BitString `asn1:"optional"`
+		SequenceNumber  *big.Int       `asn1:"optional"`
+		ThisUpdate      time.Time
+		NextUpdate      time.Time `asn1:"optional"`
+		Algorithm       asn1.RawValue
+		TrustedSubjects []struct {
+			SubjectIdentifier []byte
+			SubjectAttributes []struct {
+				Identifier asn1.ObjectIdentifier
+				Values     [][]byte `asn1:"set"`
+			} `asn1:"set"`
+		} `asn1:"optional"`
+	}
+	if _, err := asn1.Unmarshal(content, &ctlInfo); err != nil {
+		return nil, err
+	}
+
+	trustList := &microsoftTrustList{
+		ThisUpdate: ctlInfo.ThisUpdate,
+		Subjects:   make([]microsoftTrustListSubject, len(ctlInfo.TrustedSubjects)),
+	}
+	for i, trustedSubject := range ctlInfo.TrustedSubjects {
+		subject := microsoftTrustListSubject{
+			SHA1:       fmt.Sprintf("%X", trustedSubject.SubjectIdentifier),
+			Attributes: map[string][]byte{},
+		}
+		for _, attribute := range trustedSubject.SubjectAttributes {
+			if len(attribute.Values) > 0 {
+				subject.Attributes[attribute.Identifier.String()] = attribute.Values[0]
+			}
+		}
+		trustList.Subjects[i] = subject
+	}
+
+	return trustList, nil
+}
+
+// evPolicies will return the EV policy OIDs of every subject in the trust list, keyed by the SHA-1 fingerprint of the
+// subject
+func (l *microsoftTrustList) evPolicies() (map[string][]string, error) {
+	evPolicies := map[string][]string{}
+	for _, subject := range l.Subjects {
+		value, ok := subject.Attributes[microsoftAttributeEVPolicies]
+		if !ok {
+			continue
+		}
+
+		var policies []struct {
+			Policy     asn1.ObjectIdentifier
+			Qualifiers asn1.RawValue `asn1:"optional"`
+		}
+		if _, err := asn1.Unmarshal(value, &policies); err != nil {
+			return nil, fmt.Errorf("invalid ev policies for %s: %s", subject.SHA1, err.Error())
+		}
+		for _, policy := range policies {
+			evPolicies[subject.SHA1] = append(evPolicies[subject.SHA1], policy.Policy.String())
+		}
 	}
-	return subjects, hash, nil
+	return evPolicies, nil
 }
 
 func microsoftCertificateIsExpired(derCertPath string) (bool, error) {
@@ -191,10 +409,11 @@ func microsoftCertificateIsExpired(derCertPath string) (bool, error) {
 	return time.Since(cert.NotAfter) > -7*(24*time.Hour), nil // cert expires within 7 days or already expired
 }
 
-func loadMicrosoftBundleCache() microsoftBundleCacheType {
+func loadMicrosoftBundleCache(bundleDir string) microsoftBundleCacheType {
+	cachePath := path.Join(bundleDir, microsoftBundleCacheName)
 	bundleCache := microsoftBundleCacheType{}
-	if _, err := os.Stat(microsoftBundleCacheName); err == nil {
-		f, err := os.Open(microsoftBundleCacheName)
+	if _, err := os.Stat(cachePath); err == nil {
+		f, err := os.Open(cachePath)
 		if err != nil {
 			log.Printf("Error reading microsoft bundle cache (ignoring error): %s", err.Error())
 			return bundleCache
@@ -209,22 +428,23 @@ func loadMicrosoftBundleCache() microsoftBundleCacheType {
 	return bundleCache
 }
 
-func saveMicrosoftBundleCache(cache *microsoftBundleCacheType) {
-	f, err := os.OpenFile(microsoftBundleCacheName+"_atomic", os.O_CREATE|os.O_RDWR, 0644)
+func saveMicrosoftBundleCache(bundleDir string, cache *microsoftBundleCacheType) {
+	cachePath := path.Join(bundleDir, microsoftBundleCacheName)
+	f, err := os.OpenFile(cachePath+"_atomic", os.O_CREATE|os.O_RDWR, 0644)
 	if err != nil {
 		log.Printf("Error saving microsoft bundle cache (ignoring error): %s", err.Error())
-		os.Remove(microsoftBundleCacheName + "_atomic")
+		os.Remove(cachePath + "_atomic")
 		return
 	}
 	if err := json.NewEncoder(f).Encode(cache); err != nil {
 		log.Printf("Error saving microsoft bundle cache (ignoring error): %s", err.Error())
-		os.Remove(microsoftBundleCacheName + "_atomic")
+		os.Remove(cachePath + "_atomic")
 		return
 	}
 	f.Close()
-	if err := os.Rename(microsoftBundleCacheName+"_atomic", microsoftBundleCacheName); err != nil {
+	if err := os.Rename(cachePath+"_atomic", cachePath); err != nil {
 		log.Printf("Error saving microsoft bundle cache (ignoring error): %s", err.Error())
-		os.Remove(microsoftBundleCacheName + "_atomic")
-		os.Remove(microsoftBundleCacheName)
+		os.Remove(cachePath + "_atomic")
+		os.Remove(cachePath)
 	}
 }
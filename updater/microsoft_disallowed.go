@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"os"
+	"path"
+
+	"github.com/tls-inspector/rootca/updater/rootca"
+)
+
+const MicrosoftDisallowedBundleName = "microsoft_disallowed_bundle"
+const microsoftDisallowedVendorID = "microsoft_disallowed"
+
+// microsoftDisallowedVendor builds a bundle of the certificates explicitly distrusted by Microsoft, from the
+// disallowedcert.stl trust list. This bundle must never be used as a trust store.
+type microsoftDisallowedVendor struct{}
+
+func (microsoftDisallowedVendor) ID() string         { return microsoftDisallowedVendorID }
+func (microsoftDisallowedVendor) Name() string       { return "Microsoft Disallowed" }
+func (microsoftDisallowedVendor) BundleName() string { return MicrosoftDisallowedBundleName }
+func (microsoftDisallowedVendor) Inputs() []string   { return nil }
+
+func (microsoftDisallowedVendor) LatestVersion(bundleDir string) (*VendorVersion, error) {
+	data, err := downloadMicrosoftTrustList("disallowedcertstl.cab", "disallowedcert.stl")
+	if err != nil {
+		return nil, err
+	}
+	trustList, err := parseMicrosoftTrustList(data)
+	if err != nil {
+		return nil, fmt.Errorf("disallowedcert.stl: %s", err.Error())
+	}
+
+	return &VendorVersion{
+		Key:   fmt.Sprintf("%X", sha256.Sum256(data)),
+		Date:  trustList.ThisUpdate.UTC(),
+		Extra: trustList,
+	}, nil
+}
+
+// Fetch will download every certificate in the disallowed trust list. Certificates from the existing bundle are reused
+// when possible. If any certificate cannot be downloaded the vendor fails, so that a partial list of distrusted
+// certificates is never published.
+func (microsoftDisallowedVendor) Fetch(version *VendorVersion, bundleDir, dir string) ([]string, error) {
+	trustList := version.Extra.(*microsoftTrustList)
+
+	// Map the SHA-1 fingerprint of each certificate from the existing bundle to its path
+	existingCerts := map[string]string{}
+	if bundlePath := path.Join(bundleDir, MicrosoftDisallowedBundleName+".p7b"); fileExists(bundlePath) {
+		if err := extractP7B(bundlePath, dir); err != nil {
+			return nil, fmt.Errorf("error extracting microsoft disallowed certificates: %s", err.Error())
+		}
+		certFiles, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, certFile := range certFiles {
+			certPath := path.Join(dir, certFile.Name())
+			pemData, err := os.ReadFile(certPath)
+			if err != nil {
+				return nil, err
+			}
+			certPem, _ := pem.Decode(pemData)
+			if certPem == nil {
+				continue
+			}
+			existingCerts[fmt.Sprintf("%X", sha1.Sum(certPem.Bytes))] = certPath
+		}
+	}
+
+	certPaths := []string{}
+	for _, subject := range trustList.Subjects {
+		expectedSHA256 := ""
+		if sha256Value, ok := subject.Attributes[microsoftAttributeSHA256]; ok {
+			expectedSHA256 = fmt.Sprintf("%X", sha256Value)
+		}
+
+		if certPath, ok := existingCerts[subject.SHA1]; ok {
+			if expectedSHA256 == "" || verifyCertPEMSHA(certPath, expectedSHA256) {
+				certPaths = append(certPaths, certPath)
+				continue
+			}
+			log.Printf("Cached Microsoft disallowed certificate %s is bad", subject.SHA1)
+		}
+
+		derData, err := httpGetBytes(fmt.Sprintf("http://ctldl.windowsupdate.com/msdownload/update/v3/static/trustedr/en/%s.crt", subject.SHA1))
+		if err != nil {
+			return nil, fmt.Errorf("error downloading disallowed certificate %s: %s", subject.SHA1, err.Error())
+		}
+		if dlSHA1 := fmt.Sprintf("%X", sha1.Sum(derData)); dlSHA1 != subject.SHA1 {
+			return nil, fmt.Errorf("downloaded certificate verification failed for %s", subject.SHA1)
+		}
+		dlSHA256 := fmt.Sprintf("%X", sha256.Sum256(derData))
+		if expectedSHA256 != "" && dlSHA256 != expectedSHA256 {
+			return nil, fmt.Errorf("downloaded certificate verification failed for %s", subject.SHA1)
+		}
+
+		certPath := path.Join(dir, dlSHA256+".crt")
+		if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derData}), 0644); err != nil {
+			return nil, err
+		}
+		log.Printf("Downloaded disallowed certificate %s.crt", subject.SHA1)
+		certPaths = append(certPaths, certPath)
+	}
+
+	return certPaths, nil
+}
+
+// Build will generate the bundle and mark every certificate as distrusted in the metadata
+func (v microsoftDisallowedVendor) Build(version *VendorVersion, bundleDir string, certPaths []string) (*VendorMetadata, error) {
+	metadata, err := generateVendorBundle(v, version, bundleDir, certPaths)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range metadata.Certificates {
+		metadata.Certificates[i].Trust = &rootca.CertificateTrust{
+			ServerAuth:      rootca.TrustDistrusted,
+			EmailProtection: rootca.TrustDistrusted,
+			CodeSigning:     rootca.TrustDistrusted,
+		}
+	}
+
+	return metadata, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/tls-inspector/rootca/updater/rootca"
+)
+
+func TestMicrosoftMetadataFromTrustList(t *testing.T) {
+	data, err := os.ReadFile("testdata/authroot.stl")
+	if err != nil {
+		t.Fatalf("Error reading trust list: %s", err.Error())
+	}
+	subjects, evPolicies, hash, err := parseMicrosoftSubjects(data)
+	if err != nil {
+		t.Fatalf("Error parsing trust list: %s", err.Error())
+	}
+	if len(subjects) != 480 {
+		t.Errorf("Expected 480 subjects, got %d", len(subjects))
+	}
+	if hash != "A6752B7851B591550E4625B832A393AABCC428DE18D83E8593CD540F7D7CAE22" {
+		t.Errorf("Unexpected hash %s", hash)
+	}
+
+	allEKUs := []string{"1.3.6.1.5.5.7.3.2", "1.3.6.1.5.5.7.3.3", "1.3.6.1.4.1.311.10.3.12", "1.3.6.1.4.1.311.10.3.4", "1.3.6.1.5.5.7.3.4", "1.3.6.1.5.5.7.3.1", "1.3.6.1.5.5.7.3.8"}
+
+	for _, test := range []struct {
+		name       string
+		sha256     string
+		attributes *rootca.MicrosoftAttributes
+		trust      *rootca.CertificateTrust
+	}{
+		{
+			name:   "no usages",
+			sha256: "DF545BF919A2439C36983B54CDFC903DFA4F37D3996D8D84B4C31EEC6F3C163E",
+			attributes: &rootca.MicrosoftAttributes{
+				FriendlyName: "Microsoft Root Certificate Authority 2010",
+				EKUs:         []string{},
+			},
+			trust: &rootca.CertificateTrust{},
+		},
+		{
+			name:   "not before for code signing",
+			sha256: "1BA5B2AA8C65401A82960118F80BEC4F62304D83CEC4713A19C39C011EA46DB4",
+			attributes: &rootca.MicrosoftAttributes{
+				FriendlyName:  "Amazon Root CA 2",
+				EKUs:          allEKUs,
+				NotBefore:     "2021-08-01T00:00:00Z",
+				NotBeforeEKUs: []string{"1.3.6.1.5.5.7.3.3"},
+				EVPolicies:    []string{"2.23.140.1.1"},
+			},
+			trust: &rootca.CertificateTrust{
+				ServerAuth:      rootca.TrustTrusted,
+				EmailProtection: rootca.TrustTrusted,
+				CodeSigning:     rootca.TrustTrusted,
+			},
+		},
+		{
+			name:   "not before for server authentication",
+			sha256: "C1D80CE474A51128B77E794A98AA2D62A0225DA3F419E5C7ED73DFBF660E7109",
+			attributes: &rootca.MicrosoftAttributes{
+				FriendlyName:  "GLOBAL CHAMBERSIGN ROOT - 2016",
+				EKUs:          []string{"1.3.6.1.5.5.7.3.2", "1.3.6.1.5.5.7.3.4", "1.3.6.1.5.5.7.3.1"},
+				NotBefore:     "2024-02-01T00:00:00Z",
+				NotBeforeEKUs: []string{"1.3.6.1.5.5.7.3.1"},
+				EVPolicies:    []string{"1.3.6.1.4.1.311.94.1.1"},
+			},
+			trust: &rootca.CertificateTrust{
+				ServerAuth:          rootca.TrustTrusted,
+				EmailProtection:     rootca.TrustTrusted,
+				ServerDistrustAfter: "2024-02-01T00:00:00Z",
+			},
+		},
+		{
+			name:   "not before for all usages",
+			sha256: "5A885DB19C01D912C5759388938CAFBBDF031AB2D48E91EE15589B42971D039C",
+			attributes: &rootca.MicrosoftAttributes{
+				FriendlyName:  "TrustCor ECA-1",
+				EKUs:          []string{"1.3.6.1.5.5.7.3.2", "1.3.6.1.5.5.7.3.4", "1.3.6.1.5.5.7.3.1"},
+				NotBefore:     "2022-11-01T00:00:00Z",
+				NotBeforeEKUs: []string{},
+			},
+			trust: &rootca.CertificateTrust{
+				ServerAuth:          rootca.TrustTrusted,
+				EmailProtection:     rootca.TrustTrusted,
+				ServerDistrustAfter: "2022-11-01T00:00:00Z",
+				EmailDistrustAfter:  "2022-11-01T00:00:00Z",
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			for _, subject := range subjects {
+				if subject.SHA256Fingerprint != test.sha256 {
+					continue
+				}
+				if attributes := newMicrosoftAttributes(subject, evPolicies[subject.SHA1Fingerprint]); !reflect.DeepEqual(attributes, test.attributes) {
+					t.Errorf("Unexpected attributes %+v", attributes)
+				}
+				if trust := newMicrosoftTrust(subject); !reflect.DeepEqual(trust, test.trust) {
+					t.Errorf("Unexpected trust %+v", trust)
+				}
+				return
+			}
+			t.Errorf("No subject for %s", test.sha256)
+		})
+	}
+}
@@ -9,37 +9,69 @@ import (
 	"regexp"
 	"strings"
 	"time"
+
+	"github.com/tls-inspector/rootca/updater/rootca"
 )
 
 const MozillaBundleName = "mozilla_ca_bundle"
+const mozillaVendorID = "mozilla"
+
+type mozillaVendor struct{}
+
+func (mozillaVendor) ID() string         { return mozillaVendorID }
+func (mozillaVendor) Name() string       { return "Mozilla" }
+func (mozillaVendor) BundleName() string { return MozillaBundleName }
+func (mozillaVendor) Inputs() []string   { return nil }
+
+// LatestVersion will query either curl or NSS for the latest certificates, depending on the Mozilla source. When using
+// NSS the version may be pinned to a specific release tag.
+func (mozillaVendor) LatestVersion(bundleDir string) (*VendorVersion, error) {
+	if mozillaSource == mozillaSourceNSS {
+		commit, date, err := getLatestNSSCommit(nssTag)
+		if err != nil {
+			return nil, err
+		}
+		return &VendorVersion{Key: commit, Date: date}, nil
+	}
 
-func buildMozillaBundle(metadata *VendorMetadata) (*VendorMetadata, error) {
 	latestSHA, err := getMozillaSHA()
 	if err != nil {
 		return nil, err
 	}
 
-	if metadata != nil && !forceUpdate {
-		if isBundleUpToDate(latestSHA, metadata.Key, MozillaBundleName) {
-			logNotice("Mozilla bundle is up-to-date")
-			return metadata, nil
+	return &VendorVersion{Key: latestSHA}, nil
+}
+
+// Fetch will download the certificates from the Mozilla source. When using NSS, the trust of each certificate is
+// saved to the versions extra data.
+func (mozillaVendor) Fetch(version *VendorVersion, bundleDir, dir string) ([]string, error) {
+	if mozillaSource == mozillaSourceNSS {
+		certPaths, trust, err := fetchNSSCertificates(version.Key, dir)
+		if err != nil {
+			return nil, err
 		}
-		logWarning("Detected changes to Mozilla bundle. LastSHA='%s' LatestSHA='%s'", metadata.Key, latestSHA)
+		version.Extra = trust
+		return certPaths, nil
 	}
-	log.Printf("Building Mozilla CA bundle")
 
+	return fetchCurlCertificates(version, dir)
+}
+
+// fetchCurlCertificates will download the curl CA bundle and split it into individual certificates. The date of the
+// version is updated to the date Mozilla's certificate data was extracted.
+func fetchCurlCertificates(version *VendorVersion, dir string) ([]string, error) {
 	pemData, err := httpGetBytes("https://curl.se/ca/cacert.pem")
 	if err != nil {
 		return nil, err
 	}
 
 	pemDataHash := fmt.Sprintf("%x", sha256.Sum256(pemData))
-	if pemDataHash != latestSHA {
-		log.Printf("Checksum verification failed for mozilla CA bundle. CalculatedSHA='%s' ExpectedSHA='%s'", pemDataHash, latestSHA)
+	if pemDataHash != version.Key {
+		log.Printf("Checksum verification failed for mozilla CA bundle. CalculatedSHA='%s' ExpectedSHA='%s'", pemDataHash, version.Key)
 		return nil, fmt.Errorf("verification failed")
 	}
 
-	pemCerts := extractPemCerts(pemData)
+	pemCerts := rootca.ExtractPEMCertificates(pemData)
 
 	if len(pemCerts) == 0 {
 		return nil, fmt.Errorf("no certificates")
@@ -49,15 +81,9 @@ func buildMozillaBundle(metadata *VendorMetadata) (*VendorMetadata, error) {
 	dateStr := string(datePatterm.Find(pemData))
 	dateStr = strings.ReplaceAll(dateStr, "## Certificate data from Mozilla as of: ", "")
 
-	tempDir, err := os.MkdirTemp("", "mozilla")
-	if err != nil {
-		return nil, err
-	}
-	defer os.RemoveAll(tempDir)
-
 	certPaths := make([]string, len(pemCerts))
 	for i := 0; i < len(pemCerts); i++ {
-		certPaths[i] = path.Join(tempDir, fmt.Sprintf("cert_%d.crt", i))
+		certPaths[i] = path.Join(dir, fmt.Sprintf("cert_%d.crt", i))
 		if err := os.WriteFile(certPaths[i], pemCerts[i], 0644); err != nil {
 			return nil, err
 		}
@@ -67,29 +93,35 @@ func buildMozillaBundle(metadata *VendorMetadata) (*VendorMetadata, error) {
 	if err != nil {
 		date = time.Now().UTC()
 	}
+	version.Date = date
 
-	p7Fingerprints, pemFingerprints, err := generateBundleFromCertificates(certPaths, MozillaBundleName)
+	return certPaths, nil
+}
+
+// Build will generate the bundle and, when using NSS, record the trust of each certificate in the metadata
+func (v mozillaVendor) Build(version *VendorVersion, bundleDir string, certPaths []string) (*VendorMetadata, error) {
+	metadata, err := generateVendorBundle(v, version, bundleDir, certPaths)
 	if err != nil {
 		return nil, err
 	}
 
-	logNotice("Mozilla CA bundle generated with %d certificates", len(certPaths))
-
-	return &VendorMetadata{
-		Key: latestSHA,
-		Bundles: map[string]BundleFingerprint{
-			MozillaBundleName + ".p7b": *p7Fingerprints,
-			MozillaBundleName + ".pem": *pemFingerprints,
-		},
-		Date:     date.Format("2006-01-02T15:04:05Z07:00"),
-		NumCerts: len(pemCerts),
-	}, nil
+	if trust, ok := version.Extra.(map[string]rootca.CertificateTrust); ok {
+		for i, certificate := range metadata.Certificates {
+			certificateTrust, ok := trust[certificate.SHA256]
+			if !ok {
+				return nil, fmt.Errorf("no trust for certificate %s", certificate.SHA256)
+			}
+			metadata.Certificates[i].Trust = &certificateTrust
+		}
+	}
+
+	return metadata, nil
 }
 
 func getMozillaSHA() (string, error) {
 	resp, err := httpGetString("https://curl.se/ca/cacert.pem.sha256")
 	if err != nil {
-		return "", nil
+		return "", err
 	}
 
 	return strings.Split(resp, " ")[0], nil
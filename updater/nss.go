@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tls-inspector/rootca/updater/rootca"
+)
+
+const (
+	mozillaSourceCurl = "curl"
+	mozillaSourceNSS  = "nss"
+)
+
+const nssCertdataPath = "lib/ckfw/builtins/certdata.txt"
+
+// nssAttribute is a single attribute of an object in certdata.txt
+type nssAttribute struct {
+	// The type of the attribute, such as CK_TRUST or MULTILINE_OCTAL
+	Type string
+	// The decoded value for MULTILINE_OCTAL and UTF8 attributes, otherwise the raw value
+	Value []byte
+}
+
+// nssObject is a single object in certdata.txt, keyed by attribute name
+type nssObject map[string]nssAttribute
+
+func (o nssObject) value(name string) string {
+	return string(o[name].Value)
+}
+
+// nssCertificate is a certificate from certdata.txt along with its trust attributes
+type nssCertificate struct {
+	Label string
+	DER   []byte
+	Trust rootca.CertificateTrust
+}
+
+// nssTrustLevels maps NSS and PKCS#11 3.1 trust values to trust levels
+var nssTrustLevels = map[string]string{
+	"CKT_NSS_TRUSTED_DELEGATOR":   rootca.TrustTrusted,
+	"CKT_NSS_MUST_VERIFY_TRUST":   rootca.TrustMustVerify,
+	"CKT_NSS_NOT_TRUSTED":         rootca.TrustDistrusted,
+	"CKT_TRUST_ANCHOR":            rootca.TrustTrusted,
+	"CKT_TRUST_MUST_VERIFY_TRUST": rootca.TrustMustVerify,
+	"CKT_NOT_TRUSTED":             rootca.TrustDistrusted,
+}
+
+// parseCertdata will parse the objects in an NSS certdata.txt file
+func parseCertdata(data []byte) ([]nssObject, error) {
+	objects := []nssObject{}
+	var object nssObject
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || line == "BEGINDATA" {
+			continue
+		}
+
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) < 2 || !strings.HasPrefix(fields[0], "CKA_") {
+			return nil, fmt.Errorf("line %d: unexpected data", lineNumber)
+		}
+		name, attributeType := fields[0], fields[1]
+
+		// Every object begins with its class
+		if name == "CKA_CLASS" {
+			object = nssObject{}
+			objects = append(objects, object)
+		}
+		if object == nil {
+			return nil, fmt.Errorf("line %d: attribute outside of object", lineNumber)
+		}
+
+		attribute := nssAttribute{Type: attributeType}
+		switch attributeType {
+		case "MULTILINE_OCTAL":
+			value := []byte{}
+			terminated := false
+			for scanner.Scan() {
+				lineNumber++
+				octalLine := strings.TrimSpace(scanner.Text())
+				if octalLine == "END" {
+					terminated = true
+					break
+				}
+				for _, octal := range strings.Split(octalLine, `\`)[1:] {
+					b, err := strconv.ParseUint(octal, 8, 8)
+					if err != nil {
+						return nil, fmt.Errorf("line %d: invalid octal value", lineNumber)
+					}
+					value = append(value, byte(b))
+				}
+			}
+			if !terminated {
+				return nil, fmt.Errorf("line %d: unterminated %s", lineNumber, name)
+			}
+			attribute.Value = value
+		case "UTF8":
+			if len(fields) < 3 {
+				return nil, fmt.Errorf("line %d: missing value", lineNumber)
+			}
+			value, err := strconv.Unquote(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid string", lineNumber)
+			}
+			attribute.Value = []byte(value)
+		default:
+			if len(fields) == 3 {
+				attribute.Value = []byte(fields[2])
+			}
+		}
+		object[name] = attribute
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return objects, nil
+}
+
+// parseNSSCertificates will pair every certificate in the certdata objects with its trust object. Certificates without
+// trust are ignored.
+func parseNSSCertificates(objects []nssObject) ([]nssCertificate, error) {
+	trustObjects := map[string]nssObject{}
+	for _, object := range objects {
+		switch object.value("CKA_CLASS") {
+		case "CKO_NSS_TRUST", "CKO_TRUST":
+			trustObjects[object.value("CKA_ISSUER")+object.value("CKA_SERIAL_NUMBER")] = object
+		}
+	}
+
+	trustLevel := func(trust nssObject, purpose string) (string, error) {
+		value := trust.value("CKA_TRUST_" + purpose)
+		if value == "" {
+			value = trust.value("CKA_PKCS_TRUST_" + purpose)
+		}
+		if value == "" {
+			return "", nil
+		}
+		level, ok := nssTrustLevels[value]
+		if !ok {
+			return "", fmt.Errorf("unknown trust value %s", value)
+		}
+		return level, nil
+	}
+
+	distrustAfter := func(certificate nssObject, name string) (string, error) {
+		attribute := certificate[name]
+		if attribute.Type != "MULTILINE_OCTAL" {
+			return "", nil
+		}
+		date, err := time.Parse("060102150405Z", string(attribute.Value))
+		if err != nil {
+			return "", fmt.Errorf("invalid %s: %s", name, err.Error())
+		}
+		return date.Format("2006-01-02T15:04:05Z07:00"), nil
+	}
+
+	certificates := []nssCertificate{}
+	for _, object := range objects {
+		if object.value("CKA_CLASS") != "CKO_CERTIFICATE" {
+			continue
+		}
+		label := object.value("CKA_LABEL")
+
+		trust, ok := trustObjects[object.value("CKA_ISSUER")+object.value("CKA_SERIAL_NUMBER")]
+		if !ok {
+			continue
+		}
+
+		certificate := nssCertificate{
+			Label: label,
+			DER:   object["CKA_VALUE"].Value,
+		}
+		var err error
+		if certificate.Trust.ServerAuth, err = trustLevel(trust, "SERVER_AUTH"); err != nil {
+			return nil, fmt.Errorf("%s: %s", label, err.Error())
+		}
+		if certificate.Trust.EmailProtection, err = trustLevel(trust, "EMAIL_PROTECTION"); err != nil {
+			return nil, fmt.Errorf("%s: %s", label, err.Error())
+		}
+		if certificate.Trust.CodeSigning, err = trustLevel(trust, "CODE_SIGNING"); err != nil {
+			return nil, fmt.Errorf("%s: %s", label, err.Error())
+		}
+		if certificate.Trust.ServerDistrustAfter, err = distrustAfter(object, "CKA_NSS_SERVER_DISTRUST_AFTER"); err != nil {
+			return nil, fmt.Errorf("%s: %s", label, err.Error())
+		}
+		if certificate.Trust.EmailDistrustAfter, err = distrustAfter(object, "CKA_NSS_EMAIL_DISTRUST_AFTER"); err != nil {
+			return nil, fmt.Errorf("%s: %s", label, err.Error())
+		}
+		if len(certificate.DER) == 0 {
+			return nil, fmt.Errorf("%s: no certificate data", label)
+		}
+		certificates = append(certificates, certificate)
+	}
+
+	return certificates, nil
+}
+
+// getLatestNSSCommit will return the SHA and date of the most recent commit to certdata.txt reachable from the given
+// NSS ref, such as a release tag. If ref is empty the default branch is used.
+func getLatestNSSCommit(ref string) (string, time.Time, error) {
+	type githubCommitType struct {
+		SHA    string `json:"sha"`
+		Commit struct {
+			Committer struct {
+				Date string `json:"date"`
+			} `json:"committer"`
+		} `json:"commit"`
+	}
+
+	url := "https://api.github.com/repos/nss-dev/nss/commits?per_page=1&path=" + nssCertdataPath
+	if ref != "" {
+		url += "&sha=" + ref
+	}
+	resp, err := httpGetBytes(url)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	commits := []githubCommitType{}
+	if err := json.Unmarshal(resp, &commits); err != nil {
+		return "", time.Time{}, err
+	}
+	if len(commits) < 1 {
+		return "", time.Time{}, fmt.Errorf("no commit")
+	}
+
+	date, err := time.Parse("2006-01-02T15:04:05Z", commits[0].Commit.Committer.Date)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return commits[0].SHA, date, nil
+}
+
+// fetchNSSCertificates will download certdata.txt at the given commit and write every certificate trusted for server
+// authentication into dir. Returns the paths to the certificates and the trust of each, keyed by SHA-256 fingerprint.
+func fetchNSSCertificates(commit, dir string) ([]string, map[string]rootca.CertificateTrust, error) {
+	certdata, err := httpGetBytes("https://raw.githubusercontent.com/nss-dev/nss/" + commit + "/" + nssCertdataPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	objects, err := parseCertdata(certdata)
+	if err != nil {
+		return nil, nil, fmt.Errorf("certdata.txt: %s", err.Error())
+	}
+	certificates, err := parseNSSCertificates(objects)
+	if err != nil {
+		return nil, nil, fmt.Errorf("certdata.txt: %s", err.Error())
+	}
+
+	certPaths := []string{}
+	trust := map[string]rootca.CertificateTrust{}
+	for _, certificate := range certificates {
+		if certificate.Trust.ServerAuth != rootca.TrustTrusted {
+			continue
+		}
+
+		sha := fmt.Sprintf("%X", sha256.Sum256(certificate.DER))
+		pemData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.DER})
+
+		certPath := path.Join(dir, sha+".crt")
+		if err := os.WriteFile(certPath, pemData, 0644); err != nil {
+			return nil, nil, err
+		}
+		certPaths = append(certPaths, certPath)
+		trust[sha] = certificate.Trust
+	}
+
+	if len(certPaths) == 0 {
+		return nil, nil, fmt.Errorf("no certificates")
+	}
+
+	return certPaths, trust, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/tls-inspector/rootca/updater/rootca"
+)
+
+// octal encodes data in the MULTILINE_OCTAL format of certdata.txt
+func octal(data []byte) string {
+	lines := []string{}
+	for i := 0; i < len(data); i += 16 {
+		line := ""
+		for _, b := range data[i:min(i+16, len(data))] {
+			line += fmt.Sprintf(`\%03o`, b)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n") + "\nEND\n"
+}
+
+func testCertdata(serverAuth, distrustAfter string) string {
+	issuer := octal([]byte{0x30, 0x01, 0x02})
+	serial := octal([]byte{0x02, 0x01, 0x01})
+	certdata := `#
+# This is a comment
+#
+BEGINDATA
+CKA_CLASS CK_OBJECT_CLASS CKO_NSS_BUILTIN_ROOT_LIST
+CKA_TOKEN CK_BBOOL CK_TRUE
+CKA_LABEL UTF8 "Mozilla Builtin Roots"
+
+# Certificate "Example Root"
+CKA_CLASS CK_OBJECT_CLASS CKO_CERTIFICATE
+CKA_TOKEN CK_BBOOL CK_TRUE
+CKA_LABEL UTF8 "Example Root"
+CKA_CERTIFICATE_TYPE CK_CERTIFICATE_TYPE CKC_X_509
+CKA_ISSUER MULTILINE_OCTAL
+` + issuer + `CKA_SERIAL_NUMBER MULTILINE_OCTAL
+` + serial + `CKA_VALUE MULTILINE_OCTAL
+` + octal([]byte("certificate data that spans more than one line")) + distrustAfter + `
+# Trust for "Example Root"
+CKA_CLASS CK_OBJECT_CLASS CKO_NSS_TRUST
+CKA_TOKEN CK_BBOOL CK_TRUE
+CKA_LABEL UTF8 "Example Root"
+CKA_ISSUER MULTILINE_OCTAL
+` + issuer + `CKA_SERIAL_NUMBER MULTILINE_OCTAL
+` + serial + `CKA_TRUST_SERVER_AUTH CK_TRUST ` + serverAuth + `
+CKA_TRUST_EMAIL_PROTECTION CK_TRUST CKT_NSS_MUST_VERIFY_TRUST
+CKA_TRUST_CODE_SIGNING CK_TRUST CKT_NSS_NOT_TRUSTED
+
+# Certificate "Untrusted"
+CKA_CLASS CK_OBJECT_CLASS CKO_CERTIFICATE
+CKA_LABEL UTF8 "Untrusted"
+CKA_VALUE MULTILINE_OCTAL
+` + octal([]byte("other"))
+	return certdata
+}
+
+func TestParseNSSCertificates(t *testing.T) {
+	objects, err := parseCertdata([]byte(testCertdata("CKT_NSS_TRUSTED_DELEGATOR", "CKA_NSS_SERVER_DISTRUST_AFTER MULTILINE_OCTAL\n"+octal([]byte("240630000000Z")))))
+	if err != nil {
+		t.Fatalf("Error parsing certdata: %s", err.Error())
+	}
+	if len(objects) != 4 {
+		t.Fatalf("Expected 4 objects, got %d", len(objects))
+	}
+
+	certificates, err := parseNSSCertificates(objects)
+	if err != nil {
+		t.Fatalf("Error parsing certificates: %s", err.Error())
+	}
+	if len(certificates) != 1 {
+		t.Fatalf("Expected 1 certificate, got %d", len(certificates))
+	}
+	certificate := certificates[0]
+	if certificate.Label != "Example Root" {
+		t.Errorf("Unexpected label %s", certificate.Label)
+	}
+	if !bytes.Equal(certificate.DER, []byte("certificate data that spans more than one line")) {
+		t.Errorf("Incorrect certificate data")
+	}
+	expected := rootca.CertificateTrust{
+		ServerAuth:          rootca.TrustTrusted,
+		EmailProtection:     rootca.TrustMustVerify,
+		CodeSigning:         rootca.TrustDistrusted,
+		ServerDistrustAfter: "2024-06-30T00:00:00Z",
+	}
+	if certificate.Trust != expected {
+		t.Errorf("Unexpected trust %+v", certificate.Trust)
+	}
+}
+
+func TestParseNSSCertificatesPKCS11Trust(t *testing.T) {
+	certdata := strings.ReplaceAll(testCertdata("CKT_TRUST_ANCHOR", ""), "CKO_NSS_TRUST", "CKO_TRUST")
+	certdata = strings.ReplaceAll(certdata, "CKA_TRUST_SERVER_AUTH", "CKA_PKCS_TRUST_SERVER_AUTH")
+	objects, err := parseCertdata([]byte(certdata))
+	if err != nil {
+		t.Fatalf("Error parsing certdata: %s", err.Error())
+	}
+	certificates, err := parseNSSCertificates(objects)
+	if err != nil {
+		t.Fatalf("Error parsing certificates: %s", err.Error())
+	}
+	if len(certificates) != 1 || certificates[0].Trust.ServerAuth != rootca.TrustTrusted {
+		t.Errorf("Unexpected certificates %+v", certificates)
+	}
+}
+
+func TestParseNSSCertificatesUnknownTrust(t *testing.T) {
+	objects, err := parseCertdata([]byte(testCertdata("CKT_NSS_SOMETHING_NEW", "")))
+	if err != nil {
+		t.Fatalf("Error parsing certdata: %s", err.Error())
+	}
+	if _, err := parseNSSCertificates(objects); err == nil {
+		t.Errorf("No error seen for unknown trust value")
+	}
+}
+
+func TestParseCertdataErrors(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		certdata string
+	}{
+		{name: "attribute outside of object", certdata: "CKA_TOKEN CK_BBOOL CK_TRUE\n"},
+		{name: "unexpected data", certdata: "CKA_CLASS CK_OBJECT_CLASS CKO_CERTIFICATE\nnot an attribute\n"},
+		{name: "unterminated octal", certdata: "CKA_CLASS CK_OBJECT_CLASS CKO_CERTIFICATE\nCKA_VALUE MULTILINE_OCTAL\n\\001\\002\n"},
+		{name: "invalid octal", certdata: "CKA_CLASS CK_OBJECT_CLASS CKO_CERTIFICATE\nCKA_VALUE MULTILINE_OCTAL\n\\999\nEND\n"},
+		{name: "invalid string", certdata: "CKA_CLASS CK_OBJECT_CLASS CKO_CERTIFICATE\nCKA_LABEL UTF8 \"unterminated\n"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := parseCertdata([]byte(test.certdata)); err == nil {
+				t.Errorf("No error seen")
+			}
+		})
+	}
+}
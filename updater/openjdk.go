@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"time"
+
+	"github.com/tls-inspector/rootca/updater/rootca"
+)
+
+const OpenJDKBundleName = "openjdk_ca_bundle"
+const openJDKVendorID = "openjdk"
+const openJDKCacertsPath = "make/data/cacerts"
+
+var openJDKAPIURL = "https://api.github.com/repos/openjdk/jdk"
+
+// openJDKMinimumGAFeature is the feature release the search for the latest general availability release starts from.
+// Its GA tag must exist, and it only needs to be raised to save requests.
+const openJDKMinimumGAFeature = 25
+
+type openJDKVendor struct{}
+
+func (openJDKVendor) ID() string         { return openJDKVendorID }
+func (openJDKVendor) Name() string       { return "OpenJDK" }
+func (openJDKVendor) BundleName() string { return OpenJDKBundleName }
+func (openJDKVendor) Inputs() []string   { return nil }
+
+// LatestVersion will find the last commit to modify the cacerts directory as of the release tag. The tag is either
+// pinned with --openjdk-tag or the most recent general availability release, and is recorded as the version.
+func (openJDKVendor) LatestVersion(bundleDir string) (*VendorVersion, error) {
+	tag := openJDKTag
+	if tag == "" {
+		latestTag, err := getLatestOpenJDKTag()
+		if err != nil {
+			return nil, err
+		}
+		tag = latestTag
+	}
+
+	type githubCommitType struct {
+		SHA    string `json:"sha"`
+		Commit struct {
+			Author struct {
+				Date string `json:"date"`
+			} `json:"author"`
+		} `json:"commit"`
+	}
+
+	resp, err := httpGetBytes(openJDKAPIURL + "/commits?per_page=1&path=" + openJDKCacertsPath + "&sha=" + url.QueryEscape(tag))
+	if err != nil {
+		return nil, fmt.Errorf("getting commit: %s", err)
+	}
+	commits := []githubCommitType{}
+	if err := json.Unmarshal(resp, &commits); err != nil {
+		return nil, err
+	}
+	if len(commits) == 0 {
+		return nil, fmt.Errorf("no commits found modifying %s at %s", openJDKCacertsPath, tag)
+	}
+
+	date, err := time.Parse("2006-01-02T15:04:05Z", commits[0].Commit.Author.Date)
+	if err != nil {
+		date = time.Now().UTC()
+	}
+
+	return &VendorVersion{Key: commits[0].SHA, Date: date, Version: tag}, nil
+}
+
+// Fetch will download every certificate in the cacerts directory at the versions commit. Each file contains a
+// description of the certificate followed by the PEM-encoded certificate.
+func (openJDKVendor) Fetch(version *VendorVersion, bundleDir, dir string) ([]string, error) {
+	type githubContentType struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+	}
+
+	resp, err := httpGetBytes(openJDKAPIURL + "/contents/" + openJDKCacertsPath + "?ref=" + version.Key)
+	if err != nil {
+		return nil, fmt.Errorf("listing certificates: %s", err)
+	}
+	contents := []githubContentType{}
+	if err := json.Unmarshal(resp, &contents); err != nil {
+		return nil, err
+	}
+
+	certPaths := []string{}
+	for _, content := range contents {
+		if content.Type != "file" {
+			continue
+		}
+
+		data, err := httpGetBytes("https://raw.githubusercontent.com/openjdk/jdk/" + version.Key + "/" + openJDKCacertsPath + "/" + content.Name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", content.Name, err.Error())
+		}
+		pemCerts := rootca.ExtractPEMCertificates(data)
+		if len(pemCerts) == 0 {
+			log.Printf("Skipping OpenJDK cacerts file without a certificate: %s", content.Name)
+			continue
+		}
+		if len(pemCerts) > 1 {
+			return nil, fmt.Errorf("%s: unexpected number of certificates. expected 1 got %d", content.Name, len(pemCerts))
+		}
+
+		certPath := path.Join(dir, content.Name+".crt")
+		if err := os.WriteFile(certPath, pemCerts[0], 0644); err != nil {
+			return nil, err
+		}
+		certPaths = append(certPaths, certPath)
+	}
+	if len(certPaths) == 0 {
+		return nil, fmt.Errorf("no certificates")
+	}
+
+	return certPaths, nil
+}
+
+func (v openJDKVendor) Build(version *VendorVersion, bundleDir string, certPaths []string) (*VendorMetadata, error) {
+	return generateVendorBundle(v, version, bundleDir, certPaths)
+}
+
+// getLatestOpenJDKTag returns the tag of the most recent OpenJDK general availability release, such as jdk-25-ga.
+// Feature releases are numbered sequentially, so rather than paging through every tag of the repository, each GA tag
+// after openJDKMinimumGAFeature is looked up until one does not exist.
+func getLatestOpenJDKTag() (string, error) {
+	type githubRefType struct {
+		Ref string `json:"ref"`
+	}
+
+	latestTag := ""
+	for feature := openJDKMinimumGAFeature; ; feature++ {
+		tag := fmt.Sprintf("jdk-%d-ga", feature)
+		// matching-refs returns an empty list rather than an error if the tag does not exist
+		resp, err := httpGetBytes(openJDKAPIURL + "/git/matching-refs/tags/" + tag)
+		if err != nil {
+			return "", fmt.Errorf("getting tag %s: %s", tag, err)
+		}
+		refs := []githubRefType{}
+		if err := json.Unmarshal(resp, &refs); err != nil {
+			return "", err
+		}
+		found := false
+		for _, ref := range refs {
+			if ref.Ref == "refs/tags/"+tag {
+				found = true
+			}
+		}
+		if !found {
+			break
+		}
+		latestTag = tag
+	}
+
+	if latestTag == "" {
+		return "", fmt.Errorf("no release tags, expected at least jdk-%d-ga", openJDKMinimumGAFeature)
+	}
+	return latestTag, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testOpenJDKServer(t *testing.T, files map[string]string) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if data, ok := files[r.URL.RequestURI()]; ok {
+			w.Write([]byte(data))
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/git/matching-refs/") {
+			w.Write([]byte("[]"))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	apiURL, tag := openJDKAPIURL, openJDKTag
+	openJDKAPIURL = server.URL
+	t.Cleanup(func() {
+		openJDKAPIURL, openJDKTag = apiURL, tag
+	})
+}
+
+func TestOpenJDKLatestVersion(t *testing.T) {
+	commit := `[{"sha":"abc","commit":{"author":{"date":"2025-09-01T10:00:00Z"}}}]`
+	testOpenJDKServer(t, map[string]string{
+		"/git/matching-refs/tags/jdk-25-ga": `[{"ref":"refs/tags/jdk-25-ga"}]`,
+		"/git/matching-refs/tags/jdk-26-ga": `[{"ref":"refs/tags/jdk-26-ga"}]`,
+		// matching-refs matches by prefix, so only the exact tag counts
+		"/git/matching-refs/tags/jdk-27-ga":                                   `[{"ref":"refs/tags/jdk-27-ga-rc"}]`,
+		"/commits?per_page=1&path=" + openJDKCacertsPath + "&sha=jdk-26-ga":   commit,
+		"/commits?per_page=1&path=" + openJDKCacertsPath + "&sha=jdk-21%2B35": commit,
+	})
+
+	version, err := openJDKVendor{}.LatestVersion(".")
+	if err != nil {
+		t.Fatalf("Error getting version: %s", err.Error())
+	}
+	if version.Key != "abc" || version.Version != "jdk-26-ga" || !version.Date.Equal(time.Date(2025, 9, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected version %+v", version)
+	}
+
+	openJDKTag = "jdk-21+35"
+	version, err = openJDKVendor{}.LatestVersion(".")
+	if err != nil {
+		t.Fatalf("Error getting version: %s", err.Error())
+	}
+	if version.Version != "jdk-21+35" {
+		t.Errorf("Unexpected version %+v", version)
+	}
+}
+
+func TestOpenJDKLatestVersionNoTags(t *testing.T) {
+	testOpenJDKServer(t, map[string]string{})
+
+	if _, err := (openJDKVendor{}).LatestVersion("."); err == nil {
+		t.Errorf("No error seen without release tags")
+	}
+}
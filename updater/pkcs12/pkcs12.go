@@ -0,0 +1,342 @@
+// Package pkcs12 provides a reader and writer for PKCS#12 truststores, which contain only trusted certificates and no private
+// keys. The certificates are stored unencrypted and the structure is protected by a SHA-256 MAC. Each certificate is
+// marked as trusted for any purpose using the attribute Java uses for trusted certificate entries, so the truststore
+// can be used by Java as well as by OpenSSL and .NET. The output is deterministic.
+package pkcs12
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"unicode/utf16"
+)
+
+// MACIterations is the number of iterations used to derive the MAC key from the password
+const MACIterations = 10000
+
+// macKeyID is the diversifier used to derive MAC keys, as described in RFC 7292 appendix B.3
+const macKeyID = 3
+
+var (
+	oidData                = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidCertBag             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidX509Certificate     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidFriendlyName        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidJavaTrustedKeyUsage = asn1.ObjectIdentifier{2, 16, 840, 1, 113894, 746875, 1, 1}
+	oidAnyExtendedKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37, 0}
+	oidSHA256              = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	asn1Null               = asn1.RawValue{Tag: asn1.TagNull}
+)
+
+// TrustedCertificate is a certificate entry in a truststore
+type TrustedCertificate struct {
+	// The friendly name of the entry, which must be unique within the truststore
+	Alias string
+	// The DER-encoded certificate
+	Certificate []byte
+}
+
+type pfx struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData `asn1:"optional"`
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int `asn1:"optional,default:1"`
+}
+
+type digestInfo struct {
+	Algorithm algorithmIdentifier
+	Digest    []byte
+}
+
+type algorithmIdentifier struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue `asn1:"optional"`
+}
+
+type safeBag struct {
+	BagID asn1.ObjectIdentifier
+	// The [0] EXPLICIT tagged bag
+	BagValue   asn1.RawValue
+	Attributes []attribute `asn1:"set,optional"`
+}
+
+type certBag struct {
+	CertID    asn1.ObjectIdentifier
+	CertValue []byte `asn1:"explicit,tag:0"`
+}
+
+type attribute struct {
+	ID     asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+// Encode will return a DER-encoded PKCS#12 truststore containing the given certificates in the order they are
+// provided, protected by the given password.
+func Encode(certificates []TrustedCertificate, password string) ([]byte, error) {
+	if len(certificates) == 0 {
+		return nil, fmt.Errorf("pkcs12: no certificates")
+	}
+
+	aliases := map[string]bool{}
+	bags := make([]safeBag, len(certificates))
+	for i, certificate := range certificates {
+		if aliases[certificate.Alias] {
+			return nil, fmt.Errorf("pkcs12: duplicate alias %s", certificate.Alias)
+		}
+		aliases[certificate.Alias] = true
+
+		var raw asn1.RawValue
+		rest, err := asn1.Unmarshal(certificate.Certificate, &raw)
+		if err != nil {
+			return nil, fmt.Errorf("pkcs12: invalid certificate at index %d: %s", i, err.Error())
+		}
+		if len(rest) > 0 || raw.Tag != asn1.TagSequence {
+			return nil, fmt.Errorf("pkcs12: invalid certificate at index %d", i)
+		}
+
+		bag, err := asn1.Marshal(certBag{CertID: oidX509Certificate, CertValue: certificate.Certificate})
+		if err != nil {
+			return nil, err
+		}
+		friendlyName, err := marshalSet(asn1.RawValue{Tag: asn1.TagBMPString, Bytes: bmpString(certificate.Alias, false)})
+		if err != nil {
+			return nil, err
+		}
+		trustedKeyUsage, err := marshalSet(oidAnyExtendedKeyUsage)
+		if err != nil {
+			return nil, err
+		}
+		bags[i] = safeBag{
+			BagID:    oidCertBag,
+			BagValue: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: bag},
+			Attributes: []attribute{
+				{ID: oidFriendlyName, Values: asn1.RawValue{FullBytes: friendlyName}},
+				{ID: oidJavaTrustedKeyUsage, Values: asn1.RawValue{FullBytes: trustedKeyUsage}},
+			},
+		}
+	}
+
+	safeContents, err := asn1.Marshal(bags)
+	if err != nil {
+		return nil, err
+	}
+	safeContentsInfo, err := dataContentInfo(safeContents)
+	if err != nil {
+		return nil, err
+	}
+	authenticatedSafe, err := asn1.Marshal([]contentInfo{safeContentsInfo})
+	if err != nil {
+		return nil, err
+	}
+	authSafe, err := dataContentInfo(authenticatedSafe)
+	if err != nil {
+		return nil, err
+	}
+
+	// The salt is derived from the contents rather than generated randomly so that the output is deterministic
+	contentHash := sha256.Sum256(authenticatedSafe)
+	salt := contentHash[:16]
+
+	return asn1.Marshal(pfx{
+		Version:  3,
+		AuthSafe: authSafe,
+		MacData: macData{
+			Mac: digestInfo{
+				Algorithm: algorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1Null},
+				Digest:    computeMAC(authenticatedSafe, password, salt, MACIterations),
+			},
+			MacSalt:    salt,
+			Iterations: MACIterations,
+		},
+	})
+}
+
+// Decode will verify the MAC of the given DER-encoded PKCS#12 truststore and return its certificates. Only
+// truststores with unencrypted certificates and a SHA-256 MAC, such as those created by Encode, are supported.
+func Decode(data []byte, password string) ([]TrustedCertificate, error) {
+	var p pfx
+	rest, err := asn1.Unmarshal(data, &p)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs12: %s", err.Error())
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("pkcs12: trailing data")
+	}
+	if p.Version != 3 {
+		return nil, fmt.Errorf("pkcs12: unsupported version %d", p.Version)
+	}
+
+	authenticatedSafe, err := dataContent(p.AuthSafe)
+	if err != nil {
+		return nil, err
+	}
+	if !p.MacData.Mac.Algorithm.Algorithm.Equal(oidSHA256) {
+		return nil, fmt.Errorf("pkcs12: unsupported MAC algorithm %s", p.MacData.Mac.Algorithm.Algorithm)
+	}
+	mac := computeMAC(authenticatedSafe, password, p.MacData.MacSalt, p.MacData.Iterations)
+	if subtle.ConstantTimeCompare(mac, p.MacData.Mac.Digest) != 1 {
+		return nil, fmt.Errorf("pkcs12: incorrect password or corrupt data")
+	}
+
+	var contentInfos []contentInfo
+	if _, err := asn1.Unmarshal(authenticatedSafe, &contentInfos); err != nil {
+		return nil, fmt.Errorf("pkcs12: %s", err.Error())
+	}
+	certificates := []TrustedCertificate{}
+	for _, info := range contentInfos {
+		safeContents, err := dataContent(info)
+		if err != nil {
+			return nil, err
+		}
+		var bags []safeBag
+		if _, err := asn1.Unmarshal(safeContents, &bags); err != nil {
+			return nil, fmt.Errorf("pkcs12: %s", err.Error())
+		}
+		for _, bag := range bags {
+			if !bag.BagID.Equal(oidCertBag) {
+				return nil, fmt.Errorf("pkcs12: unsupported bag type %s", bag.BagID)
+			}
+			var cert certBag
+			if _, err := asn1.Unmarshal(bag.BagValue.Bytes, &cert); err != nil {
+				return nil, fmt.Errorf("pkcs12: %s", err.Error())
+			}
+			if !cert.CertID.Equal(oidX509Certificate) {
+				return nil, fmt.Errorf("pkcs12: unsupported certificate type %s", cert.CertID)
+			}
+
+			certificate := TrustedCertificate{Certificate: cert.CertValue}
+			for _, attr := range bag.Attributes {
+				if !attr.ID.Equal(oidFriendlyName) {
+					continue
+				}
+				var name asn1.RawValue
+				if _, err := asn1.Unmarshal(attr.Values.Bytes, &name); err != nil {
+					return nil, fmt.Errorf("pkcs12: %s", err.Error())
+				}
+				certificate.Alias = decodeBMPString(name.Bytes)
+			}
+			certificates = append(certificates, certificate)
+		}
+	}
+	return certificates, nil
+}
+
+func dataContentInfo(content []byte) (contentInfo, error) {
+	octets, err := asn1.Marshal(content)
+	if err != nil {
+		return contentInfo{}, err
+	}
+	return contentInfo{
+		ContentType: oidData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: octets},
+	}, nil
+}
+
+func dataContent(info contentInfo) ([]byte, error) {
+	if !info.ContentType.Equal(oidData) {
+		return nil, fmt.Errorf("pkcs12: unsupported content type %s", info.ContentType)
+	}
+	var content []byte
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &content); err != nil {
+		return nil, fmt.Errorf("pkcs12: %s", err.Error())
+	}
+	return content, nil
+}
+
+func marshalSet(value any) ([]byte, error) {
+	element, err := asn1.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: element})
+}
+
+// bmpString returns s encoded as big-endian UTF-16, optionally followed by a null terminator
+func bmpString(s string, terminated bool) []byte {
+	units := utf16.Encode([]rune(s))
+	if terminated {
+		units = append(units, 0)
+	}
+	b := make([]byte, len(units)*2)
+	for i, unit := range units {
+		b[i*2] = byte(unit >> 8)
+		b[i*2+1] = byte(unit)
+	}
+	return b
+}
+
+func decodeBMPString(b []byte) string {
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = uint16(b[i*2])<<8 | uint16(b[i*2+1])
+	}
+	return string(utf16.Decode(units))
+}
+
+// computeMAC returns the HMAC-SHA256 of the data using a key derived from the password, as described in RFC 7292
+// appendix B
+func computeMAC(data []byte, password string, salt []byte, iterations int) []byte {
+	key := deriveKey(bmpString(password, true), salt, macKeyID, iterations, sha256.Size)
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// deriveKey implements the PKCS#12 key derivation function using SHA-256, as described in RFC 7292 appendix B.2
+func deriveKey(password, salt []byte, id byte, iterations, size int) []byte {
+	const u = sha256.Size
+	const v = sha256.BlockSize
+
+	fill := func(b []byte) []byte {
+		if len(b) == 0 {
+			return nil
+		}
+		out := make([]byte, v*((len(b)+v-1)/v))
+		for i := range out {
+			out[i] = b[i%len(b)]
+		}
+		return out
+	}
+
+	d := bytes.Repeat([]byte{id}, v)
+	i := append(fill(salt), fill(password)...)
+	key := []byte{}
+	for len(key) < size {
+		h := sha256.New()
+		h.Write(d)
+		h.Write(i)
+		a := h.Sum(nil)
+		for j := 1; j < iterations; j++ {
+			sum := sha256.Sum256(a)
+			a = sum[:]
+		}
+		key = append(key, a...)
+
+		// Each v-byte block of I is replaced with (I_j + B + 1) mod 2^(v*8), where B is A repeated to v bytes
+		b := new(big.Int).SetBytes(fill(a[:u]))
+		b.Add(b, big.NewInt(1))
+		modulus := new(big.Int).Lsh(big.NewInt(1), v*8)
+		for j := 0; j < len(i); j += v {
+			block := new(big.Int).SetBytes(i[j : j+v])
+			block.Add(block, b).Mod(block, modulus)
+			blockBytes := block.Bytes()
+			copy(i[j:j+v], make([]byte, v-len(blockBytes)))
+			copy(i[j+v-len(blockBytes):j+v], blockBytes)
+		}
+	}
+	return key[:size]
+}
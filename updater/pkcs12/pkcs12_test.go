@@ -0,0 +1,147 @@
+package pkcs12
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/tls-inspector/rootca/updater/internal/testcert"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	certificates := []TrustedCertificate{
+		{Alias: "root a", Certificate: testcert.Certificate(t, "root_a")},
+		{Alias: "Räksmörgås \U0001F512", Certificate: testcert.Certificate(t, "root_b")},
+	}
+
+	data, err := Encode(certificates, "changeit")
+	if err != nil {
+		t.Fatalf("Error encoding truststore: %s", err.Error())
+	}
+	again, err := Encode(certificates, "changeit")
+	if err != nil {
+		t.Fatalf("Error encoding truststore: %s", err.Error())
+	}
+	if !bytes.Equal(data, again) {
+		t.Errorf("Truststore is not deterministic")
+	}
+
+	decoded, err := Decode(data, "changeit")
+	if err != nil {
+		t.Fatalf("Error decoding truststore: %s", err.Error())
+	}
+	if !reflect.DeepEqual(decoded, certificates) {
+		t.Errorf("Decoded certificates do not match\n%+v\n%+v", decoded, certificates)
+	}
+
+	if _, err := Decode(data, "password"); err == nil {
+		t.Errorf("No error seen for incorrect password")
+	}
+	if _, err := Decode(append(bytes.Clone(data), 0), "changeit"); err == nil {
+		t.Errorf("No error seen for trailing data")
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	certificate := testcert.Certificate(t, "root_a")
+	for _, test := range []struct {
+		name         string
+		certificates []TrustedCertificate
+	}{
+		{name: "no certificates", certificates: nil},
+		{name: "duplicate alias", certificates: []TrustedCertificate{{Alias: "root", Certificate: certificate}, {Alias: "root", Certificate: certificate}}},
+		{name: "invalid certificate", certificates: []TrustedCertificate{{Alias: "root", Certificate: []byte("not a certificate")}}},
+		{name: "trailing data", certificates: []TrustedCertificate{{Alias: "root", Certificate: append(bytes.Clone(certificate), 0)}}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := Encode(test.certificates, "changeit"); err == nil {
+				t.Errorf("No error seen")
+			}
+		})
+	}
+}
+
+// parseTestTruststore returns the MAC data and the bags of a truststore with a single unencrypted safe contents
+func parseTestTruststore(t *testing.T, data []byte) (macData, []safeBag) {
+	t.Helper()
+	var p pfx
+	if _, err := asn1.Unmarshal(data, &p); err != nil {
+		t.Fatalf("Error parsing truststore: %s", err.Error())
+	}
+	authenticatedSafe, err := dataContent(p.AuthSafe)
+	if err != nil {
+		t.Fatalf("Error parsing truststore: %s", err.Error())
+	}
+	var contentInfos []contentInfo
+	if _, err := asn1.Unmarshal(authenticatedSafe, &contentInfos); err != nil {
+		t.Fatalf("Error parsing truststore: %s", err.Error())
+	}
+	if len(contentInfos) != 1 {
+		t.Fatalf("Expected 1 safe contents, got %d", len(contentInfos))
+	}
+	safeContents, err := dataContent(contentInfos[0])
+	if err != nil {
+		t.Fatalf("Error parsing truststore: %s", err.Error())
+	}
+	var bags []safeBag
+	if _, err := asn1.Unmarshal(safeContents, &bags); err != nil {
+		t.Fatalf("Error parsing truststore: %s", err.Error())
+	}
+	return p.MacData, bags
+}
+
+// TestDecodeOpenSSL reads a truststore created by OpenSSL 3.0 from the certificates in the testcert package with:
+//
+//	openssl pkcs12 -export -nokeys -in roots.pem -caname "root a" -caname "root b" -certpbe NONE -macalg sha256 \
+//		-iter 10000 -passout pass:changeit -out openssl.p12
+//
+// OpenSSL 3.0 cannot add the Java trusted key usage attribute, so it is the only difference from the output of Encode
+// apart from the MAC salt.
+func TestDecodeOpenSSL(t *testing.T) {
+	fixture, err := os.ReadFile("testdata/openssl.p12")
+	if err != nil {
+		t.Fatalf("Error reading truststore: %s", err.Error())
+	}
+	decoded, err := Decode(fixture, "changeit")
+	if err != nil {
+		t.Fatalf("Error decoding truststore: %s", err.Error())
+	}
+	certificates := []TrustedCertificate{
+		{Alias: "root a", Certificate: testcert.Certificate(t, "root_a")},
+		{Alias: "root b", Certificate: testcert.Certificate(t, "root_b")},
+	}
+	if !reflect.DeepEqual(decoded, certificates) {
+		t.Errorf("Decoded certificates do not match\n%+v\n%+v", decoded, certificates)
+	}
+
+	data, err := Encode(certificates, "changeit")
+	if err != nil {
+		t.Fatalf("Error encoding truststore: %s", err.Error())
+	}
+	fixtureMAC, fixtureBags := parseTestTruststore(t, fixture)
+	mac, bags := parseTestTruststore(t, data)
+	if !mac.Mac.Algorithm.Algorithm.Equal(fixtureMAC.Mac.Algorithm.Algorithm) || mac.Iterations != fixtureMAC.Iterations {
+		t.Errorf("MAC parameters do not match")
+	}
+	if len(bags) != len(fixtureBags) {
+		t.Fatalf("Expected %d bags, got %d", len(fixtureBags), len(bags))
+	}
+	for i, bag := range bags {
+		fixtureBag := fixtureBags[i]
+		if !bag.BagID.Equal(fixtureBag.BagID) || !bytes.Equal(bag.BagValue.FullBytes, fixtureBag.BagValue.FullBytes) {
+			t.Errorf("Bag %d does not match", i)
+		}
+		attributes := map[string][]byte{}
+		for _, attr := range bag.Attributes {
+			attributes[attr.ID.String()] = attr.Values.FullBytes
+		}
+		if len(attributes) != 2 || attributes[oidJavaTrustedKeyUsage.String()] == nil {
+			t.Errorf("Bag %d: unexpected attributes", i)
+		}
+		if len(fixtureBag.Attributes) != 1 || !fixtureBag.Attributes[0].ID.Equal(oidFriendlyName) || !bytes.Equal(fixtureBag.Attributes[0].Values.FullBytes, attributes[oidFriendlyName.String()]) {
+			t.Errorf("Bag %d: friendly name does not match", i)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Package pkcs7 provides a reader and writer for degenerate PKCS#7 SignedData structures, which are commonly used to
+// package a bundle of certificates without any signers. The output is deterministic and identical to that of
+// `openssl crl2pkcs7 -nocrl`. Signatures of signed structures, such as Microsoft certificate trust lists, can be
+// verified with Verify.
+package pkcs7
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+)
+
+// PEMType is the type of the PEM block used for PKCS#7 data
+const PEMType = "PKCS7"
+
+var (
+	oidData       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+)
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      contentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      asn1.RawValue
+}
+
+// Encode will return a DER-encoded PKCS#7 SignedData structure containing the given DER-encoded certificates in the
+// order they are provided. The structure has no content, CRLs, or signers.
+func Encode(certificates [][]byte) ([]byte, error) {
+	if len(certificates) == 0 {
+		return nil, fmt.Errorf("pkcs7: no certificates")
+	}
+
+	for i, certificate := range certificates {
+		var raw asn1.RawValue
+		rest, err := asn1.Unmarshal(certificate, &raw)
+		if err != nil {
+			return nil, fmt.Errorf("pkcs7: invalid certificate at index %d: %s", i, err.Error())
+		}
+		if len(rest) > 0 || raw.Tag != asn1.TagSequence {
+			return nil, fmt.Errorf("pkcs7: invalid certificate at index %d", i)
+		}
+	}
+
+	sd := signedData{
+		Version:          1,
+		DigestAlgorithms: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: []byte{}},
+		ContentInfo:      contentInfo{ContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: bytes.Join(certificates, nil)},
+		SignerInfos:      asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: []byte{}},
+	}
+	sdBytes, err := asn1.Marshal(sd)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs7: %s", err.Error())
+	}
+
+	ci := struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue
+	}{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sdBytes},
+	}
+	return asn1.Marshal(ci)
+}
+
+// EncodePEM is the same as Encode, but returns the PKCS#7 structure as a PEM block
+func EncodePEM(certificates [][]byte) ([]byte, error) {
+	der, err := Encode(certificates)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: PEMType, Bytes: der}), nil
+}
+
+// Decode will return the DER-encoded certificates contained within the given PKCS#7 SignedData structure, in the
+// order they appear. Data may either be DER-encoded or a PEM block. Certificates are not parsed or validated.
+func Decode(data []byte) ([][]byte, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != PEMType {
+			return nil, fmt.Errorf("pkcs7: unexpected pem type %s", block.Type)
+		}
+		data = block.Bytes
+	}
+
+	var ci contentInfo
+	rest, err := asn1.Unmarshal(data, &ci)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs7: %s", err.Error())
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("pkcs7: unexpected trailing data")
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("pkcs7: unexpected content type %s", ci.ContentType.String())
+	}
+
+	var sd signedData
+	rest, err = asn1.Unmarshal(ci.Content.Bytes, &sd)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs7: %s", err.Error())
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("pkcs7: unexpected trailing data")
+	}
+
+	certificates := [][]byte{}
+	certBytes := sd.Certificates.Bytes
+	for len(certBytes) > 0 {
+		var raw asn1.RawValue
+		certBytes, err = asn1.Unmarshal(certBytes, &raw)
+		if err != nil {
+			return nil, fmt.Errorf("pkcs7: invalid certificate at index %d: %s", len(certificates), err.Error())
+		}
+		certificates = append(certificates, raw.FullBytes)
+	}
+
+	return certificates, nil
+}
@@ -0,0 +1,55 @@
+package pkcs7
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRoundTripBundles(t *testing.T) {
+	bundlePaths, err := filepath.Glob("../../bundles/*.p7b")
+	if err != nil {
+		t.Fatalf("Error listing bundles: %s", err.Error())
+	}
+	if len(bundlePaths) == 0 {
+		t.Fatalf("No bundles found")
+	}
+
+	for _, bundlePath := range bundlePaths {
+		t.Run(filepath.Base(bundlePath), func(t *testing.T) {
+			data, err := os.ReadFile(bundlePath)
+			if err != nil {
+				t.Fatalf("Error reading bundle: %s", err.Error())
+			}
+
+			certificates, err := Decode(data)
+			if err != nil {
+				t.Fatalf("Error decoding bundle: %s", err.Error())
+			}
+			if len(certificates) == 0 {
+				t.Fatalf("No certificates decoded")
+			}
+
+			encoded, err := EncodePEM(certificates)
+			if err != nil {
+				t.Fatalf("Error encoding bundle: %s", err.Error())
+			}
+			if !bytes.Equal(encoded, data) {
+				t.Errorf("Encoded bundle does not match original")
+			}
+		})
+	}
+}
+
+func TestEncodeNoCertificates(t *testing.T) {
+	if _, err := Encode(nil); err == nil {
+		t.Errorf("No error seen for empty certificate list")
+	}
+}
+
+func TestEncodeInvalidCertificate(t *testing.T) {
+	if _, err := Encode([][]byte{{0x01, 0x02}}); err == nil {
+		t.Errorf("No error seen for invalid certificate")
+	}
+}
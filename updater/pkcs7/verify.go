@@ -0,0 +1,224 @@
+package pkcs7
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/subtle"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+var (
+	oidAttributeContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttributeMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidRSAEncryption          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+)
+
+// digestAlgorithms maps supported digest algorithm OIDs to their hash and RSA signature algorithm
+var digestAlgorithms = map[string]struct {
+	Hash               crypto.Hash
+	SignatureAlgorithm x509.SignatureAlgorithm
+}{
+	"1.3.14.3.2.26":          {crypto.SHA1, x509.SHA1WithRSA},
+	"2.16.840.1.101.3.4.2.1": {crypto.SHA256, x509.SHA256WithRSA},
+	"2.16.840.1.101.3.4.2.2": {crypto.SHA384, x509.SHA384WithRSA},
+	"2.16.840.1.101.3.4.2.3": {crypto.SHA512, x509.SHA512WithRSA},
+}
+
+type signerInfo struct {
+	Version               int
+	IssuerAndSerialNumber struct {
+		Issuer       asn1.RawValue
+		SerialNumber *big.Int
+	}
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue `asn1:"set"`
+}
+
+// VerifyOptions controls how the signature of a SignedData structure is verified
+type VerifyOptions struct {
+	// The trusted root certificates for the signers certificate chain. Required.
+	Roots *x509.CertPool
+	// If true, the signers certificate chain is verified as of the day before the signing certificate expires, rather
+	// than the current time. Some publishers, such as Microsoft, continue to distribute data signed by an expired
+	// certificate.
+	IgnoreExpiry bool
+}
+
+// SignedContent is the verified content of a SignedData structure
+type SignedContent struct {
+	// The type of the signed content
+	ContentType asn1.ObjectIdentifier
+	// The DER-encoded signed content
+	Content []byte
+	// The signers certificate chain, starting with the signing certificate
+	Chain []*x509.Certificate
+}
+
+// Verify will verify the signature of the given DER-encoded PKCS#7 SignedData structure and return its content. The
+// structure must have exactly one RSA signer with authenticated attributes, and the signers certificate must chain to
+// one of the roots in the options.
+func Verify(data []byte, options VerifyOptions) (*SignedContent, error) {
+	if options.Roots == nil {
+		return nil, fmt.Errorf("pkcs7: no roots")
+	}
+
+	sd, err := parseSignedData(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// The digest covers the contents octets of the content, excluding its tag and length
+	var content asn1.RawValue
+	if _, err := asn1.Unmarshal(sd.ContentInfo.Content.Bytes, &content); err != nil {
+		return nil, fmt.Errorf("pkcs7: invalid content: %s", err.Error())
+	}
+
+	certificates, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs7: %s", err.Error())
+	}
+
+	var signers []signerInfo
+	signerBytes := sd.SignerInfos.Bytes
+	for len(signerBytes) > 0 {
+		var signer signerInfo
+		signerBytes, err = asn1.Unmarshal(signerBytes, &signer)
+		if err != nil {
+			return nil, fmt.Errorf("pkcs7: invalid signer: %s", err.Error())
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) != 1 {
+		return nil, fmt.Errorf("pkcs7: unexpected number of signers. expected 1 got %d", len(signers))
+	}
+	signer := signers[0]
+
+	var signingCertificate *x509.Certificate
+	for _, certificate := range certificates {
+		if certificate.SerialNumber.Cmp(signer.IssuerAndSerialNumber.SerialNumber) == 0 && bytes.Equal(certificate.RawIssuer, signer.IssuerAndSerialNumber.Issuer.FullBytes) {
+			signingCertificate = certificate
+			break
+		}
+	}
+	if signingCertificate == nil {
+		return nil, fmt.Errorf("pkcs7: no certificate for signer")
+	}
+
+	algorithm, ok := digestAlgorithms[signer.DigestAlgorithm.Algorithm.String()]
+	if !ok {
+		return nil, fmt.Errorf("pkcs7: unsupported digest algorithm %s", signer.DigestAlgorithm.Algorithm.String())
+	}
+	if !signer.DigestEncryptionAlgorithm.Algorithm.Equal(oidRSAEncryption) {
+		return nil, fmt.Errorf("pkcs7: unsupported signature algorithm %s", signer.DigestEncryptionAlgorithm.Algorithm.String())
+	}
+
+	if len(signer.AuthenticatedAttributes.Bytes) == 0 {
+		return nil, fmt.Errorf("pkcs7: no authenticated attributes")
+	}
+	var messageDigest []byte
+	var contentType asn1.ObjectIdentifier
+	attributeBytes := signer.AuthenticatedAttributes.Bytes
+	for len(attributeBytes) > 0 {
+		var attr attribute
+		attributeBytes, err = asn1.Unmarshal(attributeBytes, &attr)
+		if err != nil {
+			return nil, fmt.Errorf("pkcs7: invalid authenticated attribute: %s", err.Error())
+		}
+		switch {
+		case attr.Type.Equal(oidAttributeMessageDigest):
+			if _, err := asn1.Unmarshal(attr.Values.Bytes, &messageDigest); err != nil {
+				return nil, fmt.Errorf("pkcs7: invalid message digest: %s", err.Error())
+			}
+		case attr.Type.Equal(oidAttributeContentType):
+			if _, err := asn1.Unmarshal(attr.Values.Bytes, &contentType); err != nil {
+				return nil, fmt.Errorf("pkcs7: invalid content type: %s", err.Error())
+			}
+		}
+	}
+	if !contentType.Equal(sd.ContentInfo.ContentType) {
+		return nil, fmt.Errorf("pkcs7: content type does not match")
+	}
+
+	h := algorithm.Hash.New()
+	h.Write(content.Bytes)
+	if subtle.ConstantTimeCompare(messageDigest, h.Sum(nil)) != 1 {
+		return nil, fmt.Errorf("pkcs7: message digest does not match")
+	}
+
+	// The signature covers the DER encoding of the authenticated attributes as a SET, rather than the implicit tag
+	signedAttributes := append([]byte{}, signer.AuthenticatedAttributes.FullBytes...)
+	signedAttributes[0] = 0x31
+	if err := signingCertificate.CheckSignature(algorithm.SignatureAlgorithm, signedAttributes, signer.EncryptedDigest); err != nil {
+		return nil, fmt.Errorf("pkcs7: %s", err.Error())
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, certificate := range certificates {
+		intermediates.AddCert(certificate)
+	}
+	verifyOptions := x509.VerifyOptions{
+		Roots:         options.Roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	if options.IgnoreExpiry {
+		verifyOptions.CurrentTime = signingCertificate.NotAfter.Add(-24 * time.Hour)
+	}
+	chains, err := signingCertificate.Verify(verifyOptions)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs7: failed to verify certificate chain: %s", err.Error())
+	}
+
+	return &SignedContent{
+		ContentType: sd.ContentInfo.ContentType,
+		Content:     sd.ContentInfo.Content.Bytes,
+		Chain:       chains[0],
+	}, nil
+}
+
+// Content returns the type and DER-encoded content of the given PKCS#7 SignedData structure without verifying its
+// signature. It must only be used for data whose signature was already verified by other means.
+func Content(data []byte) (asn1.ObjectIdentifier, []byte, error) {
+	sd, err := parseSignedData(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sd.ContentInfo.ContentType, sd.ContentInfo.Content.Bytes, nil
+}
+
+func parseSignedData(data []byte) (*signedData, error) {
+	var ci contentInfo
+	rest, err := asn1.Unmarshal(data, &ci)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs7: %s", err.Error())
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("pkcs7: unexpected trailing data")
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("pkcs7: unexpected content type %s", ci.ContentType.String())
+	}
+
+	sd := &signedData{}
+	rest, err = asn1.Unmarshal(ci.Content.Bytes, sd)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs7: %s", err.Error())
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("pkcs7: unexpected trailing data")
+	}
+	return sd, nil
+}
@@ -0,0 +1,173 @@
+package pkcs7
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+var oidCertificateTrustList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 10, 1}
+
+// testTrustList returns the committed authroot.stl and a pool containing the Microsoft root it is signed by
+func testTrustList(t *testing.T) ([]byte, *x509.CertPool) {
+	t.Helper()
+	data, err := os.ReadFile("../testdata/authroot.stl")
+	if err != nil {
+		t.Fatalf("Error reading trust list: %s", err.Error())
+	}
+	rootData, err := os.ReadFile("../microsoft_root_ca_2010.crt")
+	if err != nil {
+		t.Fatalf("Error reading root: %s", err.Error())
+	}
+	root, err := x509.ParseCertificate(rootData)
+	if err != nil {
+		t.Fatalf("Error parsing root: %s", err.Error())
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	return data, roots
+}
+
+// modifySigner will decode the trust list, modify its signer, and encode it again
+func modifySigner(t *testing.T, data []byte, modify func(signer *signerInfo)) []byte {
+	t.Helper()
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(data, &ci); err != nil {
+		t.Fatalf("Error decoding content info: %s", err.Error())
+	}
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		t.Fatalf("Error decoding signed data: %s", err.Error())
+	}
+	var signer signerInfo
+	if _, err := asn1.Unmarshal(sd.SignerInfos.Bytes, &signer); err != nil {
+		t.Fatalf("Error decoding signer: %s", err.Error())
+	}
+
+	modify(&signer)
+
+	signerBytes, err := asn1.Marshal(signer)
+	if err != nil {
+		t.Fatalf("Error encoding signer: %s", err.Error())
+	}
+	sd.SignerInfos = asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: signerBytes}
+	sdBytes, err := asn1.Marshal(sd)
+	if err != nil {
+		t.Fatalf("Error encoding signed data: %s", err.Error())
+	}
+	ci.Content = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sdBytes}
+	encoded, err := asn1.Marshal(ci)
+	if err != nil {
+		t.Fatalf("Error encoding content info: %s", err.Error())
+	}
+	return encoded
+}
+
+func TestVerifyTrustList(t *testing.T) {
+	data, roots := testTrustList(t)
+
+	signedContent, err := Verify(data, VerifyOptions{Roots: roots, IgnoreExpiry: true})
+	if err != nil {
+		t.Fatalf("Error verifying trust list: %s", err.Error())
+	}
+	if !signedContent.ContentType.Equal(oidCertificateTrustList) {
+		t.Errorf("Unexpected content type %s", signedContent.ContentType)
+	}
+	if len(signedContent.Content) == 0 || !bytes.Contains(data, signedContent.Content) {
+		t.Errorf("Unexpected content")
+	}
+	if len(signedContent.Chain) < 2 || signedContent.Chain[0].Subject.CommonName != "Microsoft Certificate Trust List Publisher" {
+		t.Errorf("Unexpected chain")
+	}
+
+	// The signing certificate of the committed trust list has expired
+	if _, err := Verify(data, VerifyOptions{Roots: roots}); err == nil {
+		t.Errorf("No error seen for expired signing certificate")
+	}
+
+	// Re-encoding the unmodified signer must not change the trust list, or the tests below would not be meaningful
+	if !bytes.Equal(modifySigner(t, data, func(signer *signerInfo) {}), data) {
+		t.Fatalf("Re-encoded trust list does not match")
+	}
+}
+
+func TestVerifyTrustListErrors(t *testing.T) {
+	data, roots := testTrustList(t)
+
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %s", err.Error())
+	}
+	otherTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Other Root"},
+		NotBefore:             time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:              time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	otherDER, err := x509.CreateCertificate(rand.Reader, otherTemplate, otherTemplate, &otherKey.PublicKey, otherKey)
+	if err != nil {
+		t.Fatalf("Error creating certificate: %s", err.Error())
+	}
+	otherRoot, err := x509.ParseCertificate(otherDER)
+	if err != nil {
+		t.Fatalf("Error parsing certificate: %s", err.Error())
+	}
+	otherRoots := x509.NewCertPool()
+	otherRoots.AddCert(otherRoot)
+
+	signedContent, err := Verify(data, VerifyOptions{Roots: roots, IgnoreExpiry: true})
+	if err != nil {
+		t.Fatalf("Error verifying trust list: %s", err.Error())
+	}
+	tamperedContent := bytes.Clone(data)
+	offset := bytes.Index(tamperedContent, signedContent.Content) + len(signedContent.Content)/2
+	tamperedContent[offset] ^= 0x01
+
+	tamperedAttribute := modifySigner(t, data, func(signer *signerInfo) {
+		// Modify the last byte of the last authenticated attribute, which is Microsoft's SpcSpOpusInfo. The content
+		// type and message digest attributes are still valid, so only the signature can detect this.
+		attributes := bytes.Clone(signer.AuthenticatedAttributes.FullBytes)
+		attributes[len(attributes)-1] ^= 0x01
+		signer.AuthenticatedAttributes = asn1.RawValue{FullBytes: attributes}
+	})
+
+	noAttributes := modifySigner(t, data, func(signer *signerInfo) {
+		signer.AuthenticatedAttributes = asn1.RawValue{}
+	})
+
+	for _, test := range []struct {
+		name    string
+		data    []byte
+		roots   *x509.CertPool
+		message string
+	}{
+		{name: "tampered content", data: tamperedContent, roots: roots, message: "message digest does not match"},
+		{name: "tampered attribute", data: tamperedAttribute, roots: roots, message: "verification error"},
+		{name: "untrusted root", data: data, roots: otherRoots, message: "failed to verify certificate chain"},
+		{name: "no authenticated attributes", data: noAttributes, roots: roots, message: "no authenticated attributes"},
+		{name: "no roots", data: data, message: "no roots"},
+		{name: "trailing data", data: append(bytes.Clone(data), 0), roots: roots, message: "trailing data"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Verify(test.data, VerifyOptions{Roots: test.roots, IgnoreExpiry: true})
+			if err == nil {
+				t.Fatalf("No error seen")
+			}
+			if !strings.Contains(err.Error(), test.message) {
+				t.Errorf("Unexpected error %s", err.Error())
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+)
+
+// vendorPlan describes the changes that would be made to a vendors bundle
+type vendorPlan struct {
+	Result    vendorResult
+	LastKey   string
+	LatestKey string
+	Diff      bundleDiff
+}
+
+// planVendors will query the given vendors for upstream changes and determine which certificates would be added or
+// removed, without modifying any files in the working directory. Vendors are built the same way as a normal update,
+// but into a temporary copy of the bundles in the working directory and with signing disabled.
+func planVendors(vendors []Vendor, metadata BundleMetadata) ([]vendorPlan, error) {
+	scratchDir, err := os.MkdirTemp("", "rootca_plan")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(scratchDir)
+
+	copyFiles := []string{microsoftBundleCacheName}
+	for _, vendor := range vendors {
+		for _, extension := range vendorBundleExtensions(vendor) {
+			copyFiles = append(copyFiles, vendor.BundleName()+extension)
+		}
+	}
+	for _, fileName := range copyFiles {
+		if !fileExists(fileName) {
+			continue
+		}
+		if err := copyFile(fileName, path.Join(scratchDir, fileName)); err != nil {
+			return nil, err
+		}
+	}
+
+	privateKey, publicKey := signingPrivateKey, signingPublicKey
+	signingPrivateKey, signingPublicKey = nil, nil
+	defer func() {
+		signingPrivateKey, signingPublicKey = privateKey, publicKey
+	}()
+
+	newMetadata, results, err := buildVendors(vendors, metadata, scratchDir)
+	if err != nil {
+		return nil, err
+	}
+
+	plans := make([]vendorPlan, len(results))
+	for i, result := range results {
+		plan := vendorPlan{
+			Result:    result,
+			LastKey:   metadata[result.Vendor.ID()].Key,
+			LatestKey: newMetadata[result.Vendor.ID()].Key,
+		}
+
+		if result.Status == vendorUpdated {
+			oldCerts, err := readBundleCertificates(result.Vendor.BundleName() + ".p7b")
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", result.Vendor.ID(), err.Error())
+			}
+			newCerts, err := readBundleCertificates(path.Join(scratchDir, result.Vendor.BundleName()+".p7b"))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", result.Vendor.ID(), err.Error())
+			}
+			plan.Diff = diffCertificates(oldCerts, newCerts)
+		}
+
+		plans[i] = plan
+	}
+
+	return plans, nil
+}
+
+// printVendorPlans will print a human readable description of the given plans to stdout
+func printVendorPlans(plans []vendorPlan) {
+	for _, plan := range plans {
+		vendorName := plan.Result.Vendor.Name()
+		switch plan.Result.Status {
+		case vendorUnchanged:
+			fmt.Printf("%s: no changes\n", vendorName)
+		case vendorFailed, vendorSkipped:
+			fmt.Printf("%s: %s (%s)\n", vendorName, plan.Result.Status, plan.Result.Err.Error())
+		case vendorUpdated:
+			fmt.Printf("%s: would update. LastKey='%s' LatestKey='%s'\n", vendorName, plan.LastKey, plan.LatestKey)
+			if !plan.Diff.HasChanges() {
+				fmt.Printf("  no certificates added or removed\n")
+			}
+			for _, cert := range plan.Diff.Added {
+				fmt.Printf("  + %s %s (expires %s)\n", cert.SHA256, cert.Subject, cert.NotAfter.Format("2006-01-02"))
+			}
+			for _, cert := range plan.Diff.Removed {
+				fmt.Printf("  - %s %s (expires %s)\n", cert.SHA256, cert.Subject, cert.NotAfter.Format("2006-01-02"))
+			}
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/pem"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// planTestVendor is the Mozilla vendor with a fixed latest version and certificates, so that it can be built without
+// network access
+type planTestVendor struct {
+	mozillaVendor
+	certs []*testCertificate
+}
+
+func (planTestVendor) LatestVersion(bundleDir string) (*VendorVersion, error) {
+	return &VendorVersion{Key: "latest", Date: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}, nil
+}
+
+func (v planTestVendor) Fetch(version *VendorVersion, bundleDir, dir string) ([]string, error) {
+	certPaths := []string{}
+	for _, cert := range v.certs {
+		certPath := path.Join(dir, testCertSHA(cert)+".crt")
+		if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Cert.Raw}), 0644); err != nil {
+			return nil, err
+		}
+		certPaths = append(certPaths, certPath)
+	}
+	return certPaths, nil
+}
+
+// snapshotDirectory returns the contents and mode of every file in dir, keyed by relative path
+func snapshotDirectory(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	snapshot := map[string]string{}
+	err := filepath.WalkDir(dir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			snapshot[relPath] = info.Mode().String()
+			return nil
+		}
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		snapshot[relPath] = info.Mode().String() + " " + string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error reading directory: %s", err.Error())
+	}
+	return snapshot
+}
+
+func TestPlanVendorsLeavesWorkingTreeUnchanged(t *testing.T) {
+	workDir := t.TempDir()
+	t.Chdir(workDir)
+
+	kept := generateTestCertificate(t, "Kept Root", nil, nil, nil)
+	removed := generateTestCertificate(t, "Removed Root", nil, nil, nil)
+	added := generateTestCertificate(t, "Added Root", nil, nil, nil)
+
+	writeTestBundle(t, ".", "mozilla", kept, removed)
+	if err := os.WriteFile(microsoftBundleCacheName, []byte("{}\n"), 0644); err != nil {
+		t.Fatalf("Error writing cache: %s", err.Error())
+	}
+	if err := os.WriteFile(BundleMetadataName, []byte("{}\n"), 0644); err != nil {
+		t.Fatalf("Error writing metadata: %s", err.Error())
+	}
+
+	testVendors := []Vendor{
+		planTestVendor{certs: []*testCertificate{kept, added}},
+		policyVendor{policy: bundlePolicy{
+			ID:         "example",
+			Name:       "Example",
+			BundleName: "example_bundle",
+			Inputs:     []string{"mozilla"},
+			Mode:       policyModeUnion,
+		}},
+	}
+	metadata := BundleMetadata{
+		"mozilla": {Key: "previous", Date: "2023-01-01T00:00:00Z"},
+	}
+
+	before := snapshotDirectory(t, workDir)
+	plans, err := planVendors(testVendors, metadata)
+	if err != nil {
+		t.Fatalf("Error planning vendors: %s", err.Error())
+	}
+	if wd, err := os.Getwd(); err != nil || wd != workDir {
+		t.Errorf("Working directory changed to %s", wd)
+	}
+	if after := snapshotDirectory(t, workDir); !reflect.DeepEqual(before, after) {
+		t.Errorf("Working tree was modified by plan")
+	}
+
+	if len(plans) != len(testVendors) {
+		t.Fatalf("Expected %d plans, got %d", len(testVendors), len(plans))
+	}
+	for _, plan := range plans {
+		if plan.Result.Status != vendorUpdated {
+			t.Fatalf("%s: expected updated, got %s (%v)", plan.Result.Vendor.ID(), plan.Result.Status, plan.Result.Err)
+		}
+	}
+
+	mozillaPlan := plans[0]
+	if mozillaPlan.LastKey != "previous" || mozillaPlan.LatestKey != "latest" {
+		t.Errorf("Unexpected keys %s %s", mozillaPlan.LastKey, mozillaPlan.LatestKey)
+	}
+	if len(mozillaPlan.Diff.Added) != 1 || mozillaPlan.Diff.Added[0].SHA256 != testCertSHA(added) {
+		t.Errorf("Unexpected added certificates %+v", mozillaPlan.Diff.Added)
+	}
+	if len(mozillaPlan.Diff.Removed) != 1 || mozillaPlan.Diff.Removed[0].SHA256 != testCertSHA(removed) {
+		t.Errorf("Unexpected removed certificates %+v", mozillaPlan.Diff.Removed)
+	}
+
+	// The policy reads its input from the newly built bundle rather than the one in the working directory
+	if policyAdded := plans[1].Diff.Added; len(policyAdded) != 2 {
+		t.Errorf("Expected 2 added certificates, got %d", len(policyAdded))
+	}
+}
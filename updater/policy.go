@@ -0,0 +1,364 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	_ "embed"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/tls-inspector/rootca/updater/rootca"
+)
+
+// defaultPolicyData is the bundle policy configuration used unless one is given with --policies
+//
+//go:embed bundle_policies.json
+var defaultPolicyData []byte
+
+// Policy modes, which determine how many input bundles a certificate must be present in
+const (
+	// The certificate must be present in every input
+	policyModeIntersection = "intersection"
+	// The certificate must be present in any input
+	policyModeUnion = "union"
+	// The certificate must be present in at least AtLeast inputs
+	policyModeAtLeast = "at_least"
+)
+
+var policyBundleNamePattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+var policyFingerprintPattern = regexp.MustCompile(`^[0-9A-F]{64}$`)
+
+// bundlePolicies is the bundle policy configuration file
+type bundlePolicies struct {
+	Bundles []bundlePolicy `json:"bundles"`
+}
+
+// bundlePolicy describes a derived bundle built from the bundles of other vendors
+type bundlePolicy struct {
+	// The vendor ID of the derived bundle
+	ID string `json:"id"`
+	// The human readable name of the derived bundle
+	Name string `json:"name"`
+	// The file name of the bundle, without an extension
+	BundleName string `json:"bundle_name"`
+	// The vendor IDs of the input bundles
+	Inputs []string `json:"inputs"`
+	// One of intersection, union, or at_least
+	Mode string `json:"mode"`
+	// The number of inputs a certificate must be present in, for the at_least mode
+	AtLeast int                 `json:"at_least,omitempty"`
+	Filters bundlePolicyFilters `json:"filters"`
+}
+
+// bundlePolicyFilters restrict which certificates are included in a derived bundle
+type bundlePolicyFilters struct {
+	// If set, only certificates with one of these public key algorithms are included, such as RSA, ECDSA, or Ed25519
+	KeyAlgorithms []string `json:"key_algorithms,omitempty"`
+	// The minimum key size in bits of each key algorithm, such as {"RSA": 2048}. For ECDSA this is the size of the
+	// curve.
+	MinKeySizes map[string]int `json:"min_key_sizes,omitempty"`
+	// If set, certificates that expire within this many days are excluded
+	ExpiryHorizonDays int `json:"expiry_horizon_days,omitempty"`
+	// The SHA-256 fingerprints of certificates to include if they are present in any input, regardless of the mode or
+	// other filters
+	Include []string `json:"include,omitempty"`
+	// The SHA-256 fingerprints of certificates to always exclude
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// loadPolicyVendors will read the bundle policy configuration from the given file, or the default configuration if
+// the path is empty, and return a vendor for each policy
+func loadPolicyVendors(policyPath string) ([]Vendor, error) {
+	data := defaultPolicyData
+	if policyPath != "" {
+		var err error
+		if data, err = os.ReadFile(policyPath); err != nil {
+			return nil, err
+		}
+	}
+
+	policies := bundlePolicies{}
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&policies); err != nil {
+		return nil, err
+	}
+
+	vendors := []Vendor{}
+	for _, policy := range policies.Bundles {
+		if err := policy.validate(); err != nil {
+			return nil, fmt.Errorf("policy %s: %s", policy.ID, err.Error())
+		}
+		vendors = append(vendors, policyVendor{policy: policy})
+	}
+	return vendors, nil
+}
+
+func (p *bundlePolicy) validate() error {
+	if p.ID == "" || p.Name == "" || p.BundleName == "" {
+		return fmt.Errorf("id, name, and bundle_name are required")
+	}
+	if !policyBundleNamePattern.MatchString(p.ID) || !policyBundleNamePattern.MatchString(p.BundleName) {
+		return fmt.Errorf("id and bundle_name may only contain lowercase letters, numbers, and underscores")
+	}
+	if len(p.Inputs) == 0 {
+		return fmt.Errorf("no inputs")
+	}
+	for i, input := range p.Inputs {
+		if sliceContains(p.Inputs[:i], input) {
+			return fmt.Errorf("duplicate input %s", input)
+		}
+	}
+
+	switch p.Mode {
+	case policyModeIntersection, policyModeUnion:
+		if p.AtLeast != 0 {
+			return fmt.Errorf("at_least is only valid with the %s mode", policyModeAtLeast)
+		}
+	case policyModeAtLeast:
+		if p.AtLeast < 1 || p.AtLeast > len(p.Inputs) {
+			return fmt.Errorf("at_least must be between 1 and the number of inputs")
+		}
+	default:
+		return fmt.Errorf("unknown mode '%s'", p.Mode)
+	}
+
+	for _, algorithm := range p.Filters.KeyAlgorithms {
+		if !isPolicyKeyAlgorithm(algorithm) {
+			return fmt.Errorf("unknown key algorithm %s", algorithm)
+		}
+	}
+	for algorithm, size := range p.Filters.MinKeySizes {
+		if !isPolicyKeyAlgorithm(algorithm) {
+			return fmt.Errorf("unknown key algorithm %s", algorithm)
+		}
+		if size < 0 {
+			return fmt.Errorf("invalid key size %d", size)
+		}
+	}
+	if p.Filters.ExpiryHorizonDays < 0 {
+		return fmt.Errorf("invalid expiry_horizon_days %d", p.Filters.ExpiryHorizonDays)
+	}
+	for _, fingerprints := range [][]string{p.Filters.Include, p.Filters.Exclude} {
+		for i, fingerprint := range fingerprints {
+			fingerprints[i] = strings.ToUpper(strings.ReplaceAll(fingerprint, ":", ""))
+			if !policyFingerprintPattern.MatchString(fingerprints[i]) {
+				return fmt.Errorf("invalid SHA-256 fingerprint %s", fingerprint)
+			}
+		}
+	}
+	for _, fingerprint := range p.Filters.Include {
+		if sliceContains(p.Filters.Exclude, fingerprint) {
+			return fmt.Errorf("fingerprint %s is both included and excluded", fingerprint)
+		}
+	}
+
+	return nil
+}
+
+func isPolicyKeyAlgorithm(algorithm string) bool {
+	return algorithm == x509.RSA.String() || algorithm == x509.ECDSA.String() || algorithm == x509.Ed25519.String()
+}
+
+// policyVendor is a derived vendor containing the certificates from its inputs selected by a bundle policy
+type policyVendor struct {
+	policy bundlePolicy
+}
+
+func (v policyVendor) ID() string         { return v.policy.ID }
+func (v policyVendor) Name() string       { return v.policy.Name }
+func (v policyVendor) BundleName() string { return v.policy.BundleName }
+func (v policyVendor) Inputs() []string   { return v.policy.Inputs }
+
+// policyVersion is the set of certificates selected by a policy
+type policyVersion struct {
+	// The PEM-encoded certificates, keyed by SHA-256 fingerprint
+	certs map[string][]byte
+	// The identity of each certificate across the inputs, keyed by SHA-256 fingerprint
+	identities map[string]*rootca.IdentityAttributes
+}
+
+// LatestVersion will select the certificates from the input bundles according to the policy. Certificates are matched
+// across inputs by their subject and public key, and when inputs contain different certificates for the same subject
+// and public key the canonical variant is selected. The key of the version is the checksum of the selected
+// certificates and the inputs that contain each of their variants.
+func (v policyVendor) LatestVersion(bundleDir string) (*VendorVersion, error) {
+	inputCerts := map[string][]x509.Certificate{}
+	for _, input := range v.policy.Inputs {
+		inputDir, err := os.MkdirTemp("", input)
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(inputDir)
+		if err := extractP7B(path.Join(bundleDir, getVendor(input).BundleName()+".p7b"), inputDir); err != nil {
+			return nil, err
+		}
+		certs, err := scanDirectoryForCertificates(inputDir)
+		if err != nil {
+			return nil, err
+		}
+		inputCerts[input] = certs
+	}
+
+	required := len(v.policy.Inputs)
+	switch v.policy.Mode {
+	case policyModeUnion:
+		required = 1
+	case policyModeAtLeast:
+		required = v.policy.AtLeast
+	}
+
+	now := time.Now().UTC()
+	version := policyVersion{
+		certs:      map[string][]byte{},
+		identities: map[string]*rootca.IdentityAttributes{},
+	}
+	h := sha256.New()
+	for _, group := range groupCertificates(v.policy.Inputs, inputCerts) {
+		selected := v.policy.Filters.selectVariant(group, len(group.vendors(v.policy.Inputs)) >= required, now)
+		if selected == nil {
+			continue
+		}
+		if group.ambiguous() {
+			logWarning("%s: certificates with the same subject and public key differ between inputs, using %s. %s", v.policy.Name, selected.sha, group.describe())
+		}
+
+		identity := group.identityAttributes(selected, v.policy.Inputs)
+		version.certs[selected.sha] = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: selected.cert.Raw})
+		version.identities[selected.sha] = identity
+		fmt.Fprintf(h, "%s:%s\n", selected.sha, strings.Join(identity.Vendors, ","))
+		for _, variant := range identity.Variants {
+			fmt.Fprintf(h, "%s>%s:%s\n", selected.sha, variant.SHA256, strings.Join(variant.Vendors, ","))
+		}
+	}
+	for _, sha := range v.policy.Filters.Include {
+		if _, ok := version.certs[sha]; !ok {
+			log.Printf("Included certificate %s is not selected from any input of %s", sha, v.policy.ID)
+		}
+	}
+
+	return &VendorVersion{Key: fmt.Sprintf("%X", h.Sum(nil)), Extra: version}, nil
+}
+
+// selectVariant returns the variant of the group to include in the bundle, or nil if the group is not included.
+// Excluded variants are never selected. If a variant is explicitly included it is selected, otherwise the canonical
+// variant of those that pass the filters is selected if the group is present in enough inputs.
+func (f bundlePolicyFilters) selectVariant(group *certificateGroup, enoughInputs bool, now time.Time) *certificateVariant {
+	candidates := []*certificateVariant{}
+	for _, variant := range group.variants {
+		if sliceContains(f.Exclude, variant.sha) {
+			continue
+		}
+		if sliceContains(f.Include, variant.sha) {
+			return variant
+		}
+		if f.matches(variant.cert, now) {
+			candidates = append(candidates, variant)
+		}
+	}
+	if !enoughInputs {
+		return nil
+	}
+	return group.canonical(candidates)
+}
+
+// matches returns true if the certificate passes the key and expiry filters
+func (f bundlePolicyFilters) matches(cert *x509.Certificate, now time.Time) bool {
+	algorithm := cert.PublicKeyAlgorithm.String()
+	if len(f.KeyAlgorithms) > 0 && !sliceContains(f.KeyAlgorithms, algorithm) {
+		return false
+	}
+	if minSize, ok := f.MinKeySizes[algorithm]; ok && publicKeySize(cert) < minSize {
+		return false
+	}
+	if f.ExpiryHorizonDays > 0 && cert.NotAfter.Before(now.AddDate(0, 0, f.ExpiryHorizonDays)) {
+		return false
+	}
+	return true
+}
+
+// publicKeySize returns the size of the certificates public key in bits, or 0 if the key type is not supported
+func publicKeySize(cert *x509.Certificate) int {
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return pub.N.BitLen()
+	case *ecdsa.PublicKey:
+		return pub.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return len(pub) * 8
+	}
+	return 0
+}
+
+func (policyVendor) Fetch(version *VendorVersion, bundleDir, dir string) ([]string, error) {
+	certPaths := []string{}
+	for sha, pemData := range version.Extra.(policyVersion).certs {
+		certPath := path.Join(dir, sha+".crt")
+		if err := os.WriteFile(certPath, pemData, 0644); err != nil {
+			return nil, err
+		}
+		certPaths = append(certPaths, certPath)
+	}
+	return certPaths, nil
+}
+
+// Build will generate the bundle and record the identity of each certificate across the inputs in the metadata
+func (v policyVendor) Build(version *VendorVersion, bundleDir string, certPaths []string) (*VendorMetadata, error) {
+	identities := version.Extra.(policyVersion).identities
+
+	metadata, err := generateVendorBundle(v, version, bundleDir, certPaths)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, certificate := range metadata.Certificates {
+		identity, ok := identities[certificate.SHA256]
+		if !ok {
+			return nil, fmt.Errorf("no identity for certificate %s", certificate.SHA256)
+		}
+		metadata.Certificates[i].Identity = identity
+	}
+
+	return metadata, nil
+}
+
+func scanDirectoryForCertificates(dirName string) ([]x509.Certificate, error) {
+	files, err := os.ReadDir(dirName)
+	if err != nil {
+		return nil, err
+	}
+	certificates := []x509.Certificate{}
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".crt") {
+			continue
+		}
+
+		data, err := os.ReadFile(path.Join(dirName, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		certPem, _ := pem.Decode(data)
+		if certPem == nil {
+			return nil, fmt.Errorf("invalid certificate pem at %s/%s", dirName, file.Name())
+		}
+		cert, err := x509.ParseCertificate(certPem.Bytes)
+		if err != nil {
+			log.Printf("Warning: found invalid certificate at %s/%s: %s", dirName, file.Name(), err.Error())
+			return nil, err
+		}
+
+		certificates = append(certificates, *cert)
+	}
+	return certificates, nil
+}
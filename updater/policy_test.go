@@ -0,0 +1,228 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tls-inspector/rootca/updater/pkcs7"
+	"github.com/tls-inspector/rootca/updater/rootca"
+)
+
+func TestLoadPolicyVendorsDefault(t *testing.T) {
+	policyVendors, err := loadPolicyVendors("")
+	if err != nil {
+		t.Fatalf("Error loading default policies: %s", err.Error())
+	}
+	if len(policyVendors) == 0 {
+		t.Fatalf("No default policies")
+	}
+	if err := validateVendorGraph(append(append([]Vendor{}, vendors...), policyVendors...)); err != nil {
+		t.Errorf("Invalid default policies: %s", err.Error())
+	}
+}
+
+func TestLoadPolicyVendorsFile(t *testing.T) {
+	policyPath := path.Join(t.TempDir(), "policies.json")
+
+	if err := os.WriteFile(policyPath, []byte(`{"bundles":[{"id":"example","name":"Example","bundle_name":"example_bundle","inputs":["apple","mozilla"],"mode":"union"}]}`), 0644); err != nil {
+		t.Fatalf("Error writing policies: %s", err.Error())
+	}
+	policyVendors, err := loadPolicyVendors(policyPath)
+	if err != nil {
+		t.Fatalf("Error loading policies: %s", err.Error())
+	}
+	if len(policyVendors) != 1 || policyVendors[0].ID() != "example" || policyVendors[0].BundleName() != "example_bundle" {
+		t.Errorf("Unexpected vendors %+v", policyVendors)
+	}
+
+	if err := os.WriteFile(policyPath, []byte(`{"bundles":[{"id":"example","unknown":true}]}`), 0644); err != nil {
+		t.Fatalf("Error writing policies: %s", err.Error())
+	}
+	if _, err := loadPolicyVendors(policyPath); err == nil {
+		t.Errorf("No error seen for unknown field")
+	}
+
+	if _, err := loadPolicyVendors(path.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Errorf("No error seen for missing file")
+	}
+}
+
+func TestBundlePolicyValidate(t *testing.T) {
+	fingerprint := strings.Repeat("ab", 32)
+	valid := func() bundlePolicy {
+		return bundlePolicy{
+			ID:         "example",
+			Name:       "Example",
+			BundleName: "example_bundle",
+			Inputs:     []string{"apple", "mozilla"},
+			Mode:       policyModeIntersection,
+		}
+	}
+
+	policy := valid()
+	policy.Filters.Include = []string{strings.Repeat("ab:", 31) + "ab"}
+	if err := policy.validate(); err != nil {
+		t.Fatalf("Error validating policy: %s", err.Error())
+	}
+	if policy.Filters.Include[0] != strings.ToUpper(fingerprint) {
+		t.Errorf("Fingerprint was not normalized: %s", policy.Filters.Include[0])
+	}
+
+	for _, test := range []struct {
+		name   string
+		modify func(p *bundlePolicy)
+	}{
+		{name: "missing id", modify: func(p *bundlePolicy) { p.ID = "" }},
+		{name: "invalid bundle name", modify: func(p *bundlePolicy) { p.BundleName = "../bundle" }},
+		{name: "no inputs", modify: func(p *bundlePolicy) { p.Inputs = nil }},
+		{name: "duplicate input", modify: func(p *bundlePolicy) { p.Inputs = []string{"apple", "apple"} }},
+		{name: "unknown mode", modify: func(p *bundlePolicy) { p.Mode = "majority" }},
+		{name: "at_least without mode", modify: func(p *bundlePolicy) { p.AtLeast = 1 }},
+		{name: "at_least too large", modify: func(p *bundlePolicy) { p.Mode = policyModeAtLeast; p.AtLeast = 3 }},
+		{name: "at_least zero", modify: func(p *bundlePolicy) { p.Mode = policyModeAtLeast }},
+		{name: "unknown key algorithm", modify: func(p *bundlePolicy) { p.Filters.KeyAlgorithms = []string{"DSA"} }},
+		{name: "unknown key size algorithm", modify: func(p *bundlePolicy) { p.Filters.MinKeySizes = map[string]int{"DSA": 1024} }},
+		{name: "negative key size", modify: func(p *bundlePolicy) { p.Filters.MinKeySizes = map[string]int{"RSA": -1} }},
+		{name: "negative expiry horizon", modify: func(p *bundlePolicy) { p.Filters.ExpiryHorizonDays = -1 }},
+		{name: "invalid fingerprint", modify: func(p *bundlePolicy) { p.Filters.Exclude = []string{"AB"} }},
+		{name: "included and excluded", modify: func(p *bundlePolicy) {
+			p.Filters.Include = []string{fingerprint}
+			p.Filters.Exclude = []string{strings.ToUpper(fingerprint)}
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			policy := valid()
+			test.modify(&policy)
+			if err := policy.validate(); err == nil {
+				t.Errorf("No error seen")
+			}
+		})
+	}
+}
+
+// writeTestBundle will write a p7b bundle containing the given certificates for the vendor into dir
+func writeTestBundle(t *testing.T, dir, vendorID string, certs ...*testCertificate) {
+	t.Helper()
+	derCerts := [][]byte{}
+	for _, cert := range certs {
+		derCerts = append(derCerts, cert.Cert.Raw)
+	}
+	p7Data, err := pkcs7.EncodePEM(derCerts)
+	if err != nil {
+		t.Fatalf("Error encoding bundle: %s", err.Error())
+	}
+	if err := os.WriteFile(path.Join(dir, getVendor(vendorID).BundleName()+".p7b"), p7Data, 0644); err != nil {
+		t.Fatalf("Error writing bundle: %s", err.Error())
+	}
+}
+
+func testCertSHA(cert *testCertificate) string {
+	return fmt.Sprintf("%X", sha256.Sum256(cert.Cert.Raw))
+}
+
+func TestPolicyVendorLatestVersion(t *testing.T) {
+	dir := t.TempDir()
+
+	shared := generateTestCertificate(t, "Shared Root", nil, nil, nil)
+	mozillaOnly := generateTestCertificate(t, "Mozilla Root", nil, nil, nil)
+	variant := generateTestCertificate(t, "Variant Root", &x509.Certificate{NotAfter: time.Date(2035, 1, 1, 0, 0, 0, 0, time.UTC)}, nil, nil)
+	reissued := generateTestCertificate(t, "Variant Root", &x509.Certificate{NotAfter: time.Date(2045, 1, 1, 0, 0, 0, 0, time.UTC)}, nil, variant.Key)
+	expiring := generateTestCertificate(t, "Expiring Root", &x509.Certificate{NotAfter: time.Now().AddDate(0, 0, 30)}, nil, nil)
+
+	writeTestBundle(t, dir, "mozilla", shared, mozillaOnly, variant, expiring)
+	writeTestBundle(t, dir, "apple", shared, reissued, expiring)
+
+	policy := func(mode string, atLeast int, filters bundlePolicyFilters) policyVendor {
+		return policyVendor{policy: bundlePolicy{
+			ID:         "example",
+			Name:       "Example",
+			BundleName: "example_bundle",
+			Inputs:     []string{"mozilla", "apple"},
+			Mode:       mode,
+			AtLeast:    atLeast,
+			Filters:    filters,
+		}}
+	}
+
+	for _, test := range []struct {
+		name     string
+		vendor   policyVendor
+		expected []*testCertificate
+	}{
+		{name: "intersection", vendor: policy(policyModeIntersection, 0, bundlePolicyFilters{}), expected: []*testCertificate{shared, reissued, expiring}},
+		{name: "at least", vendor: policy(policyModeAtLeast, 2, bundlePolicyFilters{}), expected: []*testCertificate{shared, reissued, expiring}},
+		{name: "union", vendor: policy(policyModeUnion, 0, bundlePolicyFilters{}), expected: []*testCertificate{shared, mozillaOnly, reissued, expiring}},
+		{name: "filters", vendor: policy(policyModeIntersection, 0, bundlePolicyFilters{
+			ExpiryHorizonDays: 365,
+			Include:           []string{testCertSHA(mozillaOnly)},
+			Exclude:           []string{testCertSHA(shared), testCertSHA(reissued)},
+		}), expected: []*testCertificate{mozillaOnly, variant}},
+		{name: "key algorithm", vendor: policy(policyModeUnion, 0, bundlePolicyFilters{KeyAlgorithms: []string{"RSA"}}), expected: []*testCertificate{}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			version, err := test.vendor.LatestVersion(dir)
+			if err != nil {
+				t.Fatalf("Error getting version: %s", err.Error())
+			}
+
+			selected := []string{}
+			for sha := range version.Extra.(policyVersion).certs {
+				selected = append(selected, sha)
+			}
+			expected := []string{}
+			for _, cert := range test.expected {
+				expected = append(expected, testCertSHA(cert))
+			}
+			sort.Strings(selected)
+			sort.Strings(expected)
+			if !reflect.DeepEqual(selected, expected) {
+				t.Errorf("Unexpected certificates %v, expected %v", selected, expected)
+			}
+
+			again, err := test.vendor.LatestVersion(dir)
+			if err != nil {
+				t.Fatalf("Error getting version: %s", err.Error())
+			}
+			if again.Key != version.Key {
+				t.Errorf("Version key is not stable")
+			}
+		})
+	}
+
+	version, err := policy(policyModeIntersection, 0, bundlePolicyFilters{}).LatestVersion(dir)
+	if err != nil {
+		t.Fatalf("Error getting version: %s", err.Error())
+	}
+	expected := &rootca.IdentityAttributes{
+		Vendors:  []string{"mozilla", "apple"},
+		Variants: []rootca.CertificateVariant{{SHA256: testCertSHA(variant), Vendors: []string{"mozilla"}}},
+	}
+	if identity := version.Extra.(policyVersion).identities[testCertSHA(reissued)]; !reflect.DeepEqual(identity, expected) {
+		t.Errorf("Unexpected identity %+v", identity)
+	}
+}
+
+func TestPolicyVendorLatestVersionMissingInput(t *testing.T) {
+	vendor := policyVendor{policy: bundlePolicy{ID: "example", Inputs: []string{"mozilla"}, Mode: policyModeUnion}}
+	if _, err := vendor.LatestVersion(t.TempDir()); err == nil {
+		t.Errorf("No error seen for missing input bundle")
+	}
+}
+
+func TestScanDirectoryForCertificatesInvalidPEM(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(path.Join(dir, "invalid.crt"), []byte("not pem"), 0644); err != nil {
+		t.Fatalf("Error writing certificate: %s", err.Error())
+	}
+	if _, err := scanDirectoryForCertificates(dir); err == nil {
+		t.Errorf("No error seen for invalid pem")
+	}
+}
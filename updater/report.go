@@ -1,114 +1,335 @@
 package main
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
 	"crypto/sha1"
 	"crypto/sha256"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	csvEncoder "encoding/csv"
-	"encoding/pem"
+	"encoding/json"
 	"fmt"
+	"net"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"sort"
 	"strings"
 	"time"
+
+	"github.com/tls-inspector/rootca/updater/pkcs7"
+	"github.com/tls-inspector/rootca/updater/rootca"
 )
 
-func ExportReport() error {
-	type tReportCertificate struct {
-		Vendor        string
-		Name          string
-		Serial        []byte
-		KeyIdentifier string
-		NotBefore     time.Time
-		NotAfter      time.Time
-		SHA1          string
-		SHA256        string
-	}
+const (
+	reportCSVName        = "certificates.csv"
+	reportJSONLinesName  = "certificates.jsonl"
+	reportSchemaVersion  = 1
+	reportVendorJSONName = "certificates_%s.json"
+)
 
-	certificatesFromP7 := func(vendor, p7File string) ([]tReportCertificate, error) {
-		output, err := exec.Command(opensslPath, "pkcs7", "-in", p7File, "-print_certs").CombinedOutput()
-		if err != nil {
-			return nil, err
+// reportCertificate describes a single certificate in a vendors bundle. The JSON representation of this type is
+// documented in README.md and must remain backwards compatible.
+type reportCertificate struct {
+	Vendor        string     `json:"vendor"`
+	VendorName    string     `json:"vendor_name"`
+	SHA1          string     `json:"sha1"`
+	SHA256        string     `json:"sha256"`
+	SPKISHA256    string     `json:"spki_sha256"`
+	Serial        string     `json:"serial"`
+	KeyIdentifier string     `json:"key_identifier"`
+	Subject       reportName `json:"subject"`
+	Issuer        reportName `json:"issuer"`
+	NotBefore     time.Time  `json:"not_before"`
+	NotAfter      time.Time  `json:"not_after"`
+	PublicKey     reportKey  `json:"public_key"`
+	// The signature algorithm used by the issuer to sign the certificate
+	SignatureAlgorithm string `json:"signature_algorithm"`
+	// The basic constraints path length, nil if there is no constraint
+	PathLength      *int                   `json:"path_length"`
+	NameConstraints *reportNameConstraints `json:"name_constraints,omitempty"`
+	// The certificate policy OIDs in dotted notation
+	Policies []string `json:"policies"`
+	DER      []byte   `json:"der"`
+}
+
+// reportName describes the subject or issuer of a certificate
+type reportName struct {
+	DN                 string   `json:"dn"`
+	CommonName         string   `json:"common_name,omitempty"`
+	SerialNumber       string   `json:"serial_number,omitempty"`
+	Organization       []string `json:"organization,omitempty"`
+	OrganizationalUnit []string `json:"organizational_unit,omitempty"`
+	Country            []string `json:"country,omitempty"`
+	Province           []string `json:"province,omitempty"`
+	Locality           []string `json:"locality,omitempty"`
+	StreetAddress      []string `json:"street_address,omitempty"`
+	PostalCode         []string `json:"postal_code,omitempty"`
+}
+
+// reportKey describes the public key of a certificate
+type reportKey struct {
+	Algorithm string `json:"algorithm"`
+	Size      int    `json:"size"`
+	Curve     string `json:"curve,omitempty"`
+}
+
+// reportNameConstraints describes the name constraints extension of a certificate
+type reportNameConstraints struct {
+	Critical       bool     `json:"critical"`
+	PermittedDNS   []string `json:"permitted_dns,omitempty"`
+	ExcludedDNS    []string `json:"excluded_dns,omitempty"`
+	PermittedIP    []string `json:"permitted_ip,omitempty"`
+	ExcludedIP     []string `json:"excluded_ip,omitempty"`
+	PermittedEmail []string `json:"permitted_email,omitempty"`
+	ExcludedEmail  []string `json:"excluded_email,omitempty"`
+	PermittedURI   []string `json:"permitted_uri,omitempty"`
+	ExcludedURI    []string `json:"excluded_uri,omitempty"`
+}
+
+// String returns the name constraints as a semicolon separated list, such as "permitted DNS:example.com"
+func (n *reportNameConstraints) String() string {
+	if n == nil {
+		return ""
+	}
+	constraints := []string{}
+	add := func(prefix string, values []string) {
+		for _, value := range values {
+			constraints = append(constraints, prefix+":"+value)
 		}
-		pemCerts := extractPemCerts(output)
-		reportCertificates := []tReportCertificate{}
-		for _, pemCert := range pemCerts {
-			certPem, _ := pem.Decode(pemCert)
-			cert, err := x509.ParseCertificate(certPem.Bytes)
-			if err != nil {
-				if strings.Contains(err.Error(), "negative serial number") {
-					continue
-				}
-				return nil, err
-			}
+	}
+	add("permitted DNS", n.PermittedDNS)
+	add("excluded DNS", n.ExcludedDNS)
+	add("permitted IP", n.PermittedIP)
+	add("excluded IP", n.ExcludedIP)
+	add("permitted email", n.PermittedEmail)
+	add("excluded email", n.ExcludedEmail)
+	add("permitted URI", n.PermittedURI)
+	add("excluded URI", n.ExcludedURI)
+	return strings.Join(constraints, ";")
+}
 
-			s1 := sha1.New()
-			s1.Write(cert.Raw)
-			s256 := sha256.New()
-			s256.Write(cert.Raw)
-
-			reportCertificates = append(reportCertificates, tReportCertificate{
-				Vendor:        vendor,
-				Name:          cert.Subject.ToRDNSequence().String(),
-				Serial:        cert.SerialNumber.Bytes(),
-				KeyIdentifier: fmt.Sprintf("%X", cert.SubjectKeyId),
-				NotBefore:     cert.NotBefore,
-				NotAfter:      cert.NotAfter,
-				SHA1:          fmt.Sprintf("%X", s1.Sum(nil)),
-				SHA256:        fmt.Sprintf("%X", s256.Sum(nil)),
-			})
+// reportVendor is the per-vendor JSON document
+type reportVendor struct {
+	SchemaVersion int                 `json:"schema_version"`
+	Vendor        string              `json:"vendor"`
+	VendorName    string              `json:"vendor_name"`
+	Date          string              `json:"date"`
+	Certificates  []reportCertificate `json:"certificates"`
+}
+
+func newReportName(name pkix.Name) reportName {
+	return reportName{
+		DN:                 name.ToRDNSequence().String(),
+		CommonName:         name.CommonName,
+		SerialNumber:       name.SerialNumber,
+		Organization:       name.Organization,
+		OrganizationalUnit: name.OrganizationalUnit,
+		Country:            name.Country,
+		Province:           name.Province,
+		Locality:           name.Locality,
+		StreetAddress:      name.StreetAddress,
+		PostalCode:         name.PostalCode,
+	}
+}
+
+func newReportKey(cert *x509.Certificate) reportKey {
+	key := reportKey{
+		Algorithm: cert.PublicKeyAlgorithm.String(),
+	}
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		key.Size = pub.N.BitLen()
+	case *ecdsa.PublicKey:
+		key.Size = pub.Curve.Params().BitSize
+		key.Curve = pub.Curve.Params().Name
+	case ed25519.PublicKey:
+		key.Size = len(pub) * 8
+		key.Curve = "Ed25519"
+	}
+	return key
+}
+
+func newReportNameConstraints(cert *x509.Certificate) *reportNameConstraints {
+	ipNets := func(nets []*net.IPNet) []string {
+		values := make([]string, len(nets))
+		for i, ipNet := range nets {
+			values[i] = ipNet.String()
 		}
-		sort.Slice(reportCertificates, func(i, j int) bool {
-			return reportCertificates[i].SHA256 > reportCertificates[j].SHA256
-		})
-		return reportCertificates, nil
+		return values
 	}
 
-	appleCertificates, err := certificatesFromP7("Apple", AppleBundleName+".p7b")
-	if err != nil {
-		return fmt.Errorf("apple: %s", err.Error())
+	constraints := &reportNameConstraints{
+		Critical:       cert.PermittedDNSDomainsCritical,
+		PermittedDNS:   cert.PermittedDNSDomains,
+		ExcludedDNS:    cert.ExcludedDNSDomains,
+		PermittedIP:    ipNets(cert.PermittedIPRanges),
+		ExcludedIP:     ipNets(cert.ExcludedIPRanges),
+		PermittedEmail: cert.PermittedEmailAddresses,
+		ExcludedEmail:  cert.ExcludedEmailAddresses,
+		PermittedURI:   cert.PermittedURIDomains,
+		ExcludedURI:    cert.ExcludedURIDomains,
 	}
-	googleCertificates, err := certificatesFromP7("Google", GoogleBundleName+".p7b")
-	if err != nil {
-		return fmt.Errorf("google: %s", err.Error())
+	if constraints.String() == "" {
+		return nil
 	}
-	microsoftCertificates, err := certificatesFromP7("Microsoft", MicrosoftBundleName+".p7b")
-	if err != nil {
-		return fmt.Errorf("microsoft: %s", err.Error())
+	return constraints
+}
+
+func newReportCertificate(vendor Vendor, cert *x509.Certificate) reportCertificate {
+	var pathLength *int
+	if cert.BasicConstraintsValid && (cert.MaxPathLen > 0 || cert.MaxPathLenZero) {
+		maxPathLen := cert.MaxPathLen
+		pathLength = &maxPathLen
+	}
+
+	policies := make([]string, len(cert.Policies))
+	for i, policy := range cert.Policies {
+		policies[i] = policy.String()
 	}
-	mozillaCertificates, err := certificatesFromP7("Mozilla", MozillaBundleName+".p7b")
+
+	return reportCertificate{
+		Vendor:        vendor.ID(),
+		VendorName:    vendor.Name(),
+		SHA1:          fmt.Sprintf("%X", sha1.Sum(cert.Raw)),
+		SHA256:        fmt.Sprintf("%X", sha256.Sum256(cert.Raw)),
+		SPKISHA256:    fmt.Sprintf("%X", sha256.Sum256(cert.RawSubjectPublicKeyInfo)),
+		Serial:        fmt.Sprintf("%X", cert.SerialNumber.Bytes()),
+		KeyIdentifier: fmt.Sprintf("%X", cert.SubjectKeyId),
+		Subject:       newReportName(cert.Subject),
+		Issuer:        newReportName(cert.Issuer),
+		NotBefore:     cert.NotBefore.UTC(),
+		NotAfter:      cert.NotAfter.UTC(),
+		PublicKey:     newReportKey(cert),
+
+		SignatureAlgorithm: cert.SignatureAlgorithm.String(),
+		PathLength:         pathLength,
+		NameConstraints:    newReportNameConstraints(cert),
+		Policies:           policies,
+		DER:                cert.Raw,
+	}
+}
+
+// readReportCertificates will describe every certificate in the vendors p7b bundle, sorted by SHA-256
+func readReportCertificates(vendor Vendor) ([]reportCertificate, error) {
+	p7Data, err := os.ReadFile(vendor.BundleName() + ".p7b")
 	if err != nil {
-		return fmt.Errorf("mozilla: %s", err.Error())
+		return nil, err
 	}
-	tlsinspectorCertificates, err := certificatesFromP7("TLSInspector", TLSInspectorBundleName+".p7b")
+	derCerts, err := pkcs7.Decode(p7Data)
 	if err != nil {
-		return fmt.Errorf("tlsinspector: %s", err.Error())
+		return nil, err
+	}
+	reportCertificates := []reportCertificate{}
+	for _, derCert := range derCerts {
+		cert, err := rootca.ParseCertificate(derCert)
+		if err != nil {
+			return nil, err
+		}
+		if cert == nil {
+			continue
+		}
+		reportCertificates = append(reportCertificates, newReportCertificate(vendor, cert))
+	}
+	sort.Slice(reportCertificates, func(i, j int) bool {
+		return reportCertificates[i].SHA256 > reportCertificates[j].SHA256
+	})
+	return reportCertificates, nil
+}
+
+// ExportReport will write a CSV file, a JSON Lines file, and a JSON file per vendor describing every certificate in the
+// bundles of all vendors present in the metadata
+func ExportReport(metadata BundleMetadata) error {
+	reportVendors := []reportVendor{}
+	for _, vendor := range vendors {
+		vendorMetadata, ok := metadata[vendor.ID()]
+		if !ok {
+			continue
+		}
+		certificates, err := readReportCertificates(vendor)
+		if err != nil {
+			return fmt.Errorf("%s: %s", vendor.ID(), err.Error())
+		}
+		reportVendors = append(reportVendors, reportVendor{
+			SchemaVersion: reportSchemaVersion,
+			Vendor:        vendor.ID(),
+			VendorName:    vendor.Name(),
+			Date:          vendorMetadata.Date,
+			Certificates:  certificates,
+		})
+	}
+
+	if err := exportReportCSV(reportVendors); err != nil {
+		return fmt.Errorf("%s: %s", reportCSVName, err.Error())
 	}
+	if err := exportReportJSON(reportVendors); err != nil {
+		return err
+	}
+
+	return nil
+}
 
-	os.Remove("certificates.csv")
-	f, err := os.OpenFile("certificates.csv", os.O_CREATE|os.O_RDWR, 0644)
+func exportReportCSV(reportVendors []reportVendor) error {
+	os.Remove(reportCSVName)
+	f, err := os.OpenFile(reportCSVName, os.O_CREATE|os.O_RDWR, 0644)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 	csv := csvEncoder.NewWriter(f)
-	err = csv.Write([]string{"Vendor", "Name", "Serial", "KeyIdentifier", "NotBefore", "NotAfter", "SHA1", "SHA256"})
+	err = csv.Write([]string{
+		"Vendor",
+		"Name",
+		"Serial",
+		"KeyIdentifier",
+		"NotBefore",
+		"NotAfter",
+		"SHA1",
+		"SHA256",
+		"SubjectCN",
+		"SubjectO",
+		"SubjectC",
+		"KeyAlgorithm",
+		"KeySize",
+		"KeyCurve",
+		"SignatureAlgorithm",
+		"PathLength",
+		"NameConstraints",
+		"Policies",
+		"SPKISHA256",
+	})
 	if err != nil {
 		return err
 	}
 
-	for _, certs := range [][]tReportCertificate{appleCertificates, googleCertificates, microsoftCertificates, mozillaCertificates, tlsinspectorCertificates} {
-		for _, cert := range certs {
+	for _, reportVendor := range reportVendors {
+		for _, cert := range reportVendor.Certificates {
+			pathLength := ""
+			if cert.PathLength != nil {
+				pathLength = fmt.Sprintf("%d", *cert.PathLength)
+			}
 			err = csv.Write([]string{
-				cert.Vendor,
-				cert.Name,
-				fmt.Sprintf("%X", cert.Serial),
+				cert.VendorName,
+				cert.Subject.DN,
+				cert.Serial,
 				cert.KeyIdentifier,
 				cert.NotBefore.Format(time.RFC3339),
 				cert.NotAfter.Format(time.RFC3339),
 				cert.SHA1,
 				cert.SHA256,
+				cert.Subject.CommonName,
+				strings.Join(cert.Subject.Organization, ";"),
+				strings.Join(cert.Subject.Country, ";"),
+				cert.PublicKey.Algorithm,
+				fmt.Sprintf("%d", cert.PublicKey.Size),
+				cert.PublicKey.Curve,
+				cert.SignatureAlgorithm,
+				pathLength,
+				cert.NameConstraints.String(),
+				strings.Join(cert.Policies, ";"),
+				cert.SPKISHA256,
 			})
 			if err != nil {
 				return err
@@ -117,5 +338,48 @@ func ExportReport() error {
 		csv.Flush()
 	}
 
+	return csv.Error()
+}
+
+// exportReportJSON will write the JSON Lines file and a JSON file per vendor, removing the JSON files of any vendors
+// that are no longer in the report
+func exportReportJSON(reportVendors []reportVendor) error {
+	jsonLines := &bytes.Buffer{}
+	encoder := json.NewEncoder(jsonLines)
+	vendorJSONNames := map[string]bool{}
+	for _, reportVendor := range reportVendors {
+		for _, cert := range reportVendor.Certificates {
+			if err := encoder.Encode(cert); err != nil {
+				return err
+			}
+		}
+
+		vendorJSONName := fmt.Sprintf(reportVendorJSONName, reportVendor.Vendor)
+		vendorJSON, err := json.MarshalIndent(reportVendor, "", "  ")
+		if err != nil {
+			return fmt.Errorf("%s: %s", vendorJSONName, err.Error())
+		}
+		if err := writeFileAtomic(vendorJSONName, append(vendorJSON, '\n')); err != nil {
+			return fmt.Errorf("%s: %s", vendorJSONName, err.Error())
+		}
+		vendorJSONNames[vendorJSONName] = true
+	}
+
+	if err := writeFileAtomic(reportJSONLinesName, jsonLines.Bytes()); err != nil {
+		return fmt.Errorf("%s: %s", reportJSONLinesName, err.Error())
+	}
+
+	existingNames, err := filepath.Glob(fmt.Sprintf(reportVendorJSONName, "*"))
+	if err != nil {
+		return err
+	}
+	for _, existingName := range existingNames {
+		if vendorJSONNames[existingName] {
+			continue
+		}
+		if err := os.Remove(existingName); err != nil {
+			return fmt.Errorf("%s: %s", existingName, err.Error())
+		}
+	}
 	return nil
 }
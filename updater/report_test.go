@@ -0,0 +1,235 @@
+package main
+
+import (
+	"crypto/x509"
+	csvEncoder "encoding/csv"
+	"encoding/pem"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+// readTestReportCertificates returns the committed constrained test CA and the Microsoft root, which has no
+// constraints
+func readTestReportCertificates(t *testing.T) (constrained, unconstrained *x509.Certificate) {
+	t.Helper()
+	pemData, err := os.ReadFile("testdata/constrained_ca.pem")
+	if err != nil {
+		t.Fatalf("Error reading certificate: %s", err.Error())
+	}
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		t.Fatalf("Invalid pem data")
+	}
+	constrained, err = x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("Error parsing certificate: %s", err.Error())
+	}
+	unconstrained, err = x509.ParseCertificate(microsoftRootCAData)
+	if err != nil {
+		t.Fatalf("Error parsing certificate: %s", err.Error())
+	}
+	return constrained, unconstrained
+}
+
+func TestNewReportCertificate(t *testing.T) {
+	constrained, unconstrained := readTestReportCertificates(t)
+	zero := 0
+
+	for _, test := range []struct {
+		name               string
+		cert               *x509.Certificate
+		pathLength         *int
+		nameConstraints    *reportNameConstraints
+		nameConstraintsCSV string
+		policies           []string
+		publicKey          reportKey
+		signatureAlgorithm string
+		keyIdentifier      string
+	}{
+		{
+			name:       "constrained",
+			cert:       constrained,
+			pathLength: &zero,
+			nameConstraints: &reportNameConstraints{
+				Critical:       true,
+				PermittedDNS:   []string{"example.com"},
+				ExcludedDNS:    []string{"internal.example.com"},
+				PermittedIP:    []string{},
+				ExcludedIP:     []string{"192.168.0.0/16"},
+				PermittedEmail: []string{"example.com"},
+			},
+			nameConstraintsCSV: "permitted DNS:example.com;excluded DNS:internal.example.com;excluded IP:192.168.0.0/16;permitted email:example.com",
+			policies:           []string{"2.23.140.1.2.1", "1.3.6.1.4.1.99999.1"},
+			publicKey:          reportKey{Algorithm: "ECDSA", Size: 384, Curve: "P-384"},
+			signatureAlgorithm: "ECDSA-SHA384",
+			keyIdentifier:      "F35B5FB1C7F639C19774A7CD78CE70D6C9438447",
+		},
+		{
+			name:               "unconstrained",
+			cert:               unconstrained,
+			policies:           []string{},
+			publicKey:          reportKey{Algorithm: "RSA", Size: 4096},
+			signatureAlgorithm: "SHA256-RSA",
+			keyIdentifier:      "D5F656CB8FE8A25C6268D13D94905BD7CE9A18C4",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cert := newReportCertificate(mozillaVendor{}, test.cert)
+			if !reflect.DeepEqual(cert.PathLength, test.pathLength) {
+				t.Errorf("Unexpected path length %v", cert.PathLength)
+			}
+			if !reflect.DeepEqual(cert.NameConstraints, test.nameConstraints) {
+				t.Errorf("Unexpected name constraints %+v", cert.NameConstraints)
+			}
+			if cert.NameConstraints.String() != test.nameConstraintsCSV {
+				t.Errorf("Unexpected name constraints string %s", cert.NameConstraints.String())
+			}
+			if !reflect.DeepEqual(cert.Policies, test.policies) {
+				t.Errorf("Unexpected policies %v", cert.Policies)
+			}
+			if cert.PublicKey != test.publicKey {
+				t.Errorf("Unexpected public key %+v", cert.PublicKey)
+			}
+			if cert.SignatureAlgorithm != test.signatureAlgorithm {
+				t.Errorf("Unexpected signature algorithm %s", cert.SignatureAlgorithm)
+			}
+			if cert.KeyIdentifier != test.keyIdentifier {
+				t.Errorf("Unexpected key identifier %s", cert.KeyIdentifier)
+			}
+			if cert.Vendor != "mozilla" || cert.VendorName != "Mozilla" {
+				t.Errorf("Unexpected vendor %s %s", cert.Vendor, cert.VendorName)
+			}
+		})
+	}
+}
+
+func TestExportReportCSV(t *testing.T) {
+	constrained, unconstrained := readTestReportCertificates(t)
+	t.Chdir(t.TempDir())
+
+	err := exportReportCSV([]reportVendor{{
+		Vendor:       "mozilla",
+		Certificates: []reportCertificate{newReportCertificate(mozillaVendor{}, constrained), newReportCertificate(mozillaVendor{}, unconstrained)},
+	}})
+	if err != nil {
+		t.Fatalf("Error exporting report: %s", err.Error())
+	}
+
+	f, err := os.Open(reportCSVName)
+	if err != nil {
+		t.Fatalf("Error opening report: %s", err.Error())
+	}
+	defer f.Close()
+	rows, err := csvEncoder.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatalf("Error reading report: %s", err.Error())
+	}
+
+	expected := [][]string{
+		{"Vendor", "Name", "Serial", "KeyIdentifier", "NotBefore", "NotAfter", "SHA1", "SHA256", "SubjectCN", "SubjectO", "SubjectC", "KeyAlgorithm", "KeySize", "KeyCurve", "SignatureAlgorithm", "PathLength", "NameConstraints", "Policies", "SPKISHA256"},
+		{
+			"Mozilla",
+			"CN=Example Constrained Test Root CA,OU=Test Roots,O=Example Test,C=US",
+			"1234ABCD",
+			"F35B5FB1C7F639C19774A7CD78CE70D6C9438447",
+			"2026-10-17T09:51:19Z",
+			"2046-10-12T09:51:19Z",
+			"8E512360EC4B69D30E3A73B632E61E67C3DAB286",
+			"17EA953F543EFDE6B754E296C5DD342023546183AFC65F5845464CFBF2019680",
+			"Example Constrained Test Root CA",
+			"Example Test",
+			"US",
+			"ECDSA",
+			"384",
+			"P-384",
+			"ECDSA-SHA384",
+			"0",
+			"permitted DNS:example.com;excluded DNS:internal.example.com;excluded IP:192.168.0.0/16;permitted email:example.com",
+			"2.23.140.1.2.1;1.3.6.1.4.1.99999.1",
+			"2B4A5F8921D744C0327B7F4FD4ED37590419F28DA6802E17530321D098DE8127",
+		},
+		{
+			"Mozilla",
+			"CN=Microsoft Root Certificate Authority 2010,O=Microsoft Corporation,L=Redmond,ST=Washington,C=US",
+			"28CC3A25BFBA44AC449A9B586B4339AA",
+			"D5F656CB8FE8A25C6268D13D94905BD7CE9A18C4",
+			"2010-06-23T21:57:24Z",
+			"2035-06-23T22:04:01Z",
+			"3B1EFD3A66EA28B16697394703A72CA340A05BD5",
+			"DF545BF919A2439C36983B54CDFC903DFA4F37D3996D8D84B4C31EEC6F3C163E",
+			"Microsoft Root Certificate Authority 2010",
+			"Microsoft Corporation",
+			"US",
+			"RSA",
+			"4096",
+			"",
+			"SHA256-RSA",
+			"",
+			"",
+			"",
+			"C9905B0EE01202293CA026E64F08412442C5504C06E44CA7E9726D61F20E4089",
+		},
+	}
+	if len(rows) != len(expected) {
+		t.Fatalf("Expected %d rows, got %d", len(expected), len(rows))
+	}
+	for i := range expected {
+		if len(rows[i]) != len(expected[i]) {
+			t.Fatalf("Row %d: expected %d columns, got %d", i, len(expected[i]), len(rows[i]))
+		}
+		for j := range expected[i] {
+			if rows[i][j] != expected[i][j] {
+				t.Errorf("Row %d column %s: expected '%s', got '%s'", i, expected[0][j], expected[i][j], rows[i][j])
+			}
+		}
+	}
+}
+
+func TestExportReportJSONGolden(t *testing.T) {
+	constrained, _ := readTestReportCertificates(t)
+	goldenDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Error getting working directory: %s", err.Error())
+	}
+	goldenDir = path.Join(goldenDir, "testdata", "report")
+	t.Chdir(t.TempDir())
+
+	// A vendor that is no longer in the report
+	staleName := "certificates_removed.json"
+	if err := os.WriteFile(staleName, []byte("{}\n"), 0644); err != nil {
+		t.Fatalf("Error writing report: %s", err.Error())
+	}
+
+	reportVendors := []reportVendor{}
+	for _, vendor := range []Vendor{appleVendor{}, mozillaVendor{}} {
+		reportVendors = append(reportVendors, reportVendor{
+			SchemaVersion: reportSchemaVersion,
+			Vendor:        vendor.ID(),
+			VendorName:    vendor.Name(),
+			Date:          "2024-01-01T00:00:00Z",
+			Certificates:  []reportCertificate{newReportCertificate(vendor, constrained)},
+		})
+	}
+	if err := exportReportJSON(reportVendors); err != nil {
+		t.Fatalf("Error exporting report: %s", err.Error())
+	}
+
+	for _, fileName := range []string{reportJSONLinesName, "certificates_apple.json", "certificates_mozilla.json"} {
+		data, err := os.ReadFile(fileName)
+		if err != nil {
+			t.Fatalf("Error reading report: %s", err.Error())
+		}
+		expected, err := os.ReadFile(path.Join(goldenDir, fileName))
+		if err != nil {
+			t.Fatalf("Error reading golden file: %s", err.Error())
+		}
+		if string(data) != string(expected) {
+			t.Errorf("%s does not match golden file:\n%s", fileName, data)
+		}
+	}
+	if fileExists(staleName) {
+		t.Errorf("Stale vendor report was not removed")
+	}
+}
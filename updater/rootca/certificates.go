@@ -0,0 +1,75 @@
+package rootca
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+)
+
+// ExtractPEMCertificates will return each PEM-encoded certificate found in data. Any other text is ignored.
+func ExtractPEMCertificates(data []byte) [][]byte {
+	pemCerts := [][]byte{}
+	pem := []byte{}
+	isInCert := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Bytes()
+
+		if bytes.Equal(line, []byte("-----BEGIN CERTIFICATE-----")) {
+			isInCert = true
+		}
+
+		if isInCert {
+			pem = append(pem, line...)
+			pem = append(pem, byte('\n'))
+
+			if bytes.Equal(line, []byte("-----END CERTIFICATE-----")) {
+				pemCerts = append(pemCerts, pem)
+				pem = []byte{}
+				isInCert = false
+			}
+		}
+	}
+
+	return pemCerts
+}
+
+// ParsePEMCertificates will parse all PEM-encoded certificates found in data.
+//
+// Certificates with a negative serial number are skipped. Such certificates are invalid, but one is still trusted by
+// Microsoft and is therefor present in the Microsoft bundle.
+func ParsePEMCertificates(data []byte) ([]*x509.Certificate, error) {
+	certificates := []*x509.Certificate{}
+	for _, pemCert := range ExtractPEMCertificates(data) {
+		block, _ := pem.Decode(pemCert)
+		if block == nil {
+			return nil, fmt.Errorf("invalid pem data")
+		}
+		cert, err := ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if cert == nil {
+			continue
+		}
+		certificates = append(certificates, cert)
+	}
+	return certificates, nil
+}
+
+// ParseCertificate will parse the given DER-encoded certificate. If the certificate has a negative serial number then
+// nil is returned without an error.
+func ParseCertificate(der []byte) (*x509.Certificate, error) {
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		if strings.Contains(err.Error(), "negative serial number") {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return cert, nil
+}
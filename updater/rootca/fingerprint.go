@@ -0,0 +1,33 @@
+package rootca
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"strings"
+)
+
+// Fingerprint will calculate the fingerprints of the given data
+func Fingerprint(data []byte) BundleFingerprint {
+	return BundleFingerprint{
+		SHA1:   fmt.Sprintf("%X", sha1.Sum(data)),
+		SHA256: fmt.Sprintf("%X", sha256.Sum256(data)),
+		SHA512: fmt.Sprintf("%X", sha512.Sum512(data)),
+	}
+}
+
+// Verify will check that all checksums in the fingerprint match the given data
+func (f BundleFingerprint) Verify(data []byte) error {
+	actual := Fingerprint(data)
+	if !strings.EqualFold(f.SHA1, actual.SHA1) {
+		return fmt.Errorf("sha1 mismatch: expected %s got %s", f.SHA1, actual.SHA1)
+	}
+	if !strings.EqualFold(f.SHA256, actual.SHA256) {
+		return fmt.Errorf("sha256 mismatch: expected %s got %s", f.SHA256, actual.SHA256)
+	}
+	if !strings.EqualFold(f.SHA512, actual.SHA512) {
+		return fmt.Errorf("sha512 mismatch: expected %s got %s", f.SHA512, actual.SHA512)
+	}
+	return nil
+}
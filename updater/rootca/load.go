@@ -0,0 +1,283 @@
+package rootca
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"github.com/tls-inspector/rootca/updater/jks"
+	"github.com/tls-inspector/rootca/updater/pkcs12"
+	"github.com/tls-inspector/rootca/updater/pkcs7"
+)
+
+// Options control how bundles are loaded
+type Options struct {
+	// The PEM-encoded public key used to verify signatures. If empty, the signing key from the source is used, which
+	// only guards against corruption and not against a compromised source.
+	PublicKey []byte
+	// If true then signature files are not verified. Fingerprints from the metadata file are always verified.
+	SkipSignatures bool
+}
+
+// Bundles is a verified set of published bundles
+type Bundles struct {
+	// The metadata for all vendors
+	Metadata BundleMetadata
+	// The schema version of the metadata that was loaded
+	SchemaVersion int
+	// The bundle for each vendor, keyed by the vendor ID
+	Vendors map[string]*VendorBundle
+}
+
+// VendorBundle is the verified bundle of a single vendor
+type VendorBundle struct {
+	// The ID of the vendor, such as "mozilla"
+	ID string
+	// The metadata of the vendor
+	Metadata VendorMetadata
+	// The certificates in the vendors bundle
+	Certificates []*x509.Certificate
+}
+
+// CertPool returns a new certificate pool containing all certificates in the vendors bundle. Returns an error if the
+// bundle contains distrusted certificates, see IsDistrustVendor.
+func (b *VendorBundle) CertPool() (*x509.CertPool, error) {
+	if IsDistrustVendor(b.ID) {
+		return nil, fmt.Errorf("%s bundle contains distrusted certificates and is not a trust store", b.ID)
+	}
+	pool := x509.NewCertPool()
+	for _, cert := range b.Certificates {
+		pool.AddCert(cert)
+	}
+	return pool, nil
+}
+
+// VendorIDs returns the sorted IDs of all vendors in the bundles
+func (b *Bundles) VendorIDs() []string {
+	ids := make([]string, 0, len(b.Vendors))
+	for id := range b.Vendors {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// CertPool returns a new certificate pool containing all certificates of the given vendor. Returns an error if the
+// vendor is unknown or its bundle contains distrusted certificates.
+func (b *Bundles) CertPool(vendorID string) (*x509.CertPool, error) {
+	vendor, ok := b.Vendors[vendorID]
+	if !ok {
+		return nil, fmt.Errorf("unknown vendor %s", vendorID)
+	}
+	return vendor.CertPool()
+}
+
+// LoadDir will load and verify the bundles in the given directory
+func LoadDir(dir string, options *Options) (*Bundles, error) {
+	return Load(DirSource(dir), options)
+}
+
+// Load will load and verify the bundles from the given source. The metadata file and every bundle file it references
+// are checked against their signature and the fingerprints in the metadata.
+func Load(source Source, options *Options) (*Bundles, error) {
+	if options == nil {
+		options = &Options{}
+	}
+
+	l := &loader{source: source, options: options}
+
+	if !options.SkipSignatures {
+		publicKeyBytes := options.PublicKey
+		if len(publicKeyBytes) == 0 {
+			b, err := source.ReadFile(SigningKeyFileName)
+			if err != nil {
+				return nil, fmt.Errorf("signing key: %s", err.Error())
+			}
+			publicKeyBytes = b
+		}
+		publicKey, err := ParsePublicKey(publicKeyBytes)
+		if err != nil {
+			return nil, fmt.Errorf("signing key: %s", err.Error())
+		}
+		l.publicKey = publicKey
+	}
+
+	// Prefer version 2 metadata, falling back to version 1 only for older bundles that do not have it. Any other error,
+	// such as an invalid signature, must not be masked by the version 1 metadata.
+	metadataFileName := MetadataV2FileName
+	metadataBytes, err := l.readVerifiedFile(metadataFileName)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		metadataFileName = MetadataFileName
+		metadataBytes, err = l.readVerifiedFile(metadataFileName)
+		if err != nil {
+			return nil, err
+		}
+	}
+	metadata, schemaVersion, err := ParseMetadata(metadataBytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", metadataFileName, err.Error())
+	}
+
+	bundles := &Bundles{
+		Metadata:      metadata,
+		SchemaVersion: schemaVersion,
+		Vendors:       map[string]*VendorBundle{},
+	}
+	for vendorID, vendorMetadata := range metadata {
+		vendor, err := l.loadVendor(vendorID, vendorMetadata)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", vendorID, err.Error())
+		}
+		bundles.Vendors[vendorID] = vendor
+	}
+
+	return bundles, nil
+}
+
+type loader struct {
+	source    Source
+	options   *Options
+	publicKey *ecdsa.PublicKey
+}
+
+func (l *loader) loadVendor(vendorID string, metadata VendorMetadata) (*VendorBundle, error) {
+	var certificates []*x509.Certificate
+	var pemCertificates [][]byte
+	// The certificates of each other bundle file, keyed by file extension
+	bundleCertificates := map[string][][]byte{}
+	for fileName, fingerprint := range metadata.Bundles {
+		data, err := l.readVerifiedFile(fileName)
+		if err != nil {
+			return nil, err
+		}
+		if err := fingerprint.Verify(data); err != nil {
+			return nil, fmt.Errorf("%s: %s", fileName, err.Error())
+		}
+
+		if strings.HasSuffix(fileName, ".pem") {
+			certs, err := ParsePEMCertificates(data)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", fileName, err.Error())
+			}
+			certificates = certs
+			pemCertificates = ExtractPEMCertificates(data)
+		} else if strings.HasSuffix(fileName, ".p7b") {
+			certs, err := pkcs7.Decode(data)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", fileName, err.Error())
+			}
+			bundleCertificates["p7b"] = certs
+		} else if strings.HasSuffix(fileName, ".jks") {
+			entries, err := jks.Decode(data, TruststorePassword)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", fileName, err.Error())
+			}
+			for _, entry := range entries {
+				bundleCertificates["jks"] = append(bundleCertificates["jks"], entry.Certificate)
+			}
+		} else if strings.HasSuffix(fileName, ".p12") {
+			entries, err := pkcs12.Decode(data, TruststorePassword)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", fileName, err.Error())
+			}
+			for _, entry := range entries {
+				bundleCertificates["p12"] = append(bundleCertificates["p12"], entry.Certificate)
+			}
+		}
+	}
+	if certificates == nil {
+		return nil, fmt.Errorf("no pem bundle in metadata")
+	}
+	for extension, certs := range bundleCertificates {
+		if err := compareBundleCertificates(extension, certificates, certs); err != nil {
+			return nil, err
+		}
+	}
+
+	if metadata.Certificates != nil {
+		if err := compareMetadataCertificates(metadata.Certificates, pemCertificates); err != nil {
+			return nil, err
+		}
+	}
+
+	return &VendorBundle{
+		ID:           vendorID,
+		Metadata:     metadata,
+		Certificates: certificates,
+	}, nil
+}
+
+// readVerifiedFile will read the file from the source and verify its signature, unless signatures are skipped
+func (l *loader) readVerifiedFile(name string) ([]byte, error) {
+	data, err := l.source.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if l.options.SkipSignatures {
+		return data, nil
+	}
+
+	signature, err := l.source.ReadFile(name + SignatureExtension)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name+SignatureExtension, err.Error())
+	}
+	if err := VerifySignature(l.publicKey, data, signature); err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err.Error())
+	}
+	return data, nil
+}
+
+// compareBundleCertificates ensures that the bundle file with the given extension contains the same certificates as
+// the PEM bundle
+func compareBundleCertificates(extension string, pemCertificates []*x509.Certificate, bundleCertificates [][]byte) error {
+	bundleMap := map[string]bool{}
+	for _, der := range bundleCertificates {
+		cert, err := ParseCertificate(der)
+		if err != nil {
+			return fmt.Errorf("%s: %s", extension, err.Error())
+		}
+		if cert != nil {
+			bundleMap[string(cert.Raw)] = true
+		}
+	}
+	if len(bundleMap) != len(pemCertificates) {
+		return fmt.Errorf("%s contains %d certificates but pem contains %d", extension, len(bundleMap), len(pemCertificates))
+	}
+	for _, cert := range pemCertificates {
+		if !bundleMap[string(cert.Raw)] {
+			return fmt.Errorf("certificate %X missing from %s", sha256.Sum256(cert.Raw), extension)
+		}
+	}
+	return nil
+}
+
+// compareMetadataCertificates ensures that the certificates listed in the metadata are exactly those in the PEM bundle
+func compareMetadataCertificates(metadataCertificates []CertificateMetadata, pemCertificates [][]byte) error {
+	metadataMap := map[string]bool{}
+	for _, cert := range metadataCertificates {
+		metadataMap[strings.ToUpper(cert.SHA256)] = true
+	}
+	if len(metadataMap) != len(pemCertificates) {
+		return fmt.Errorf("metadata lists %d certificates but pem contains %d", len(metadataMap), len(pemCertificates))
+	}
+	for _, pemCert := range pemCertificates {
+		block, _ := pem.Decode(pemCert)
+		if block == nil {
+			return fmt.Errorf("invalid pem data")
+		}
+		sha := fmt.Sprintf("%X", sha256.Sum256(block.Bytes))
+		if !metadataMap[sha] {
+			return fmt.Errorf("certificate %s missing from metadata", sha)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package rootca
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testBundleDir writes a signed bundle directory containing a single vendor with one certificate. Version 1 and version
+// 2 metadata are written as requested.
+func testBundleDir(t *testing.T, vendorID string, v1, v2 bool) (string, []byte) {
+	dir := t.TempDir()
+	signingKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %s", err.Error())
+	}
+	publicKeyDER, err := x509.MarshalPKIXPublicKey(&signingKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Error marshaling public key: %s", err.Error())
+	}
+	publicKey := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyDER})
+
+	writeSigned := func(name string, data []byte) {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatalf("Error writing %s: %s", name, err.Error())
+		}
+		digest := sha256.Sum256(data)
+		signature, err := ecdsa.SignASN1(rand.Reader, signingKey, digest[:])
+		if err != nil {
+			t.Fatalf("Error signing %s: %s", name, err.Error())
+		}
+		if err := os.WriteFile(filepath.Join(dir, name+SignatureExtension), signature, 0644); err != nil {
+			t.Fatalf("Error writing %s signature: %s", name, err.Error())
+		}
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &signingKey.PublicKey, signingKey)
+	if err != nil {
+		t.Fatalf("Error creating certificate: %s", err.Error())
+	}
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	writeSigned(vendorID+"_bundle.pem", pemData)
+
+	certificate, err := NewCertificateMetadata(der)
+	if err != nil {
+		t.Fatalf("Error describing certificate: %s", err.Error())
+	}
+	metadata := BundleMetadata{
+		vendorID: {
+			Date:         "2024-10-01T00:00:00Z",
+			Key:          "key",
+			Bundles:      map[string]BundleFingerprint{vendorID + "_bundle.pem": Fingerprint(pemData)},
+			NumCerts:     1,
+			Certificates: []CertificateMetadata{certificate},
+		},
+	}
+	if v1 {
+		data, err := MarshalMetadata(metadata, 1)
+		if err != nil {
+			t.Fatalf("Error marshaling metadata: %s", err.Error())
+		}
+		writeSigned(MetadataFileName, data)
+	}
+	if v2 {
+		data, err := MarshalMetadata(metadata, MetadataSchemaVersion)
+		if err != nil {
+			t.Fatalf("Error marshaling metadata: %s", err.Error())
+		}
+		writeSigned(MetadataV2FileName, data)
+	}
+
+	return dir, publicKey
+}
+
+func TestLoadV2(t *testing.T) {
+	dir, publicKey := testBundleDir(t, "mozilla", true, true)
+	bundles, err := LoadDir(dir, &Options{PublicKey: publicKey})
+	if err != nil {
+		t.Fatalf("Error loading bundles: %s", err.Error())
+	}
+	if bundles.SchemaVersion != MetadataSchemaVersion {
+		t.Errorf("Expected schema version %d, got %d", MetadataSchemaVersion, bundles.SchemaVersion)
+	}
+	if _, err := bundles.CertPool("mozilla"); err != nil {
+		t.Errorf("Error getting cert pool: %s", err.Error())
+	}
+}
+
+func TestCertPoolDistrustVendor(t *testing.T) {
+	dir, publicKey := testBundleDir(t, "microsoft_disallowed", false, true)
+	bundles, err := LoadDir(dir, &Options{PublicKey: publicKey})
+	if err != nil {
+		t.Fatalf("Error loading bundles: %s", err.Error())
+	}
+	if len(bundles.Vendors["microsoft_disallowed"].Certificates) != 1 {
+		t.Errorf("Expected 1 certificate")
+	}
+	if _, err := bundles.CertPool("microsoft_disallowed"); err == nil {
+		t.Errorf("No error seen for distrust vendor")
+	}
+}
+
+func TestLoadFallbackV1(t *testing.T) {
+	dir, publicKey := testBundleDir(t, "mozilla", true, false)
+	bundles, err := LoadDir(dir, &Options{PublicKey: publicKey})
+	if err != nil {
+		t.Fatalf("Error loading bundles: %s", err.Error())
+	}
+	if bundles.SchemaVersion != 1 {
+		t.Errorf("Expected schema version 1, got %d", bundles.SchemaVersion)
+	}
+}
+
+func TestLoadTamperedV2(t *testing.T) {
+	dir, publicKey := testBundleDir(t, "mozilla", true, true)
+	metadataPath := filepath.Join(dir, MetadataV2FileName)
+	data, err := os.ReadFile(metadataPath)
+	if err != nil {
+		t.Fatalf("Error reading metadata: %s", err.Error())
+	}
+	if err := os.WriteFile(metadataPath, append(data, ' '), 0644); err != nil {
+		t.Fatalf("Error writing metadata: %s", err.Error())
+	}
+
+	_, err = LoadDir(dir, &Options{PublicKey: publicKey})
+	if err == nil {
+		t.Fatalf("No error seen for tampered version 2 metadata")
+	}
+	if !strings.Contains(err.Error(), MetadataV2FileName) {
+		t.Errorf("Unexpected error: %s", err.Error())
+	}
+}
+
+func TestLoadMissingV2Signature(t *testing.T) {
+	dir, publicKey := testBundleDir(t, "mozilla", true, true)
+	if err := os.Remove(filepath.Join(dir, MetadataV2FileName+SignatureExtension)); err != nil {
+		t.Fatalf("Error removing signature: %s", err.Error())
+	}
+	if _, err := LoadDir(dir, &Options{PublicKey: publicKey}); err == nil {
+		t.Errorf("No error seen for missing version 2 metadata signature")
+	}
+}
@@ -0,0 +1,337 @@
+// Package rootca provides an interface to read and verify the certificate bundles published by the rootca updater.
+// Bundles can be loaded from a local directory or from a published release, and are verified using the signing key,
+// signatures, and fingerprints included with them.
+package rootca
+
+import (
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// MetadataFileName is the name of the version 1 metadata file describing all bundles
+const MetadataFileName = "bundle_metadata.json"
+
+// MetadataV2FileName is the name of the version 2 metadata file describing all bundles
+const MetadataV2FileName = "bundle_metadata_v2.json"
+
+// MetadataSchemaVersion is the most recent version of the metadata schema
+const MetadataSchemaVersion = 2
+
+// SigningKeyFileName is the name of the PEM-encoded public key used to sign the bundles
+const SigningKeyFileName = "signing_key.pem"
+
+// TruststorePassword is the password of the JKS and PKCS#12 truststore of each bundle. The truststores only contain
+// public certificates, so the password only protects their integrity and is the same as the default password of the
+// Java cacerts truststore.
+const TruststorePassword = "changeit"
+
+// DistrustVendorIDs are the IDs of vendors whose bundle contains distrusted certificates rather than trusted roots.
+// These bundles must never be used as a trust store, so they are published without truststores and have no
+// certificate pool.
+var DistrustVendorIDs = []string{"microsoft_disallowed"}
+
+// IsDistrustVendor returns true if the bundle of the given vendor contains distrusted certificates
+func IsDistrustVendor(vendorID string) bool {
+	for _, id := range DistrustVendorIDs {
+		if id == vendorID {
+			return true
+		}
+	}
+	return false
+}
+
+// SignatureExtension is appended to the name of a file to get the name of its signature file
+const SignatureExtension = ".sig"
+
+// BundleMetadata maps the ID of each vendor to the metadata of its bundle
+type BundleMetadata map[string]VendorMetadata
+
+// VendorMetadata describes the bundle of a single vendor
+type VendorMetadata struct {
+	// The date of when the vendor last modified their certificates, in RFC 3339 format.
+	Date string `json:"date"`
+	// Key is internal to the updater and should be ignored.
+	Key string `json:"key"`
+	// The fingerprints of each bundle file for this vendor, keyed by the file name.
+	Bundles map[string]BundleFingerprint `json:"bundles"`
+	// The number of certificates in the bundle.
+	NumCerts int `json:"num_certs"`
+	// The upstream version of the certificates, for vendors that publish one. Only present in version 2 metadata.
+	Version string `json:"version,omitempty"`
+	// The product releases that shipped this version of the certificates, such as "iOS 17.4", if known. Only present
+	// in version 2 metadata.
+	Releases []string `json:"releases,omitempty"`
+	// Every certificate in the bundle, sorted by SHA-256 fingerprint. Only present in version 2 metadata.
+	Certificates []CertificateMetadata `json:"certificates,omitempty"`
+}
+
+// CertificateMetadata describes a single certificate in a bundle
+type CertificateMetadata struct {
+	// The uppercase hex SHA-256 fingerprint of the certificate
+	SHA256 string `json:"sha256"`
+	// The uppercase hex SHA-256 fingerprint of the certificates subject public key info
+	SPKISHA256 string `json:"spki_sha256"`
+	// The subject of the certificate
+	Subject string `json:"subject"`
+	// Trust attributes of the certificate, only present for vendors that publish them
+	Trust *CertificateTrust `json:"trust,omitempty"`
+	// Attributes from the Microsoft trust list, only present for Microsoft certificates
+	Microsoft *MicrosoftAttributes `json:"microsoft,omitempty"`
+	// Attributes from the Chrome Root Store, only present for Google certificates
+	Chrome *ChromeAttributes `json:"chrome,omitempty"`
+	// Attributes from Apple's trust metadata, only present for Apple certificates
+	Apple *AppleAttributes `json:"apple,omitempty"`
+	// Attributes from the EU trusted lists, only present for EU QWAC certificates
+	EU *EUTrustedListAttributes `json:"eu,omitempty"`
+	// The certificates identity across the inputs of a derived bundle, only present for derived bundles
+	Identity *IdentityAttributes `json:"identity,omitempty"`
+}
+
+// IdentityAttributes describes a certificate in a derived bundle and the other certificates in its inputs that share
+// its subject and public key
+type IdentityAttributes struct {
+	// The IDs of the input vendors that contain this certificate or any of its variants
+	Vendors []string `json:"vendors"`
+	// Other certificates in the inputs with the same subject and public key, such as a root that was re-issued with a
+	// different validity period. If present, the inputs disagree on which certificate to use for this root.
+	Variants []CertificateVariant `json:"variants,omitempty"`
+}
+
+// CertificateVariant is a certificate in the inputs of a derived bundle that was not selected for the bundle
+type CertificateVariant struct {
+	// The uppercase hex SHA-256 fingerprint of the certificate
+	SHA256 string `json:"sha256"`
+	// The IDs of the input vendors that contain this certificate
+	Vendors []string `json:"vendors"`
+}
+
+// EUTrustedListAttributes describes a certificate in the EU trusted lists
+type EUTrustedListAttributes struct {
+	// The qualified trust services that the certificate identifies
+	Services []EUTrustService `json:"services"`
+}
+
+// EUTrustService is a trust service in a member state trusted list
+type EUTrustService struct {
+	// The scheme territory of the trusted list, such as DE
+	Country string `json:"country"`
+	// The name of the trust service provider
+	Provider string `json:"provider"`
+	// The name of the service
+	Name string `json:"name"`
+	// The current status of the service, such as granted or withdrawn
+	Status string `json:"status"`
+	// When the current status took effect, in RFC 3339 format
+	StatusStartingTime string `json:"status_starting_time"`
+	// Previous statuses of the service, most recent first
+	History []EUTrustServiceStatus `json:"history,omitempty"`
+}
+
+// EUTrustServiceStatus is a previous status of a trust service
+type EUTrustServiceStatus struct {
+	// The status of the service, such as granted or withdrawn
+	Status string `json:"status"`
+	// When the status took effect, in RFC 3339 format
+	StatusStartingTime string `json:"status_starting_time"`
+}
+
+// AppleAttributes describes a root certificate in Apple's trust store
+type AppleAttributes struct {
+	// The policy OIDs for which the certificate is trusted to issue extended validation certificates
+	EVPolicies []string `json:"ev_policies,omitempty"`
+	// If the certificate is only trusted for an allowlist of leaf certificates
+	Allowlisted bool `json:"allowlisted,omitempty"`
+	// If the certificate is only trusted within the name constraints from Apple's constrained certificates
+	Constrained bool `json:"constrained,omitempty"`
+	// The DNS names that a constrained certificate is permitted to issue for
+	PermittedDNSNames []string `json:"permitted_dns_names,omitempty"`
+	// The DNS names that a constrained certificate is not permitted to issue for
+	ExcludedDNSNames []string `json:"excluded_dns_names,omitempty"`
+}
+
+// ChromeAttributes describes a trust anchor in the Chrome Root Store
+type ChromeAttributes struct {
+	// The policy OIDs for which the certificate is trusted to issue extended validation certificates
+	EVPolicies []string `json:"ev_policies,omitempty"`
+	// The constraints on the trust anchor. The anchor is trusted if any one of the constraints is satisfied, or
+	// unconditionally if there are none.
+	Constraints []ChromeConstraint `json:"constraints,omitempty"`
+}
+
+// ChromeConstraint is a set of conditions that must all be satisfied for Chrome to trust a certificate chain
+type ChromeConstraint struct {
+	// Leaf certificates must have no embedded SCT dated after this date
+	SCTNotAfter string `json:"sct_not_after,omitempty"`
+	// Leaf certificates must have every embedded SCT dated after this date
+	SCTAllAfter string `json:"sct_all_after,omitempty"`
+	// The minimum version of Chrome that trusts the anchor
+	MinVersion string `json:"min_version,omitempty"`
+	// The first version of Chrome that no longer trusts the anchor
+	MaxVersionExclusive string `json:"max_version_exclusive,omitempty"`
+	// Leaf certificates must have a DNS name within one of these names
+	PermittedDNSNames []string `json:"permitted_dns_names,omitempty"`
+}
+
+// MicrosoftAttributes describes a certificate in the Microsoft Trusted Root Program
+type MicrosoftAttributes struct {
+	// The name Microsoft uses for the certificate
+	FriendlyName string `json:"friendly_name"`
+	// The extended key usage OIDs the certificate is trusted for
+	EKUs []string `json:"ekus"`
+	// Certificates issued by this certificate with a NotBefore date after this date are not trusted for the
+	// NotBeforeEKUs, or for any usage if there are no NotBeforeEKUs
+	NotBefore string `json:"not_before,omitempty"`
+	// The extended key usage OIDs restricted by NotBefore
+	NotBeforeEKUs []string `json:"not_before_ekus,omitempty"`
+	// The certificate policy OIDs for which the certificate is trusted to issue extended validation certificates
+	EVPolicies []string `json:"ev_policies,omitempty"`
+}
+
+// Trust levels used in CertificateTrust
+const (
+	TrustTrusted    = "trusted"
+	TrustMustVerify = "must_verify"
+	TrustDistrusted = "distrusted"
+)
+
+// CertificateTrust describes the purposes a certificate is trusted for by a vendor
+type CertificateTrust struct {
+	// The trust level for server authentication, one of TrustTrusted, TrustMustVerify, or TrustDistrusted
+	ServerAuth string `json:"server_auth,omitempty"`
+	// The trust level for email protection
+	EmailProtection string `json:"email_protection,omitempty"`
+	// The trust level for code signing
+	CodeSigning string `json:"code_signing,omitempty"`
+	// Certificates issued by this certificate with a NotBefore date after this date are not trusted for server
+	// authentication
+	ServerDistrustAfter string `json:"server_distrust_after,omitempty"`
+	// Certificates issued by this certificate with a NotBefore date after this date are not trusted for email
+	// protection
+	EmailDistrustAfter string `json:"email_distrust_after,omitempty"`
+}
+
+// ParseDate will parse the date of the vendor metadata
+func (m VendorMetadata) ParseDate() (time.Time, error) {
+	return time.Parse("2006-01-02T15:04:05Z07:00", m.Date)
+}
+
+// MustDate will parse the date of the vendor metadata, panicing if the date is invalid
+func (m VendorMetadata) MustDate() time.Time {
+	d, err := m.ParseDate()
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// BundleFingerprint contains the uppercase hex-encoded checksums of a bundle file
+type BundleFingerprint struct {
+	SHA1   string `json:"sha1"`
+	SHA256 string `json:"sha256"`
+	SHA512 string `json:"sha512"`
+}
+
+// metadataV2 is the top level structure of version 2 metadata
+type metadataV2 struct {
+	SchemaVersion int            `json:"schema_version"`
+	Vendors       BundleMetadata `json:"vendors"`
+}
+
+// ParseMetadata will parse the given bundle metadata JSON data. Both version 1 and version 2 metadata are supported,
+// and the schema version of the data is returned. Version 1 metadata does not include any certificates.
+func ParseMetadata(data []byte) (BundleMetadata, int, error) {
+	var header struct {
+		SchemaVersion int `json:"schema_version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, 0, err
+	}
+
+	switch header.SchemaVersion {
+	case 0:
+		// Version 1 metadata has no schema version
+		metadata := BundleMetadata{}
+		if err := json.Unmarshal(data, &metadata); err != nil {
+			return nil, 0, err
+		}
+		return metadata, 1, nil
+	case 2:
+		metadata := metadataV2{}
+		if err := json.Unmarshal(data, &metadata); err != nil {
+			return nil, 0, err
+		}
+		if metadata.Vendors == nil {
+			metadata.Vendors = BundleMetadata{}
+		}
+		return metadata.Vendors, 2, nil
+	}
+
+	return nil, 0, fmt.Errorf("unsupported metadata schema version %d", header.SchemaVersion)
+}
+
+// MarshalMetadata will encode the metadata as JSON using the given schema version. Certificates are omitted from
+// version 1 metadata.
+func MarshalMetadata(metadata BundleMetadata, schemaVersion int) ([]byte, error) {
+	var v any
+	switch schemaVersion {
+	case 1:
+		v1 := BundleMetadata{}
+		for id, vendor := range metadata {
+			vendor.Certificates = nil
+			vendor.Version = ""
+			vendor.Releases = nil
+			v1[id] = vendor
+		}
+		v = v1
+	case 2:
+		v = metadataV2{
+			SchemaVersion: 2,
+			Vendors:       metadata,
+		}
+	default:
+		return nil, fmt.Errorf("unsupported metadata schema version %d", schemaVersion)
+	}
+
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// NewCertificateMetadata will describe the given DER-encoded certificate. Only the subject and public key are parsed
+// from the certificate, so this will succeed for certificates that are otherwise rejected by crypto/x509.
+func NewCertificateMetadata(der []byte) (CertificateMetadata, error) {
+	var certificate struct {
+		TBSCertificate struct {
+			Raw                asn1.RawContent
+			Version            int `asn1:"optional,explicit,default:0,tag:0"`
+			SerialNumber       asn1.RawValue
+			SignatureAlgorithm asn1.RawValue
+			Issuer             asn1.RawValue
+			Validity           asn1.RawValue
+			Subject            asn1.RawValue
+			PublicKey          asn1.RawValue
+		}
+		SignatureAlgorithm asn1.RawValue
+		Signature          asn1.RawValue
+	}
+	if _, err := asn1.Unmarshal(der, &certificate); err != nil {
+		return CertificateMetadata{}, fmt.Errorf("invalid certificate: %s", err.Error())
+	}
+
+	var subject pkix.RDNSequence
+	if _, err := asn1.Unmarshal(certificate.TBSCertificate.Subject.FullBytes, &subject); err != nil {
+		return CertificateMetadata{}, fmt.Errorf("invalid certificate subject: %s", err.Error())
+	}
+
+	return CertificateMetadata{
+		SHA256:     fmt.Sprintf("%X", sha256.Sum256(der)),
+		SPKISHA256: fmt.Sprintf("%X", sha256.Sum256(certificate.TBSCertificate.PublicKey.FullBytes)),
+		Subject:    subject.String(),
+	}, nil
+}
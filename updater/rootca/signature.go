@@ -0,0 +1,36 @@
+package rootca
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+)
+
+// ParsePublicKey will parse the given PEM-encoded ECDSA public key
+func ParsePublicKey(pemData []byte) (*ecdsa.PublicKey, error) {
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return nil, fmt.Errorf("invalid pem data")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	ecKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported public key type %T", key)
+	}
+	return ecKey, nil
+}
+
+// VerifySignature will verify that signature is a valid ASN.1 ECDSA signature of the SHA-256 digest of data. This
+// is the same format produced by `openssl dgst -sha256 -sign`.
+func VerifySignature(publicKey *ecdsa.PublicKey, data, signature []byte) error {
+	digest := sha256.Sum256(data)
+	if !ecdsa.VerifyASN1(publicKey, digest[:], signature) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package rootca
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Source provides the files of a set of published bundles
+type Source interface {
+	// ReadFile will return the contents of the file with the given name. If the file does not exist, the error must
+	// wrap fs.ErrNotExist.
+	ReadFile(name string) ([]byte, error)
+}
+
+// DirSource returns a source reading files from the given directory
+func DirSource(dir string) Source {
+	return dirSource(dir)
+}
+
+type dirSource string
+
+func (d dirSource) ReadFile(name string) ([]byte, error) {
+	if strings.ContainsAny(name, `/\`) {
+		return nil, fmt.Errorf("invalid file name %s", name)
+	}
+	return os.ReadFile(filepath.Join(string(d), name))
+}
+
+// DefaultReleaseURL is the base URL for downloading assets of published releases
+const DefaultReleaseURL = "https://github.com/tls-inspector/rootca/releases"
+
+// ReleaseSource returns a source downloading the assets of the given release from GitHub. Release is the name of the
+// release, such as "bundle_20241001", or "latest" for the most recent release.
+func ReleaseSource(release string) *HTTPSource {
+	baseURL := DefaultReleaseURL + "/download/" + release
+	if release == "latest" {
+		baseURL = DefaultReleaseURL + "/latest/download"
+	}
+	return &HTTPSource{BaseURL: baseURL}
+}
+
+// HTTPSource is a source that downloads files relative to a base URL
+type HTTPSource struct {
+	// The URL that the file name is appended to
+	BaseURL string
+	// Optional HTTP client to use. If nil, http.DefaultClient is used.
+	Client *http.Client
+	// Optional User-Agent to send with requests
+	UserAgent string
+}
+
+func (s *HTTPSource) ReadFile(name string) ([]byte, error) {
+	if strings.ContainsAny(name, `/\`) {
+		return nil, fmt.Errorf("invalid file name %s", name)
+	}
+
+	req, err := http.NewRequest("GET", strings.TrimSuffix(s.BaseURL, "/")+"/"+name, nil)
+	if err != nil {
+		return nil, err
+	}
+	userAgent := s.UserAgent
+	if userAgent == "" {
+		userAgent = "rootca (github.com/tlsinspector/rootca)"
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("%s: http error %d", name, resp.StatusCode)
+	}
+
+	return io.ReadAll(resp.Body)
+}
@@ -1,68 +1,109 @@
 package main
 
 import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
+	"path"
+
+	"github.com/tls-inspector/rootca/updater/rootca"
 )
 
-func signBundle(bundleName string) error {
-	if err := signFile(bundleName + ".p7b"); err != nil {
+var signingPrivateKey *ecdsa.PrivateKey
+var signingPublicKey *ecdsa.PublicKey
+
+// loadSigningKeys will parse the signing key pair, if one was provided, and ensure that both keys belong together
+func loadSigningKeys() error {
+	if len(publicKeyBytes) == 0 || len(privateKeyBytes) == 0 {
 		return nil
 	}
-	if err := signFile(bundleName + ".pem"); err != nil {
-		return nil
+
+	publicKey, err := rootca.ParsePublicKey(publicKeyBytes)
+	if err != nil {
+		return fmt.Errorf("public key: %s", err.Error())
+	}
+
+	block, _ := pem.Decode(privateKeyBytes)
+	if block == nil {
+		return fmt.Errorf("private key: invalid pem data")
+	}
+	var privateKey *ecdsa.PrivateKey
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		privateKey, err = x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("private key: %s", err.Error())
+		}
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("private key: %s", err.Error())
+		}
+		ecKey, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return fmt.Errorf("private key: unsupported key type %T", key)
+		}
+		privateKey = ecKey
+	default:
+		return fmt.Errorf("private key: unsupported pem type %s", block.Type)
+	}
+
+	if !privateKey.PublicKey.Equal(publicKey) {
+		return fmt.Errorf("public key does not match private key")
+	}
+
+	signingPrivateKey = privateKey
+	signingPublicKey = publicKey
+	return nil
+}
+
+func signBundle(bundleDir string, vendor Vendor) error {
+	for _, extension := range vendorBundleExtensions(vendor) {
+		if err := signFile(path.Join(bundleDir, vendor.BundleName()+extension)); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+// signFile will create a signature of the file, if signing is enabled. The signature is a DER-encoded ECDSA signature
+// of the SHA-256 digest of the file, which is the same format used by `openssl dgst -sha256 -sign`.
 func signFile(filePath string) error {
 	if _, err := os.Stat(filePath); err != nil {
 		return fmt.Errorf("signFile: %s", err.Error())
 	}
 
-	if publicKeyBytes == nil || privateKeyBytes == nil {
+	if signingPrivateKey == nil || signingPublicKey == nil {
 		return nil
 	}
 
-	privKeyPath, err := writeTemp(privateKeyBytes)
-	if err != nil {
-		return err
-	}
-	pubKeyPath, err := writeTemp(publicKeyBytes)
-	if err != nil {
-		return err
-	}
-
-	defer os.Remove(privKeyPath)
-	defer os.Remove(pubKeyPath)
-
 	signaturePath := filePath + ".sig"
 
-	if verifyFileSignature(filePath, signaturePath, pubKeyPath) == nil {
+	if verifyFileSignature(filePath, signaturePath) == nil {
 		log.Printf("%s signatuture OK", filePath)
 		return nil
 	}
 	os.Remove(signaturePath)
 
-	signArgs := []string{
-		"dgst",
-		"-sha256",
-		"-sign",
-		privKeyPath,
-		"-out",
-		signaturePath,
-		filePath,
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("signFile: %s", err.Error())
 	}
-
-	cmd := exec.Command(opensslPath, signArgs...)
-	output, err := cmd.CombinedOutput()
+	digest := sha256.Sum256(data)
+	signature, err := ecdsa.SignASN1(rand.Reader, signingPrivateKey, digest[:])
 	if err != nil {
-		return fmt.Errorf("openssl error: %s", output)
+		return fmt.Errorf("signFile: %s", err.Error())
+	}
+	if err := os.WriteFile(signaturePath, signature, 0644); err != nil {
+		return fmt.Errorf("signFile: %s", err.Error())
 	}
 
-	if err := verifyFileSignature(filePath, signaturePath, pubKeyPath); err != nil {
+	if err := verifyFileSignature(filePath, signaturePath); err != nil {
 		os.Remove(signaturePath)
 		return fmt.Errorf("signature validation failed after signing: %s", err.Error())
 	}
@@ -71,19 +112,14 @@ func signFile(filePath string) error {
 	return nil
 }
 
-func verifyFileSignature(filePath, signaturePath, pubKeyPath string) error {
-	verifyArgs := []string{
-		"dgst",
-		"-sha256",
-		"-verify",
-		pubKeyPath,
-		"-signature",
-		signaturePath,
-		filePath,
+func verifyFileSignature(filePath, signaturePath string) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
 	}
-	output, err := exec.Command(opensslPath, verifyArgs...).CombinedOutput()
+	signature, err := os.ReadFile(signaturePath)
 	if err != nil {
-		return fmt.Errorf("%s", output)
+		return err
 	}
-	return nil
+	return rootca.VerifySignature(signingPublicKey, data, signature)
 }
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteSignedFilesRemovesStaleSignature(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "bundle.pem")
+	if err := os.WriteFile(fileName+".sig", []byte("stale"), 0644); err != nil {
+		t.Fatalf("Error writing signature: %s", err.Error())
+	}
+
+	signingPrivateKey, signingPublicKey = nil, nil
+	if err := writeSignedFiles(map[string][]byte{fileName: []byte("data")}); err != nil {
+		t.Fatalf("Error writing files: %s", err.Error())
+	}
+
+	if _, err := os.Stat(fileName + ".sig"); !os.IsNotExist(err) {
+		t.Errorf("Stale signature was not removed")
+	}
+	data, err := os.ReadFile(fileName)
+	if err != nil || string(data) != "data" {
+		t.Errorf("File was not written")
+	}
+}
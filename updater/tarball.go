@@ -0,0 +1,112 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// tarballLimits restrict how much data may be extracted from a tarball
+type tarballLimits struct {
+	// The maximum size of a single extracted file
+	MaxEntrySize int64
+	// The maximum combined size of all extracted files
+	MaxTotalSize int64
+}
+
+// extractTarball will extract all regular files at or beneath any of the given paths from the gzip compressed tarball
+// read from r into outputDir, preserving their path within the archive. The first stripComponents path components of
+// every entry are stripped, such as the single top-level directory of tarballs from GitHub. Entries outside of paths
+// are skipped without being written. An error is returned if the tarball contains absolute paths, paths that traverse
+// outside of the archive, links within paths, or if any limit is exceeded.
+func extractTarball(r io.Reader, paths []string, stripComponents int, outputDir string, limits tarballLimits) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("gzip: %s", err.Error())
+	}
+	defer gz.Close()
+
+	cleanPaths := make([]string, len(paths))
+	for i, p := range paths {
+		cleanPaths[i] = strings.Trim(path.Clean(p), "/")
+	}
+	isExtracted := func(name string) bool {
+		for _, p := range cleanPaths {
+			if name == p || strings.HasPrefix(name, p+"/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	var totalSize int64
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("tar: %s", err.Error())
+		}
+
+		name := header.Name
+		if strings.HasPrefix(name, "/") || strings.Contains(name, `\`) {
+			return fmt.Errorf("tar: illegal path %s", name)
+		}
+		for _, component := range strings.Split(name, "/") {
+			if component == ".." {
+				return fmt.Errorf("tar: illegal path %s", name)
+			}
+		}
+
+		parts := strings.SplitN(path.Clean(name), "/", stripComponents+1)
+		if len(parts) != stripComponents+1 {
+			continue
+		}
+		name = parts[stripComponents]
+		if !isExtracted(name) {
+			continue
+		}
+
+		if header.Typeflag == tar.TypeDir {
+			continue
+		}
+		if header.Typeflag != tar.TypeReg {
+			return fmt.Errorf("tar: unsupported entry type %c for %s", header.Typeflag, name)
+		}
+
+		if header.Size > limits.MaxEntrySize {
+			return fmt.Errorf("tar: entry %s exceeds maximum size", name)
+		}
+		totalSize += header.Size
+		if totalSize > limits.MaxTotalSize {
+			return fmt.Errorf("tar: archive exceeds maximum extracted size")
+		}
+
+		outputPath := filepath.Join(outputDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(outputPath), os.ModePerm); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(outputPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		n, err := io.Copy(f, io.LimitReader(tr, header.Size+1))
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("tar: %s", err.Error())
+		}
+		if n != header.Size {
+			return fmt.Errorf("tar: entry %s size mismatch", name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type testTarEntry struct {
+	name     string
+	typeflag byte
+	data     string
+	size     int64
+}
+
+func buildTarball(t *testing.T, entries []testTarEntry) []byte {
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+	for _, entry := range entries {
+		typeflag := entry.typeflag
+		if typeflag == 0 {
+			typeflag = tar.TypeReg
+		}
+		header := &tar.Header{Name: entry.name, Typeflag: typeflag, Mode: 0644}
+		if typeflag == tar.TypeReg {
+			header.Size = int64(len(entry.data))
+		}
+		if typeflag == tar.TypeSymlink {
+			header.Linkname = "/etc/passwd"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatalf("Error writing tar header: %s", err.Error())
+		}
+		if typeflag == tar.TypeReg {
+			tw.Write([]byte(entry.data))
+		}
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+var testTarballLimits = tarballLimits{MaxEntrySize: 1024, MaxTotalSize: 2048}
+
+func TestExtractTarball(t *testing.T) {
+	tarball := buildTarball(t, []testTarEntry{
+		{name: "repo-abc123/", typeflag: tar.TypeDir},
+		{name: "repo-abc123/certificates/roots/a.cer", data: "a"},
+		{name: "repo-abc123/certificates/roots/nested/b.cer", data: "b"},
+		{name: "repo-abc123/certificates/rootsother/c.cer", data: "c"},
+		{name: "repo-abc123/README.md", data: "readme"},
+		{name: "repo-abc123/link", typeflag: tar.TypeSymlink},
+	})
+
+	outputDir := t.TempDir()
+	if err := extractTarball(bytes.NewReader(tarball), []string{"certificates/roots/"}, 1, outputDir, testTarballLimits); err != nil {
+		t.Fatalf("Error extracting tarball: %s", err.Error())
+	}
+
+	for name, expected := range map[string]string{
+		"certificates/roots/a.cer":        "a",
+		"certificates/roots/nested/b.cer": "b",
+	} {
+		data, err := os.ReadFile(filepath.Join(outputDir, name))
+		if err != nil {
+			t.Fatalf("Error reading %s: %s", name, err.Error())
+		}
+		if string(data) != expected {
+			t.Errorf("Incorrect contents of %s", name)
+		}
+	}
+	for _, name := range []string{"certificates/rootsother/c.cer", "README.md", "link"} {
+		if _, err := os.Stat(filepath.Join(outputDir, name)); err == nil {
+			t.Errorf("Unexpected file %s extracted", name)
+		}
+	}
+}
+
+func TestExtractTarballNoStrip(t *testing.T) {
+	tarball := buildTarball(t, []testTarEntry{
+		{name: "files/a.pem", data: "a"},
+		{name: "removed/b.pem", data: "b"},
+	})
+
+	outputDir := t.TempDir()
+	if err := extractTarball(bytes.NewReader(tarball), []string{"files", "removed"}, 0, outputDir, testTarballLimits); err != nil {
+		t.Fatalf("Error extracting tarball: %s", err.Error())
+	}
+	for _, name := range []string{"files/a.pem", "removed/b.pem"} {
+		if _, err := os.Stat(filepath.Join(outputDir, name)); err != nil {
+			t.Errorf("Expected file %s not extracted", name)
+		}
+	}
+}
+
+func TestExtractTarballErrors(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		entries []testTarEntry
+		err     string
+	}{
+		{
+			name:    "absolute path",
+			entries: []testTarEntry{{name: "/certificates/roots/a.cer", data: "a"}},
+			err:     "illegal path",
+		},
+		{
+			name:    "traversal",
+			entries: []testTarEntry{{name: "repo/certificates/roots/../../../a.cer", data: "a"}},
+			err:     "illegal path",
+		},
+		{
+			name:    "backslash",
+			entries: []testTarEntry{{name: `repo\certificates\roots\a.cer`, data: "a"}},
+			err:     "illegal path",
+		},
+		{
+			name:    "link within paths",
+			entries: []testTarEntry{{name: "repo/certificates/roots/a.cer", typeflag: tar.TypeSymlink}},
+			err:     "unsupported entry type",
+		},
+		{
+			name:    "entry size",
+			entries: []testTarEntry{{name: "repo/certificates/roots/a.cer", data: strings.Repeat("a", 1025)}},
+			err:     "exceeds maximum size",
+		},
+		{
+			name: "total size",
+			entries: []testTarEntry{
+				{name: "repo/certificates/roots/a.cer", data: strings.Repeat("a", 1000)},
+				{name: "repo/certificates/roots/b.cer", data: strings.Repeat("b", 1000)},
+				{name: "repo/certificates/roots/c.cer", data: strings.Repeat("c", 1000)},
+			},
+			err: "exceeds maximum extracted size",
+		},
+		{
+			name: "duplicate entry",
+			entries: []testTarEntry{
+				{name: "repo/certificates/roots/a.cer", data: "a"},
+				{name: "repo/certificates/roots/a.cer", data: "b"},
+			},
+			err: "exists",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := extractTarball(bytes.NewReader(buildTarball(t, test.entries)), []string{"certificates/roots"}, 1, t.TempDir(), testTarballLimits)
+			if err == nil {
+				t.Fatalf("No error seen")
+			}
+			if !strings.Contains(err.Error(), test.err) {
+				t.Errorf("Unexpected error: %s", err.Error())
+			}
+		})
+	}
+}
+
+func TestExtractTarballNotGzip(t *testing.T) {
+	if err := extractTarball(strings.NewReader("not a tarball"), []string{"a"}, 0, t.TempDir(), testTarballLimits); err == nil {
+		t.Errorf("No error seen for invalid gzip data")
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// textprotoField is a single field of a message in the protocol buffer text format. Either Value or Message is set.
+type textprotoField struct {
+	Name string
+	// The unquoted value of a scalar field
+	Value   string
+	Message *textprotoMessage
+}
+
+// textprotoMessage is a message in the protocol buffer text format. Fields are kept in the order they appear, and
+// repeated fields appear once for each value.
+type textprotoMessage struct {
+	Fields []textprotoField
+}
+
+// Values returns the values of every scalar field with the given name
+func (m *textprotoMessage) Values(name string) []string {
+	values := []string{}
+	for _, field := range m.Fields {
+		if field.Name == name && field.Message == nil {
+			values = append(values, field.Value)
+		}
+	}
+	return values
+}
+
+// Value returns the value of the last scalar field with the given name, or an empty string
+func (m *textprotoMessage) Value(name string) string {
+	values := m.Values(name)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[len(values)-1]
+}
+
+// Messages returns every message field with the given name
+func (m *textprotoMessage) Messages(name string) []*textprotoMessage {
+	messages := []*textprotoMessage{}
+	for _, field := range m.Fields {
+		if field.Name == name && field.Message != nil {
+			messages = append(messages, field.Message)
+		}
+	}
+	return messages
+}
+
+// parseTextproto will parse a message in the protocol buffer text format. Only the subset of the format needed for
+// data files is supported: scalar fields, nested messages, lists, and comments. Field types are not checked.
+func parseTextproto(data string) (*textprotoMessage, error) {
+	p := &textprotoParser{data: data, line: 1}
+	message, err := p.parseMessage("")
+	if err != nil {
+		return nil, fmt.Errorf("textproto: line %d: %s", p.line, err.Error())
+	}
+	return message, nil
+}
+
+type textprotoParser struct {
+	data string
+	pos  int
+	line int
+}
+
+// skip will advance past any whitespace and comments
+func (p *textprotoParser) skip() {
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		switch {
+		case c == '\n':
+			p.line++
+			p.pos++
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case c == '#':
+			for p.pos < len(p.data) && p.data[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// peek returns the next character after any whitespace and comments, or 0 at the end of the data
+func (p *textprotoParser) peek() byte {
+	p.skip()
+	if p.pos >= len(p.data) {
+		return 0
+	}
+	return p.data[p.pos]
+}
+
+// parseMessage will parse fields until the given closing delimiter, or the end of the data if it is empty
+func (p *textprotoParser) parseMessage(end string) (*textprotoMessage, error) {
+	message := &textprotoMessage{}
+	for {
+		c := p.peek()
+		if c == 0 {
+			if end != "" {
+				return nil, fmt.Errorf("unexpected end of data, expected %s", end)
+			}
+			return message, nil
+		}
+		if end != "" && string(c) == end {
+			p.pos++
+			return message, nil
+		}
+
+		name := p.parseIdentifier()
+		if name == "" {
+			return nil, fmt.Errorf("expected field name")
+		}
+
+		hasColon := false
+		if p.peek() == ':' {
+			hasColon = true
+			p.pos++
+		}
+
+		switch c := p.peek(); {
+		case c == '{' || c == '<':
+			p.pos++
+			nested, err := p.parseMessage(map[byte]string{'{': "}", '<': ">"}[c])
+			if err != nil {
+				return nil, err
+			}
+			message.Fields = append(message.Fields, textprotoField{Name: name, Message: nested})
+		case c == '[' && hasColon:
+			p.pos++
+			for p.peek() != ']' {
+				value, err := p.parseValue()
+				if err != nil {
+					return nil, err
+				}
+				message.Fields = append(message.Fields, textprotoField{Name: name, Value: value})
+				if p.peek() == ',' {
+					p.pos++
+				} else if p.peek() != ']' {
+					return nil, fmt.Errorf("expected , or ]")
+				}
+			}
+			p.pos++
+		case hasColon:
+			value, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			message.Fields = append(message.Fields, textprotoField{Name: name, Value: value})
+		default:
+			return nil, fmt.Errorf("expected : or { after %s", name)
+		}
+
+		// Fields may optionally be separated by a comma or semicolon
+		if c := p.peek(); c == ',' || c == ';' {
+			p.pos++
+		}
+	}
+}
+
+func (p *textprotoParser) parseIdentifier() string {
+	start := p.pos
+	for p.pos < len(p.data) {
+		c := rune(p.data[p.pos])
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) && c != '_' && c != '.' && c != '-' && c != '+' {
+			break
+		}
+		p.pos++
+	}
+	return p.data[start:p.pos]
+}
+
+// parseValue will parse a scalar value. Adjacent quoted strings are concatenated.
+func (p *textprotoParser) parseValue() (string, error) {
+	c := p.peek()
+	if c != '"' && c != '\'' {
+		value := p.parseIdentifier()
+		if value == "" {
+			return "", fmt.Errorf("expected value")
+		}
+		return value, nil
+	}
+
+	value := &strings.Builder{}
+	for c := p.peek(); c == '"' || c == '\''; c = p.peek() {
+		start := p.pos
+		p.pos++
+		for p.pos < len(p.data) && p.data[p.pos] != c {
+			if p.data[p.pos] == '\\' {
+				p.pos++
+			}
+			if p.pos < len(p.data) && p.data[p.pos] == '\n' {
+				return "", fmt.Errorf("unterminated string")
+			}
+			p.pos++
+		}
+		if p.pos >= len(p.data) {
+			return "", fmt.Errorf("unterminated string")
+		}
+		p.pos++
+
+		quoted := p.data[start:p.pos]
+		if c == '\'' {
+			quoted = `"` + strings.ReplaceAll(quoted[1:len(quoted)-1], `"`, `\"`) + `"`
+		}
+		unquoted, err := strconv.Unquote(quoted)
+		if err != nil {
+			return "", fmt.Errorf("invalid string %s", quoted)
+		}
+		value.WriteString(unquoted)
+	}
+	return value.String(), nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tls-inspector/rootca/updater/rootca"
+)
+
+func TestParseTextproto(t *testing.T) {
+	message, err := parseTextproto(`# A comment
+version_major: 42
+name: "first" 'second'
+escaped: "a\"b\n"
+single: 'it"s'
+list: ["a", "b",
+  "c"]
+nested {
+  value: 1
+  inner < value: 2; >
+}
+nested: { value: 3 }, enum: SOME_VALUE
+`)
+	if err != nil {
+		t.Fatalf("Error parsing textproto: %s", err.Error())
+	}
+
+	if value := message.Value("version_major"); value != "42" {
+		t.Errorf("Unexpected version_major %s", value)
+	}
+	if value := message.Value("name"); value != "firstsecond" {
+		t.Errorf("Adjacent strings were not concatenated: %s", value)
+	}
+	if value := message.Value("escaped"); value != "a\"b\n" {
+		t.Errorf("Unexpected escaped value %q", value)
+	}
+	if value := message.Value("single"); value != `it"s` {
+		t.Errorf("Unexpected single quoted value %q", value)
+	}
+	if values := message.Values("list"); !reflect.DeepEqual(values, []string{"a", "b", "c"}) {
+		t.Errorf("Unexpected list values %v", values)
+	}
+	if value := message.Value("enum"); value != "SOME_VALUE" {
+		t.Errorf("Unexpected enum value %s", value)
+	}
+	if value := message.Value("missing"); value != "" {
+		t.Errorf("Unexpected value for missing field %s", value)
+	}
+
+	nested := message.Messages("nested")
+	if len(nested) != 2 {
+		t.Fatalf("Expected 2 nested messages, got %d", len(nested))
+	}
+	if nested[0].Value("value") != "1" || nested[1].Value("value") != "3" {
+		t.Errorf("Nested messages are not in order")
+	}
+	inner := nested[0].Messages("inner")
+	if len(inner) != 1 || inner[0].Value("value") != "2" {
+		t.Errorf("Unexpected inner message")
+	}
+	if len(message.Values("nested")) != 0 {
+		t.Errorf("Message fields returned as values")
+	}
+}
+
+func TestParseTextprotoErrors(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		data string
+		line string
+	}{
+		{name: "missing separator", data: "name \"value\"", line: "line 1"},
+		{name: "unterminated message", data: "a {\n  b: 1\n", line: "line 3"},
+		{name: "mismatched delimiter", data: "a { b: 1 >", line: "line 1"},
+		{name: "unterminated string", data: "a: 1\nb: \"value\n", line: "line 2"},
+		{name: "unterminated list", data: "a: [1, 2", line: "line 1"},
+		{name: "invalid list", data: "a: [1 2]", line: "line 1"},
+		{name: "missing value", data: "a: }", line: "line 1"},
+		{name: "missing field name", data: "{ a: 1 }", line: "line 1"},
+		{name: "invalid escape", data: `a: "\q"`, line: "line 1"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseTextproto(test.data)
+			if err == nil {
+				t.Fatalf("No error seen")
+			}
+			if !strings.Contains(err.Error(), test.line) {
+				t.Errorf("Error '%s' does not include %s", err.Error(), test.line)
+			}
+		})
+	}
+}
+
+func TestParseChromeRootStore(t *testing.T) {
+	anchorSHA := strings.Repeat("ab", 32)
+	additionalSHA := strings.Repeat("CD", 32)
+	untrustedSHA := strings.Repeat("EF", 32)
+	rootStore, err := parseChromeRootStore(`version_major: 17
+
+trust_anchors {
+  sha256_hex: "` + anchorSHA + `"
+  ev_policy_oids: "2.23.140.1.1"
+  constraints {
+    sct_not_after_sec: 1735689600
+    min_version: "120"
+    permitted_dns_names: "example.com"
+  }
+}
+
+additional_certs {
+  sha256_hex: "` + additionalSHA + `"
+  tls_trust_anchor: true
+}
+
+additional_certs {
+  sha256_hex: "` + untrustedSHA + `"
+}
+`)
+	if err != nil {
+		t.Fatalf("Error parsing root store: %s", err.Error())
+	}
+
+	if rootStore.VersionMajor != "17" {
+		t.Errorf("Unexpected version_major %s", rootStore.VersionMajor)
+	}
+	if len(rootStore.Anchors) != 3 {
+		t.Fatalf("Expected 3 anchors, got %d", len(rootStore.Anchors))
+	}
+	expected := rootca.ChromeAttributes{
+		EVPolicies: []string{"2.23.140.1.1"},
+		Constraints: []rootca.ChromeConstraint{{
+			SCTNotAfter:       "2025-01-01T00:00:00Z",
+			MinVersion:        "120",
+			PermittedDNSNames: []string{"example.com"},
+		}},
+	}
+	if anchor := rootStore.Anchors[strings.ToUpper(anchorSHA)]; !anchor.TLSTrustAnchor || !reflect.DeepEqual(anchor.Attributes, expected) {
+		t.Errorf("Unexpected anchor %+v", anchor)
+	}
+	if tlsAnchors := rootStore.tlsAnchors(); !reflect.DeepEqual(tlsAnchors, []string{strings.ToUpper(anchorSHA), additionalSHA}) {
+		t.Errorf("Unexpected TLS anchors %v", tlsAnchors)
+	}
+
+	certMap := map[string][]byte{strings.ToUpper(anchorSHA): nil, additionalSHA: nil, untrustedSHA: nil}
+	if err := rootStore.verifyCertificates(certMap); err != nil {
+		t.Errorf("Error verifying certificates: %s", err.Error())
+	}
+	delete(certMap, untrustedSHA)
+	if err := rootStore.verifyCertificates(certMap); err == nil {
+		t.Errorf("No error seen for missing certificate")
+	}
+}
+
+func TestParseChromeRootStoreErrors(t *testing.T) {
+	sha := strings.Repeat("AB", 32)
+	for _, test := range []struct {
+		name string
+		data string
+	}{
+		{name: "no version", data: `trust_anchors { sha256_hex: "` + sha + `" }`},
+		{name: "no anchors", data: `version_major: 1`},
+		{name: "invalid sha", data: `version_major: 1 trust_anchors { sha256_hex: "AB" }`},
+		{name: "duplicate", data: `version_major: 1 trust_anchors { sha256_hex: "` + sha + `" } trust_anchors { sha256_hex: "` + sha + `" }`},
+		{name: "invalid time", data: `version_major: 1 trust_anchors { sha256_hex: "` + sha + `" constraints { sct_all_after_sec: soon } }`},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := parseChromeRootStore(test.data); err == nil {
+				t.Errorf("No error seen")
+			}
+		})
+	}
+}
@@ -1,27 +1,52 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
-	"crypto/sha1"
 	"crypto/sha256"
-	"crypto/sha512"
 	"crypto/x509"
 	"encoding/hex"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"net/http"
 	"os"
-	"os/exec"
 	"path"
 	"sort"
+	"strconv"
 	"strings"
+
+	"github.com/tls-inspector/rootca/updater/jks"
+	"github.com/tls-inspector/rootca/updater/pkcs12"
+	"github.com/tls-inspector/rootca/updater/pkcs7"
+	"github.com/tls-inspector/rootca/updater/rootca"
 )
 
 var githubAccessToken = os.Getenv(envGithubAccessToken)
 
+// bundleExtensions are the extensions of every file generated for a bundle
+var bundleExtensions = []string{".p7b", ".pem", ".jks", ".p12"}
+
+// truststoreExtensions are the extensions of the truststores generated for a bundle, which are not generated for the
+// bundles of distrust vendors
+var truststoreExtensions = []string{".jks", ".p12"}
+
+// vendorBundleExtensions returns the extensions of every file generated for the bundle of the given vendor
+func vendorBundleExtensions(vendor Vendor) []string {
+	if !rootca.IsDistrustVendor(vendor.ID()) {
+		return bundleExtensions
+	}
+	extensions := []string{}
+	for _, extension := range bundleExtensions {
+		if !sliceContains(truststoreExtensions, extension) {
+			extensions = append(extensions, extension)
+		}
+	}
+	return extensions
+}
+
 func httpGetBytes(url string) ([]byte, error) {
 	r, err := httpGet(url)
 	if err != nil {
@@ -72,21 +97,22 @@ func httpGet(url string) (io.ReadCloser, error) {
 	return resp.Body, nil
 }
 
-// generateBundleFromCertificates will generate a PKCS#7 and PEM bundle from the given slice of PEM certificate files
-// returns the fingerprints of the PKCS#7 file, the PEM file, or an error
-func generateBundleFromCertificates(pemPaths []string, bundleName string) (*BundleFingerprint, *BundleFingerprint, error) {
+// generateBundleFromCertificates will generate a PKCS#7 and PEM bundle in bundleDir from the given slice of PEM
+// certificate files, and a JKS and PKCS#12 truststore if truststores is true. If truststores is false, any existing
+// truststores of the bundle are removed. Returns the fingerprints of each file keyed by file name, or an error.
+func generateBundleFromCertificates(pemPaths []string, bundleDir, bundleName string, truststores bool) (map[string]BundleFingerprint, error) {
 	// Sort the certificates by their hash
 	certFingerprintsToPath := map[string]string{}
 	certFingerprints := make([]string, len(pemPaths))
 	for i, pemPath := range pemPaths {
 		pemData, err := os.ReadFile(pemPath)
 		if err != nil {
-			return nil, nil, fmt.Errorf("pem: %s", err.Error())
+			return nil, fmt.Errorf("pem: %s", err.Error())
 		}
 
 		fingerprint, err := getCertPemSHA(pemData)
 		if err != nil {
-			return nil, nil, fmt.Errorf("pem: %s", err.Error())
+			return nil, fmt.Errorf("pem: %s", err.Error())
 		}
 		certFingerprintsToPath[fingerprint] = pemPath
 		certFingerprints[i] = fingerprint
@@ -98,67 +124,159 @@ func generateBundleFromCertificates(pemPaths []string, bundleName string) (*Bund
 		pemPaths[i] = certFingerprintsToPath[fingerprint]
 	}
 
-	args := []string{
-		"crl2pkcs7",
-		"-nocrl",
+	if len(pemPaths) == 0 {
+		return nil, fmt.Errorf("no certificates to add to bundle")
 	}
 
-	if len(pemPaths) == 0 {
-		return nil, nil, fmt.Errorf("no certificates to add to bundle")
+	derCerts := make([][]byte, len(pemPaths))
+	for i, certPath := range pemPaths {
+		pemData, err := os.ReadFile(certPath)
+		if err != nil {
+			return nil, fmt.Errorf("pem: %s", err.Error())
+		}
+		certPem, _ := pem.Decode(pemData)
+		if certPem == nil {
+			return nil, fmt.Errorf("pem: invalid certificate %s", certPath)
+		}
+		derCerts[i] = certPem.Bytes
 	}
 
-	for _, certPath := range pemPaths {
-		args = append(args, "-certfile")
-		args = append(args, certPath)
+	p7Data, err := pkcs7.EncodePEM(derCerts)
+	if err != nil {
+		return nil, err
 	}
 
-	args = append(args, "-out")
-	args = append(args, bundleName+".p7b")
+	pemData := []byte{}
+	for _, pemPath := range pemPaths {
+		certData, err := os.ReadFile(pemPath)
+		if err != nil {
+			return nil, fmt.Errorf("pem: %s", err.Error())
+		}
+		pemData = append(pemData, certData...)
+	}
 
-	os.Remove(bundleName + ".p7b")
-	cmd := exec.Command(opensslPath, args...)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return nil, nil, fmt.Errorf("openssl error: %s", output)
+	files := map[string][]byte{
+		bundleName + ".p7b": p7Data,
+		bundleName + ".pem": pemData,
+	}
+	if truststores {
+		jksData, p12Data, err := generateTruststores(derCerts)
+		if err != nil {
+			return nil, err
+		}
+		files[bundleName+".jks"] = jksData
+		files[bundleName+".p12"] = p12Data
+	}
+	filePaths := map[string][]byte{}
+	for fileName, data := range files {
+		filePaths[path.Join(bundleDir, fileName)] = data
+	}
+	if err := writeSignedFiles(filePaths); err != nil {
+		return nil, err
+	}
+	if !truststores {
+		for _, extension := range truststoreExtensions {
+			for _, fileName := range []string{bundleName + extension, bundleName + extension + ".sig"} {
+				if err := os.Remove(path.Join(bundleDir, fileName)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+					return nil, fmt.Errorf("%s: %s", fileName, err.Error())
+				}
+			}
+		}
 	}
 
-	os.Remove(bundleName + ".pem")
-	f, err := os.OpenFile(bundleName+".pem", os.O_CREATE|os.O_RDWR, 0644)
-	if err != nil {
-		return nil, nil, fmt.Errorf("pem: %s", err.Error())
+	fingerprints := map[string]BundleFingerprint{}
+	for fileName, data := range files {
+		fingerprints[fileName] = rootca.Fingerprint(data)
 	}
-	defer f.Close()
-	for _, pemPath := range pemPaths {
-		pemData, err := os.ReadFile(pemPath)
+	return fingerprints, nil
+}
+
+// generateTruststores will generate a JKS and PKCS#12 truststore containing the given DER-encoded certificates, protected
+// by rootca.TruststorePassword. The alias of each certificate is its lowercase hex SHA-256 fingerprint, and the date of
+// each JKS entry is the NotBefore date of its certificate, so that the truststores are deterministic.
+func generateTruststores(derCerts [][]byte) ([]byte, []byte, error) {
+	jksCerts := make([]jks.TrustedCertificate, len(derCerts))
+	p12Certs := make([]pkcs12.TrustedCertificate, len(derCerts))
+	for i, derCert := range derCerts {
+		cert, err := x509.ParseCertificate(derCert)
 		if err != nil {
-			return nil, nil, fmt.Errorf("pem: %s", err.Error())
+			return nil, nil, err
 		}
-		f.Write(pemData)
+		alias := fmt.Sprintf("%x", sha256.Sum256(derCert))
+		jksCerts[i] = jks.TrustedCertificate{Alias: alias, Date: cert.NotBefore, Certificate: derCert}
+		p12Certs[i] = pkcs12.TrustedCertificate{Alias: alias, Certificate: derCert}
 	}
 
-	p7Fingerprints, err := getFileFingerprints(bundleName + ".p7b")
+	jksData, err := jks.Encode(jksCerts, rootca.TruststorePassword)
 	if err != nil {
-		return nil, nil, fmt.Errorf("checksum: %s", err.Error())
+		return nil, nil, err
 	}
-	pemFingerprints, err := getFileFingerprints(bundleName + ".pem")
+	p12Data, err := pkcs12.Encode(p12Certs, rootca.TruststorePassword)
 	if err != nil {
-		return nil, nil, fmt.Errorf("checksum: %s", err.Error())
+		return nil, nil, err
+	}
+	return jksData, p12Data, nil
+}
+
+// writeSignedFiles will write and sign each of the given files. All files and their signatures are first written to a
+// temporary name and only replace the existing files once every file was written and signed, so that a failure never
+// leaves a partially updated set of files behind. If signing is disabled, any existing signature of a file is removed as
+// it would no longer match.
+func writeSignedFiles(files map[string][]byte) error {
+	fileNames := make([]string, 0, len(files))
+	for fileName := range files {
+		fileNames = append(fileNames, fileName)
+	}
+	sort.Strings(fileNames)
+
+	cleanup := func() {
+		for _, fileName := range fileNames {
+			os.Remove(fileName + "_atomic")
+			os.Remove(fileName + "_atomic.sig")
+		}
 	}
 
-	return p7Fingerprints, pemFingerprints, nil
+	for _, fileName := range fileNames {
+		if err := os.WriteFile(fileName+"_atomic", files[fileName], 0644); err != nil {
+			cleanup()
+			return fmt.Errorf("%s: %s", fileName, err.Error())
+		}
+		if err := signFile(fileName + "_atomic"); err != nil {
+			cleanup()
+			return fmt.Errorf("%s: %s", fileName, err.Error())
+		}
+	}
+
+	for _, fileName := range fileNames {
+		if err := os.Rename(fileName+"_atomic", fileName); err != nil {
+			cleanup()
+			return fmt.Errorf("%s: %s", fileName, err.Error())
+		}
+		if fileExists(fileName + "_atomic.sig") {
+			if err := os.Rename(fileName+"_atomic.sig", fileName+".sig"); err != nil {
+				cleanup()
+				return fmt.Errorf("%s: %s", fileName, err.Error())
+			}
+		} else if err := os.Remove(fileName + ".sig"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			// The file was written without signing, so any existing signature no longer matches it
+			cleanup()
+			return fmt.Errorf("%s: %s", fileName, err.Error())
+		}
+	}
+	return nil
 }
 
-func writeTemp(data []byte) (string, error) {
-	f, err := os.CreateTemp("", "rootca")
-	if err != nil {
-		return "", err
+// writeFileAtomic will write data to a temporary file and then rename it to fileName
+func writeFileAtomic(fileName string, data []byte) error {
+	if err := os.WriteFile(fileName+"_atomic", data, 0644); err != nil {
+		os.Remove(fileName + "_atomic")
+		return err
 	}
-	if _, err := f.Write(data); err != nil {
-		return "", err
+	if err := os.Rename(fileName+"_atomic", fileName); err != nil {
+		os.Remove(fileName + "_atomic")
+		return err
 	}
-	f.Sync()
-	f.Close()
-	return f.Name(), nil
+	return nil
 }
 
 func downloadFile(url string, filePath string) error {
@@ -199,16 +317,15 @@ func verifyCertPEMSHA(certPath string, expectedSHA256 string) bool {
 	return true
 }
 
+// getCertPemSHA returns the SHA-256 fingerprint of the PEM-encoded certificate. The certificate is not parsed, so this
+// works for certificates that crypto/x509 rejects, such as those with a negative serial number.
 func getCertPemSHA(certData []byte) (string, error) {
 	certPem, _ := pem.Decode(certData)
-	cert, err := x509.ParseCertificate(certPem.Bytes)
-	if err != nil {
-		return "", err
+	if certPem == nil || certPem.Type != "CERTIFICATE" {
+		return "", fmt.Errorf("invalid certificate pem")
 	}
 
-	signer := sha256.New()
-	signer.Write(cert.Raw)
-	return fmt.Sprintf("%X", signer.Sum(nil)), nil
+	return fmt.Sprintf("%X", sha256.Sum256(certPem.Bytes)), nil
 }
 
 func convertDerToPem(derPath, pemPath string) (string, error) {
@@ -223,43 +340,12 @@ func convertDerToPem(derPath, pemPath string) (string, error) {
 	return fmt.Sprintf("%X", h.Sum(nil)), os.WriteFile(pemPath, pemData, 0644)
 }
 
-func getFileFingerprints(filePath string) (*BundleFingerprint, error) {
-	d, err := os.ReadFile(filePath)
+func copyFile(srcPath, dstPath string) error {
+	data, err := os.ReadFile(srcPath)
 	if err != nil {
-		return nil, err
-	}
-	s1 := fmt.Sprintf("%X", sha1.Sum(d))
-	s256 := fmt.Sprintf("%X", sha256.Sum256(d))
-	s512 := fmt.Sprintf("%X", sha512.Sum512(d))
-	return &BundleFingerprint{s1, s256, s512}, nil
-}
-
-func extractPemCerts(data []byte) [][]byte {
-	pemCerts := [][]byte{}
-	pem := []byte{}
-	isInCert := false
-
-	scanner := bufio.NewScanner(bytes.NewReader(data))
-	for scanner.Scan() {
-		line := scanner.Bytes()
-
-		if bytes.Equal(line, []byte("-----BEGIN CERTIFICATE-----")) {
-			isInCert = true
-		}
-
-		if isInCert {
-			pem = append(pem, line...)
-			pem = append(pem, byte('\n'))
-
-			if bytes.Equal(line, []byte("-----END CERTIFICATE-----")) {
-				pemCerts = append(pemCerts, pem)
-				pem = []byte{}
-				isInCert = false
-			}
-		}
+		return err
 	}
-
-	return pemCerts
+	return os.WriteFile(dstPath, data, 0644)
 }
 
 func fileExists(inPath string) bool {
@@ -291,40 +377,44 @@ func checksumCertShaList(thumbprints []string) string {
 // extractP7B will extract the given PKCS#7 bundle and save all certificates in PEM format with the filename
 // <SHA-256>.crt
 func extractP7B(bundlePath, outputDir string) error {
-	output, err := exec.Command(opensslPath, "pkcs7", "-in", bundlePath, "-print_certs").CombinedOutput()
+	p7Data, err := os.ReadFile(bundlePath)
+	if err != nil {
+		return err
+	}
+	derCerts, err := pkcs7.Decode(p7Data)
 	if err != nil {
 		return fmt.Errorf("error extracting certs: %s", err.Error())
 	}
 
-	pemCerts := extractPemCerts(output)
-	for _, pemCert := range pemCerts {
-		sha, err := getCertPemSHA(pemCert)
+	for _, derCert := range derCerts {
+		// Known issue: For some reason there is but one root certificate that has a negative serial number.
+		// This is invalid, and go (rightfully) enforces this. However, Microsoft still trusts this otherwise
+		// invalid certificate. Even more baffling is that they have not been issuing certificates for the web for
+		// over 6 years.
+		cert, err := rootca.ParseCertificate(derCert)
 		if err != nil {
-			// Known issue: For some reason there is but one root certificate that has a negative serial number.
-			// This is invalid, and go (rightfully) enforces this. However, Microsoft still trusts this otherwise
-			// invalid certificate. Even more baffling is that they have not been issuing certificates for the web for
-			// over 6 years.
-			if strings.Contains(err.Error(), "negative serial number") {
-				continue
-			}
 			return fmt.Errorf("error parsing certificate: %s", err.Error())
 		}
+		if cert == nil {
+			continue
+		}
+		sha := fmt.Sprintf("%X", sha256.Sum256(cert.Raw))
+		pemCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derCert})
 		fileName := path.Join(outputDir, sha+".crt")
 		os.WriteFile(fileName, pemCert, 0644)
 	}
 	return nil
 }
 
-func isBundleUpToDate(inKey, expectedKey string, bundleName string) bool {
+func isBundleUpToDate(inKey, expectedKey string, bundleDir string, vendor Vendor) bool {
 	if inKey != expectedKey {
 		return false
 	}
 
-	if _, err := os.Stat(bundleName + ".p7b"); err != nil {
-		return false
-	}
-	if _, err := os.Stat(bundleName + ".pem"); err != nil {
-		return false
+	for _, extension := range vendorBundleExtensions(vendor) {
+		if _, err := os.Stat(path.Join(bundleDir, vendor.BundleName()+extension)); err != nil {
+			return false
+		}
 	}
 
 	return true
@@ -338,3 +428,35 @@ func sliceContains[T comparable](haystack []T, needle T) bool {
 	}
 	return false
 }
+
+// compareVersions compares two dotted version numbers, returning -1, 0, or 1. Missing components are treated as
+// zero, and components that are not numbers are compared as strings.
+func compareVersions(a, b string) int {
+	aComponents := strings.Split(a, ".")
+	bComponents := strings.Split(b, ".")
+	for i := 0; i < len(aComponents) || i < len(bComponents); i++ {
+		aComponent, bComponent := "0", "0"
+		if i < len(aComponents) {
+			aComponent = aComponents[i]
+		}
+		if i < len(bComponents) {
+			bComponent = bComponents[i]
+		}
+
+		aNumber, aErr := strconv.ParseUint(aComponent, 10, 64)
+		bNumber, bErr := strconv.ParseUint(bComponent, 10, 64)
+		if aErr != nil || bErr != nil {
+			if c := strings.Compare(aComponent, bComponent); c != 0 {
+				return c
+			}
+			continue
+		}
+		if aNumber < bNumber {
+			return -1
+		}
+		if aNumber > bNumber {
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/pem"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestGenerateBundleFromCertificatesTruststores(t *testing.T) {
+	t.Chdir(t.TempDir())
+	signingPrivateKey, signingPublicKey = nil, nil
+
+	certPaths := []string{}
+	for i, cert := range []*testCertificate{
+		generateTestCertificate(t, "Root A", nil, nil, nil),
+		generateTestCertificate(t, "Root B", nil, nil, nil),
+	} {
+		certPath := fmt.Sprintf("cert%d.crt", i)
+		if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Cert.Raw}), 0644); err != nil {
+			t.Fatalf("Error writing certificate: %s", err.Error())
+		}
+		certPaths = append(certPaths, certPath)
+	}
+
+	vendor := microsoftDisallowedVendor{}
+	bundleName := vendor.BundleName()
+	fingerprints, err := generateBundleFromCertificates(certPaths, ".", bundleName, true)
+	if err != nil {
+		t.Fatalf("Error generating bundle: %s", err.Error())
+	}
+	for _, extension := range bundleExtensions {
+		if _, ok := fingerprints[bundleName+extension]; !ok || !fileExists(bundleName+extension) {
+			t.Errorf("Missing %s file", extension)
+		}
+	}
+	if err := os.WriteFile(bundleName+".jks.sig", []byte("stale"), 0644); err != nil {
+		t.Fatalf("Error writing signature: %s", err.Error())
+	}
+
+	fingerprints, err = generateBundleFromCertificates(certPaths, ".", bundleName, false)
+	if err != nil {
+		t.Fatalf("Error generating bundle: %s", err.Error())
+	}
+	if len(fingerprints) != 2 {
+		t.Errorf("Expected 2 files, got %d", len(fingerprints))
+	}
+	for _, extension := range truststoreExtensions {
+		if _, ok := fingerprints[bundleName+extension]; ok {
+			t.Errorf("Unexpected %s fingerprint", extension)
+		}
+		if fileExists(bundleName+extension) || fileExists(bundleName+extension+".sig") {
+			t.Errorf("Stale %s file was not removed", extension)
+		}
+	}
+
+	if !isBundleUpToDate("key", "key", ".", vendor) {
+		t.Errorf("Distrust bundle without truststores is not up-to-date")
+	}
+	if err := signBundle(".", vendor); err != nil {
+		t.Errorf("Error signing distrust bundle: %s", err.Error())
+	}
+	if isBundleUpToDate("key", "key", ".", mozillaVendor{}) {
+		t.Errorf("Bundle without files is up-to-date")
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tls-inspector/rootca/updater/rootca"
+)
+
+// Vendor describes a root program that produces a certificate bundle. Vendors are either sourced directly from an
+// upstream root program, or derived from the bundles of other vendors.
+type Vendor interface {
+	// ID is the unique identifier of the vendor, used as the key in the bundle metadata
+	ID() string
+	// Name is the human readable name of the vendor
+	Name() string
+	// BundleName is the file name of the vendors bundle, without an extension
+	BundleName() string
+	// Inputs returns the IDs of any vendors whose bundles must be built before this vendor
+	Inputs() []string
+	// LatestVersion queries the upstream source for the most recent version of the vendors certificates. The bundles
+	// of all vendors are in bundleDir.
+	LatestVersion(bundleDir string) (*VendorVersion, error)
+	// Fetch will download the certificates of the given version into dir and return the paths to the PEM-encoded
+	// certificate files. The existing bundles of all vendors are in bundleDir.
+	Fetch(version *VendorVersion, bundleDir, dir string) ([]string, error)
+	// Build will generate the vendors bundle in bundleDir from the given certificate files
+	Build(version *VendorVersion, bundleDir string, certPaths []string) (*VendorMetadata, error)
+}
+
+// VendorVersion describes a specific version of a vendors certificates
+type VendorVersion struct {
+	// Key uniquely identifies this version, such as a commit SHA or a checksum
+	Key string
+	// Date is when this version was modified upstream. May be zero if the vendor does not provide a date.
+	Date time.Time
+	// Version is the upstream version number of the certificates, if the vendor publishes one. May be set by Fetch.
+	Version string
+	// Extra holds vendor-specific data needed to fetch this version
+	Extra any
+}
+
+var vendors = []Vendor{
+	androidVendor{},
+	appleVendor{},
+	appleAllRootsVendor{},
+	euQWACVendor{},
+	googleVendor{},
+	microsoftVendor{},
+	microsoftDisallowedVendor{},
+	mozillaVendor{},
+	openJDKVendor{},
+}
+
+func getVendor(id string) Vendor {
+	for _, vendor := range vendors {
+		if vendor.ID() == id {
+			return vendor
+		}
+	}
+	return nil
+}
+
+// validateVendorGraph ensures that all vendor inputs exist and that there are no cyclic dependencies between vendors
+func validateVendorGraph(vendors []Vendor) error {
+	vendorMap := map[string]Vendor{}
+	for _, vendor := range vendors {
+		if _, duplicate := vendorMap[vendor.ID()]; duplicate {
+			return fmt.Errorf("duplicate vendor %s", vendor.ID())
+		}
+		vendorMap[vendor.ID()] = vendor
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := map[string]int{}
+
+	var visit func(id string) error
+	visit = func(id string) error {
+		switch state[id] {
+		case visiting:
+			return fmt.Errorf("cyclic dependency on vendor %s", id)
+		case visited:
+			return nil
+		}
+		state[id] = visiting
+		for _, input := range vendorMap[id].Inputs() {
+			if _, ok := vendorMap[input]; !ok {
+				return fmt.Errorf("vendor %s depends on unknown vendor %s", id, input)
+			}
+			if err := visit(input); err != nil {
+				return err
+			}
+		}
+		state[id] = visited
+		return nil
+	}
+
+	for _, vendor := range vendors {
+		if err := visit(vendor.ID()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// vendorStatus describes the outcome of building a vendors bundle
+type vendorStatus int
+
+const (
+	vendorUpdated vendorStatus = iota
+	vendorUnchanged
+	vendorFailed
+	vendorSkipped
+)
+
+func (s vendorStatus) String() string {
+	switch s {
+	case vendorUpdated:
+		return "updated"
+	case vendorUnchanged:
+		return "unchanged"
+	case vendorFailed:
+		return "failed"
+	case vendorSkipped:
+		return "skipped"
+	}
+	return "unknown"
+}
+
+// vendorResult is the outcome of building a single vendors bundle
+type vendorResult struct {
+	Vendor Vendor
+	Status vendorStatus
+	// The error for failed vendors, or the reason a vendor was skipped
+	Err error
+}
+
+// buildVendors will build and sign the bundles for all given vendors in bundleDir, returning the new metadata and the
+// result of each vendor. Vendors are built concurrently, but a vendor is only built once all of its inputs have finished.
+//
+// A vendor that fails keeps its previous metadata and bundle files. Vendors depending on a failed or skipped vendor
+// are skipped, unless lastGoodInputs is set, in which case they are built using the last-known-good bundle of that
+// input.
+func buildVendors(vendors []Vendor, metadata BundleMetadata, bundleDir string) (BundleMetadata, []vendorResult, error) {
+	if err := validateVendorGraph(vendors); err != nil {
+		return nil, nil, err
+	}
+
+	done := map[string]chan struct{}{}
+	for _, vendor := range vendors {
+		done[vendor.ID()] = make(chan struct{})
+	}
+
+	newMetadata := BundleMetadata{}
+	results := make([]vendorResult, len(vendors))
+	statuses := map[string]vendorStatus{}
+	lock := &sync.Mutex{}
+
+	wg := &sync.WaitGroup{}
+	wg.Add(len(vendors))
+	for i, vendor := range vendors {
+		go func(i int, vendor Vendor) {
+			defer wg.Done()
+			defer close(done[vendor.ID()])
+
+			var vendorMetadata *VendorMetadata
+			if m, ok := metadata[vendor.ID()]; ok {
+				vendorMetadata = &m
+			}
+
+			result := vendorResult{Vendor: vendor}
+			defer func() {
+				lock.Lock()
+				results[i] = result
+				statuses[vendor.ID()] = result.Status
+				if vendorMetadata != nil {
+					newMetadata[vendor.ID()] = *vendorMetadata
+				}
+				lock.Unlock()
+			}()
+
+			for _, input := range vendor.Inputs() {
+				<-done[input]
+				lock.Lock()
+				inputStatus := statuses[input]
+				lock.Unlock()
+				if (inputStatus == vendorFailed || inputStatus == vendorSkipped) && !lastGoodInputs {
+					result.Status = vendorSkipped
+					result.Err = fmt.Errorf("input %s %s", input, inputStatus)
+					logWarning("Skipping %s bundle: input %s %s", vendor.Name(), input, inputStatus)
+					return
+				}
+			}
+
+			newVendorMetadata, updated, err := buildVendorSafe(vendor, vendorMetadata, bundleDir)
+			if err != nil {
+				result.Status = vendorFailed
+				result.Err = err
+				logError("Error updating %s bundle: %s", vendor.ID(), err.Error())
+				return
+			}
+
+			// Rebuilt bundles are signed as they are written, but existing bundles may still need to be signed, such
+			// as when the signing key changes
+			if !updated {
+				if err := signBundle(bundleDir, vendor); err != nil {
+					result.Status = vendorFailed
+					result.Err = err
+					logError("Error signing %s bundle: %s", vendor.ID(), err.Error())
+					return
+				}
+			}
+
+			vendorMetadata = newVendorMetadata
+			result.Status = vendorUnchanged
+			if updated {
+				result.Status = vendorUpdated
+			}
+		}(i, vendor)
+	}
+	wg.Wait()
+
+	return newMetadata, results, nil
+}
+
+// buildVendorSafe calls buildVendor, recovering from any panic as an error so that a single vendor cannot bring down
+// the entire update
+func buildVendorSafe(vendor Vendor, metadata *VendorMetadata, bundleDir string) (newMetadata *VendorMetadata, updated bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			newMetadata = nil
+			updated = false
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return buildVendor(vendor, metadata, bundleDir)
+}
+
+// logVendorResults will log a summary of the result of each vendor
+func logVendorResults(results []vendorResult) {
+	log.Printf("Summary:")
+	for _, result := range results {
+		switch result.Status {
+		case vendorUpdated:
+			logNotice("%s: %s", result.Vendor.Name(), result.Status)
+		case vendorUnchanged:
+			log.Printf("%s: %s", result.Vendor.Name(), result.Status)
+		case vendorSkipped:
+			logWarning("%s: %s (%s)", result.Vendor.Name(), result.Status, result.Err.Error())
+		case vendorFailed:
+			logError("%s: %s (%s)", result.Vendor.Name(), result.Status, result.Err.Error())
+		}
+	}
+}
+
+// buildVendor will build the bundle for the given vendor in bundleDir if changes are detected upstream, otherwise the
+// existing metadata is returned. Returns true if the bundle was rebuilt.
+func buildVendor(vendor Vendor, metadata *VendorMetadata, bundleDir string) (*VendorMetadata, bool, error) {
+	version, err := vendor.LatestVersion(bundleDir)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if metadata != nil && !forceUpdate {
+		lastDate, err := metadata.ParseDate()
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid date in metadata: %s", err.Error())
+		}
+		if !version.Date.IsZero() && version.Date.Before(lastDate) {
+			logWarning("%s bundle has modified date '%s' newer than the most recent vendors date '%s'. Skipping update.", vendor.Name(), lastDate, version.Date)
+			return metadata, false, nil
+		}
+		if isBundleUpToDate(version.Key, metadata.Key, bundleDir, vendor) {
+			logNotice("%s bundle is up-to-date", vendor.Name())
+			return metadata, false, nil
+		}
+		logWarning("Detected changes to %s bundle. LastSHA='%s' LatestSHA='%s'", vendor.Name(), metadata.Key, version.Key)
+	}
+	log.Printf("Building %s CA bundle", vendor.Name())
+
+	tempDir, err := os.MkdirTemp("", vendor.ID())
+	if err != nil {
+		return nil, false, err
+	}
+	defer os.RemoveAll(tempDir)
+
+	certPaths, err := vendor.Fetch(version, bundleDir, tempDir)
+	if err != nil {
+		return nil, false, err
+	}
+
+	newMetadata, err := vendor.Build(version, bundleDir, certPaths)
+	if err != nil {
+		return nil, false, err
+	}
+
+	logNotice("%s CA bundle generated with %d certificates", vendor.Name(), newMetadata.NumCerts)
+	return newMetadata, true, nil
+}
+
+// generateVendorBundle will generate the bundle for the vendor in bundleDir from the given certificate files and return
+// the metadata for the bundle. If the version has no date, the current time is used.
+func generateVendorBundle(vendor Vendor, version *VendorVersion, bundleDir string, certPaths []string) (*VendorMetadata, error) {
+	fingerprints, err := generateBundleFromCertificates(certPaths, bundleDir, vendor.BundleName(), !rootca.IsDistrustVendor(vendor.ID()))
+	if err != nil {
+		return nil, err
+	}
+
+	certificates, err := readCertificateMetadata(bundleDir, vendor.BundleName())
+	if err != nil {
+		return nil, err
+	}
+
+	date := version.Date
+	if date.IsZero() {
+		date = time.Now().UTC()
+	}
+
+	return &VendorMetadata{
+		Date:         date.Format("2006-01-02T15:04:05Z07:00"),
+		Key:          version.Key,
+		Bundles:      fingerprints,
+		NumCerts:     len(certPaths),
+		Version:      version.Version,
+		Certificates: certificates,
+	}, nil
+}
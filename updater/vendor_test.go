@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testVendor is a vendor that does not download or write anything
+type testVendor struct {
+	id     string
+	inputs []string
+	// If set, LatestVersion returns this error
+	err error
+	// If set, LatestVersion panics with this value
+	panic any
+}
+
+func (v testVendor) ID() string         { return v.id }
+func (v testVendor) Name() string       { return v.id }
+func (v testVendor) BundleName() string { return v.id + "_bundle" }
+func (v testVendor) Inputs() []string   { return v.inputs }
+
+func (v testVendor) LatestVersion(bundleDir string) (*VendorVersion, error) {
+	if v.panic != nil {
+		panic(v.panic)
+	}
+	if v.err != nil {
+		return nil, v.err
+	}
+	return &VendorVersion{Key: v.id + "-latest", Date: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}, nil
+}
+
+func (v testVendor) Fetch(version *VendorVersion, bundleDir, dir string) ([]string, error) {
+	return nil, nil
+}
+
+func (v testVendor) Build(version *VendorVersion, bundleDir string, certPaths []string) (*VendorMetadata, error) {
+	return &VendorMetadata{Key: version.Key, Date: version.Date.Format("2006-01-02T15:04:05Z07:00")}, nil
+}
+
+func TestValidateVendorGraph(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		vendors []Vendor
+		message string
+	}{
+		{name: "valid", vendors: []Vendor{testVendor{id: "a"}, testVendor{id: "b", inputs: []string{"a"}}, testVendor{id: "c", inputs: []string{"a", "b"}}}},
+		{name: "cycle", vendors: []Vendor{testVendor{id: "a", inputs: []string{"c"}}, testVendor{id: "b", inputs: []string{"a"}}, testVendor{id: "c", inputs: []string{"b"}}}, message: "cyclic dependency"},
+		{name: "self", vendors: []Vendor{testVendor{id: "a", inputs: []string{"a"}}}, message: "cyclic dependency"},
+		{name: "unknown input", vendors: []Vendor{testVendor{id: "a", inputs: []string{"b"}}}, message: "unknown vendor b"},
+		{name: "duplicate", vendors: []Vendor{testVendor{id: "a"}, testVendor{id: "a"}}, message: "duplicate vendor a"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateVendorGraph(test.vendors)
+			if test.message == "" {
+				if err != nil {
+					t.Errorf("Unexpected error %s", err.Error())
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.message) {
+				t.Errorf("Unexpected error %v", err)
+			}
+		})
+	}
+}
+
+func TestBuildVendorsFailureIsolation(t *testing.T) {
+	t.Chdir(t.TempDir())
+	signingPrivateKey, signingPublicKey = nil, nil
+
+	testVendors := []Vendor{
+		testVendor{id: "failed", err: fmt.Errorf("upstream unavailable")},
+		testVendor{id: "dependent", inputs: []string{"failed"}},
+		testVendor{id: "transitive", inputs: []string{"dependent"}},
+		testVendor{id: "panicked", panic: "unexpected"},
+		testVendor{id: "bad_date"},
+		testVendor{id: "independent"},
+	}
+	metadata := BundleMetadata{
+		"failed":   {Key: "failed-previous", Date: "2023-01-01T00:00:00Z"},
+		"bad_date": {Key: "bad_date-previous", Date: "not a date"},
+	}
+
+	for _, test := range []struct {
+		name           string
+		lastGoodInputs bool
+		expected       map[string]vendorStatus
+	}{
+		{
+			name: "skip dependents",
+			expected: map[string]vendorStatus{
+				"failed":      vendorFailed,
+				"dependent":   vendorSkipped,
+				"transitive":  vendorSkipped,
+				"panicked":    vendorFailed,
+				"bad_date":    vendorFailed,
+				"independent": vendorUpdated,
+			},
+		},
+		{
+			name:           "last good inputs",
+			lastGoodInputs: true,
+			expected: map[string]vendorStatus{
+				"failed":      vendorFailed,
+				"dependent":   vendorUpdated,
+				"transitive":  vendorUpdated,
+				"panicked":    vendorFailed,
+				"bad_date":    vendorFailed,
+				"independent": vendorUpdated,
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			previous := lastGoodInputs
+			lastGoodInputs = test.lastGoodInputs
+			defer func() {
+				lastGoodInputs = previous
+			}()
+
+			newMetadata, results, err := buildVendors(testVendors, metadata, t.TempDir())
+			if err != nil {
+				t.Fatalf("Error building vendors: %s", err.Error())
+			}
+			if len(results) != len(testVendors) {
+				t.Fatalf("Expected %d results, got %d", len(testVendors), len(results))
+			}
+			for i, result := range results {
+				if result.Vendor.ID() != testVendors[i].ID() {
+					t.Errorf("Result %d is for %s, expected %s", i, result.Vendor.ID(), testVendors[i].ID())
+				}
+				if result.Status != test.expected[result.Vendor.ID()] {
+					t.Errorf("%s: expected %s, got %s", result.Vendor.ID(), test.expected[result.Vendor.ID()], result.Status)
+				}
+				if (result.Status == vendorFailed || result.Status == vendorSkipped) && result.Err == nil {
+					t.Errorf("%s: no error", result.Vendor.ID())
+				}
+				switch result.Vendor.ID() {
+				case "panicked":
+					if !strings.HasPrefix(result.Err.Error(), "panic: ") {
+						t.Errorf("Unexpected error %s", result.Err.Error())
+					}
+				case "bad_date":
+					if !strings.Contains(result.Err.Error(), "invalid date") {
+						t.Errorf("Unexpected error %s", result.Err.Error())
+					}
+				}
+			}
+
+			// Failed vendors keep their previous metadata, and vendors that never built have none
+			for id, key := range map[string]string{"failed": "failed-previous", "bad_date": "bad_date-previous", "independent": "independent-latest"} {
+				if newMetadata[id].Key != key {
+					t.Errorf("%s: expected key %s, got %s", id, key, newMetadata[id].Key)
+				}
+			}
+			if _, ok := newMetadata["panicked"]; ok {
+				t.Errorf("Unexpected metadata for vendor that never built")
+			}
+		})
+	}
+}
+
+func TestBuildVendorsInvalidGraph(t *testing.T) {
+	if _, _, err := buildVendors([]Vendor{testVendor{id: "a", inputs: []string{"a"}}}, BundleMetadata{}, t.TempDir()); err == nil {
+		t.Errorf("No error seen for cyclic dependency")
+	}
+}
@@ -0,0 +1,188 @@
+package xmldsig
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+)
+
+// Supported canonicalization algorithms. Comments are never included in the canonical form, so the WithComments
+// variants are not supported.
+const (
+	AlgorithmC14N          = "http://www.w3.org/TR/2001/REC-xml-c14n-20010315"
+	AlgorithmExclusiveC14N = "http://www.w3.org/2001/10/xml-exc-c14n#"
+)
+
+// canonicalizer produces the canonical form of an element subtree
+type canonicalizer struct {
+	// If true, use exclusive canonicalization, otherwise inclusive canonicalization
+	Exclusive bool
+	// The prefixes that are treated as visibly utilized with exclusive canonicalization. #default is the default
+	// namespace.
+	InclusivePrefixes []string
+	// An element to omit from the output, such as the signature of an enveloped signature
+	Exclude *element
+}
+
+// canonicalizeDocument returns the canonical form of an entire document
+func (c canonicalizer) canonicalizeDocument(doc *document) []byte {
+	b := &bytes.Buffer{}
+	for _, pi := range doc.Before {
+		writeProcInst(b, pi)
+		b.WriteByte('\n')
+	}
+	c.writeElement(b, doc.Root, map[string]string{}, true)
+	for _, pi := range doc.After {
+		b.WriteByte('\n')
+		writeProcInst(b, pi)
+	}
+	return b.Bytes()
+}
+
+// canonicalize returns the canonical form of the subtree rooted at the element
+func (c canonicalizer) canonicalize(e *element) []byte {
+	b := &bytes.Buffer{}
+	c.writeElement(b, e, map[string]string{}, true)
+	return b.Bytes()
+}
+
+func (c canonicalizer) writeElement(b *bytes.Buffer, e *element, rendered map[string]string, apex bool) {
+	if e == c.Exclude {
+		return
+	}
+
+	inScope := e.inScopeNamespaces()
+
+	// Determine which namespace prefixes are candidates for output
+	candidates := map[string]bool{}
+	if c.Exclusive {
+		candidates[e.Prefix] = true
+		for _, attr := range e.Attrs {
+			if attr.Prefix != "" {
+				candidates[attr.Prefix] = true
+			}
+		}
+		for _, prefix := range c.InclusivePrefixes {
+			if prefix == "#default" {
+				prefix = ""
+			}
+			if _, ok := inScope[prefix]; ok {
+				candidates[prefix] = true
+			}
+		}
+	} else {
+		for prefix := range inScope {
+			candidates[prefix] = true
+		}
+		candidates[""] = true
+	}
+	delete(candidates, "xml")
+
+	// Only output namespaces that differ from those rendered by the nearest output ancestor
+	childRendered := map[string]string{}
+	for prefix, uri := range rendered {
+		childRendered[prefix] = uri
+	}
+	prefixes := []string{}
+	for prefix := range candidates {
+		uri := inScope[prefix]
+		renderedURI, ok := rendered[prefix]
+		if prefix == "" {
+			if uri == renderedURI {
+				continue
+			}
+		} else if ok && uri == renderedURI {
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+		childRendered[prefix] = uri
+	}
+	sort.Strings(prefixes)
+
+	attrs := append([]attribute{}, e.Attrs...)
+	// Inclusive canonicalization of a subtree inherits the xml: attributes of its ancestors
+	if apex && !c.Exclusive {
+		for p := e.Parent; p != nil; p = p.Parent {
+			for _, attr := range p.Attrs {
+				if attr.Prefix != "xml" {
+					continue
+				}
+				present := false
+				for _, a := range attrs {
+					if a.Prefix == "xml" && a.Local == attr.Local {
+						present = true
+						break
+					}
+				}
+				if !present {
+					attrs = append(attrs, attr)
+				}
+			}
+		}
+	}
+	attrNamespace := func(attr attribute) string {
+		if attr.Prefix == "" {
+			return ""
+		}
+		uri, _ := e.lookupNamespace(attr.Prefix)
+		return uri
+	}
+	sort.SliceStable(attrs, func(i, j int) bool {
+		ni, nj := attrNamespace(attrs[i]), attrNamespace(attrs[j])
+		if ni != nj {
+			return ni < nj
+		}
+		return attrs[i].Local < attrs[j].Local
+	})
+
+	name := qualifiedName(e.Prefix, e.Local)
+	b.WriteByte('<')
+	b.WriteString(name)
+	for _, prefix := range prefixes {
+		if prefix == "" {
+			b.WriteString(` xmlns="`)
+		} else {
+			b.WriteString(` xmlns:` + prefix + `="`)
+		}
+		b.WriteString(escapeAttr(inScope[prefix]))
+		b.WriteByte('"')
+	}
+	for _, attr := range attrs {
+		b.WriteString(" " + qualifiedName(attr.Prefix, attr.Local) + `="` + escapeAttr(attr.Value) + `"`)
+	}
+	b.WriteByte('>')
+
+	for _, child := range e.Children {
+		switch n := child.(type) {
+		case *element:
+			c.writeElement(b, n, childRendered, false)
+		case charData:
+			b.WriteString(escapeText(string(n)))
+		case procInst:
+			writeProcInst(b, n)
+		}
+	}
+
+	b.WriteString("</" + name + ">")
+}
+
+func writeProcInst(b *bytes.Buffer, pi procInst) {
+	b.WriteString("<?" + pi.Target)
+	if pi.Inst != "" {
+		b.WriteString(" " + strings.TrimLeft(pi.Inst, " \t\r\n"))
+	}
+	b.WriteString("?>")
+}
+
+func qualifiedName(prefix, local string) string {
+	if prefix == "" {
+		return local
+	}
+	return prefix + ":" + local
+}
+
+var textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+var attrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+
+func escapeText(s string) string { return textEscaper.Replace(s) }
+func escapeAttr(s string) string { return attrEscaper.Replace(s) }
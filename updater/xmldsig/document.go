@@ -0,0 +1,270 @@
+// Package xmldsig verifies enveloped XML signatures, such as those of ETSI TS 119 612 trusted lists. Only the subset
+// of XML Signature needed for enveloped signatures over an entire document is supported, using the exclusive or
+// inclusive canonicalization algorithms without comments.
+package xmldsig
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+const xmlNamespace = "http://www.w3.org/XML/1998/namespace"
+
+// element is an XML element with its namespace prefixes preserved, as required for canonicalization
+type element struct {
+	Prefix string
+	Local  string
+	// Attributes, excluding namespace declarations
+	Attrs []attribute
+	// Namespace declarations on this element, keyed by prefix. The default namespace has an empty prefix.
+	Namespaces map[string]string
+	// Child nodes, either *element, charData, or procInst
+	Children []any
+	Parent   *element
+}
+
+type attribute struct {
+	Prefix string
+	Local  string
+	Value  string
+}
+
+type charData string
+
+type procInst struct {
+	Target string
+	Inst   string
+}
+
+// document is a parsed XML document. Processing instructions outside of the root element are kept for
+// canonicalization.
+type document struct {
+	Before []procInst
+	Root   *element
+	After  []procInst
+}
+
+// parseDocument will parse an XML document. Documents with a DTD are rejected, as a DTD could alter the content of
+// the document in ways that are not reflected by its canonical form.
+func parseDocument(data []byte) (*document, error) {
+	d := xml.NewDecoder(bytes.NewReader(normalizeAttributeWhitespace(data)))
+	doc := &document{}
+	var current *element
+
+	for {
+		token, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			e := &element{
+				Prefix:     t.Name.Space,
+				Local:      t.Name.Local,
+				Namespaces: map[string]string{},
+				Parent:     current,
+			}
+			for _, attr := range t.Attr {
+				switch {
+				case attr.Name.Space == "" && attr.Name.Local == "xmlns":
+					e.Namespaces[""] = attr.Value
+				case attr.Name.Space == "xmlns":
+					e.Namespaces[attr.Name.Local] = attr.Value
+				default:
+					e.Attrs = append(e.Attrs, attribute{Prefix: attr.Name.Space, Local: attr.Name.Local, Value: attr.Value})
+				}
+			}
+			if current == nil {
+				if doc.Root != nil {
+					return nil, fmt.Errorf("multiple root elements")
+				}
+				doc.Root = e
+			} else {
+				current.Children = append(current.Children, e)
+			}
+			current = e
+		case xml.EndElement:
+			if current == nil || t.Name.Space != current.Prefix || t.Name.Local != current.Local {
+				return nil, fmt.Errorf("unexpected end element %s", t.Name.Local)
+			}
+			current = current.Parent
+		case xml.CharData:
+			if current != nil {
+				current.Children = append(current.Children, charData(t))
+			} else if len(bytes.TrimSpace(t)) > 0 {
+				return nil, fmt.Errorf("character data outside of root element")
+			}
+		case xml.ProcInst:
+			// The XML declaration is not a processing instruction
+			if t.Target == "xml" {
+				continue
+			}
+			pi := procInst{Target: t.Target, Inst: string(t.Inst)}
+			switch {
+			case current != nil:
+				current.Children = append(current.Children, pi)
+			case doc.Root == nil:
+				doc.Before = append(doc.Before, pi)
+			default:
+				doc.After = append(doc.After, pi)
+			}
+		case xml.Directive:
+			return nil, fmt.Errorf("unsupported directive")
+		}
+	}
+
+	if current != nil {
+		return nil, fmt.Errorf("unexpected end of document")
+	}
+	if doc.Root == nil {
+		return nil, fmt.Errorf("no root element")
+	}
+	return doc, nil
+}
+
+// normalizeAttributeWhitespace will replace literal whitespace characters within attribute values with spaces, as
+// required by XML attribute-value normalization. encoding/xml does not normalize attribute values, and after decoding
+// literal whitespace cannot be distinguished from whitespace character references, which are not normalized.
+func normalizeAttributeWhitespace(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	inTag := false
+	var quote byte
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case quote != 0:
+			switch c {
+			case quote:
+				quote = 0
+			case '\r':
+				// A CRLF pair is a single line break
+				if i+1 < len(data) && data[i+1] == '\n' {
+					i++
+				}
+				c = ' '
+			case '\n', '\t':
+				c = ' '
+			}
+		case inTag:
+			switch c {
+			case '"', '\'':
+				quote = c
+			case '>':
+				inTag = false
+			}
+		case c == '<':
+			// Comments, CDATA sections, processing instructions, and directives are copied as-is
+			if n := markupSectionLength(data[i:]); n > 0 {
+				out = append(out, data[i:i+n]...)
+				i += n - 1
+				continue
+			}
+			inTag = true
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// markupSectionLength returns the length of the comment, CDATA section, processing instruction, or directive at the
+// start of data, or 0 if there is none
+func markupSectionLength(data []byte) int {
+	for _, section := range [][2]string{{"<!--", "-->"}, {"<![CDATA[", "]]>"}, {"<?", "?>"}, {"<!", ">"}} {
+		if !bytes.HasPrefix(data, []byte(section[0])) {
+			continue
+		}
+		end := bytes.Index(data[len(section[0]):], []byte(section[1]))
+		if end == -1 {
+			return len(data)
+		}
+		return len(section[0]) + end + len(section[1])
+	}
+	return 0
+}
+
+// lookupNamespace returns the namespace URI bound to the prefix in the scope of the element
+func (e *element) lookupNamespace(prefix string) (string, bool) {
+	if prefix == "xml" {
+		return xmlNamespace, true
+	}
+	for p := e; p != nil; p = p.Parent {
+		if uri, ok := p.Namespaces[prefix]; ok {
+			return uri, true
+		}
+	}
+	return "", false
+}
+
+// inScopeNamespaces returns every namespace binding in the scope of the element, keyed by prefix
+func (e *element) inScopeNamespaces() map[string]string {
+	namespaces := map[string]string{}
+	for p := e; p != nil; p = p.Parent {
+		for prefix, uri := range p.Namespaces {
+			if _, ok := namespaces[prefix]; !ok {
+				namespaces[prefix] = uri
+			}
+		}
+	}
+	return namespaces
+}
+
+// namespace returns the namespace URI of the element
+func (e *element) namespace() string {
+	uri, _ := e.lookupNamespace(e.Prefix)
+	return uri
+}
+
+// is returns true if the element has the given namespace URI and local name
+func (e *element) is(namespace, local string) bool {
+	return e.Local == local && e.namespace() == namespace
+}
+
+// children returns the child elements with the given namespace URI and local name
+func (e *element) children(namespace, local string) []*element {
+	elements := []*element{}
+	for _, child := range e.Children {
+		if c, ok := child.(*element); ok && c.is(namespace, local) {
+			elements = append(elements, c)
+		}
+	}
+	return elements
+}
+
+// child returns the first child element with the given namespace URI and local name, or nil
+func (e *element) child(namespace, local string) *element {
+	children := e.children(namespace, local)
+	if len(children) == 0 {
+		return nil
+	}
+	return children[0]
+}
+
+// attr returns the value of the unqualified attribute with the given name
+func (e *element) attr(local string) (string, bool) {
+	for _, attr := range e.Attrs {
+		if attr.Prefix == "" && attr.Local == local {
+			return attr.Value, true
+		}
+	}
+	return "", false
+}
+
+// text returns the concatenated character data of the element and its descendants
+func (e *element) text() string {
+	b := &bytes.Buffer{}
+	for _, child := range e.Children {
+		switch c := child.(type) {
+		case charData:
+			b.WriteString(string(c))
+		case *element:
+			b.WriteString(c.text())
+		}
+	}
+	return b.String()
+}
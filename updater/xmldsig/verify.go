@@ -0,0 +1,304 @@
+package xmldsig
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"strings"
+
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+const (
+	namespaceDSig = "http://www.w3.org/2000/09/xmldsig#"
+
+	transformEnveloped = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+)
+
+// digestMethods maps supported digest algorithm URIs to their hash
+var digestMethods = map[string]crypto.Hash{
+	"http://www.w3.org/2000/09/xmldsig#sha1":        crypto.SHA1,
+	"http://www.w3.org/2001/04/xmlenc#sha256":       crypto.SHA256,
+	"http://www.w3.org/2001/04/xmldsig-more#sha384": crypto.SHA384,
+	"http://www.w3.org/2001/04/xmlenc#sha512":       crypto.SHA512,
+}
+
+type signatureMethod struct {
+	Hash crypto.Hash
+	// One of rsa, rsa-pss, or ecdsa
+	Type string
+}
+
+// signatureMethods maps supported signature algorithm URIs to their hash and key type
+var signatureMethods = map[string]signatureMethod{
+	"http://www.w3.org/2000/09/xmldsig#rsa-sha1":             {crypto.SHA1, "rsa"},
+	"http://www.w3.org/2001/04/xmldsig-more#rsa-sha256":      {crypto.SHA256, "rsa"},
+	"http://www.w3.org/2001/04/xmldsig-more#rsa-sha384":      {crypto.SHA384, "rsa"},
+	"http://www.w3.org/2001/04/xmldsig-more#rsa-sha512":      {crypto.SHA512, "rsa"},
+	"http://www.w3.org/2007/05/xmldsig-more#sha256-rsa-MGF1": {crypto.SHA256, "rsa-pss"},
+	"http://www.w3.org/2007/05/xmldsig-more#sha384-rsa-MGF1": {crypto.SHA384, "rsa-pss"},
+	"http://www.w3.org/2007/05/xmldsig-more#sha512-rsa-MGF1": {crypto.SHA512, "rsa-pss"},
+	"http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256":    {crypto.SHA256, "ecdsa"},
+	"http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha384":    {crypto.SHA384, "ecdsa"},
+	"http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha512":    {crypto.SHA512, "ecdsa"},
+}
+
+// VerifyOptions controls how the signature of a document is verified
+type VerifyOptions struct {
+	// The certificates that may sign the document. Required.
+	Certificates []*x509.Certificate
+}
+
+// Verify will verify the enveloped signature of the given XML document and return the signing certificate. The
+// document must have exactly one signature as a child of its root element, the signature must include a reference to
+// the entire document, and the signing key must belong to one of the certificates in the options. Certificates are
+// not otherwise validated, as trusted lists are commonly signed by certificates that are only trusted by being listed.
+func Verify(data []byte, options VerifyOptions) (*x509.Certificate, error) {
+	if len(options.Certificates) == 0 {
+		return nil, fmt.Errorf("xmldsig: no certificates")
+	}
+
+	doc, err := parseDocument(data)
+	if err != nil {
+		return nil, fmt.Errorf("xmldsig: %s", err.Error())
+	}
+
+	signatures := doc.Root.children(namespaceDSig, "Signature")
+	if len(signatures) != 1 {
+		return nil, fmt.Errorf("xmldsig: unexpected number of signatures. expected 1 got %d", len(signatures))
+	}
+	signature := signatures[0]
+
+	signedInfo := signature.child(namespaceDSig, "SignedInfo")
+	if signedInfo == nil {
+		return nil, fmt.Errorf("xmldsig: no SignedInfo")
+	}
+	signedInfoCanonicalizer, err := newCanonicalizer(signedInfo.child(namespaceDSig, "CanonicalizationMethod"))
+	if err != nil {
+		return nil, fmt.Errorf("xmldsig: %s", err.Error())
+	}
+	methodElement := signedInfo.child(namespaceDSig, "SignatureMethod")
+	if methodElement == nil {
+		return nil, fmt.Errorf("xmldsig: no SignatureMethod")
+	}
+	methodAlgorithm, _ := methodElement.attr("Algorithm")
+	method, ok := signatureMethods[methodAlgorithm]
+	if !ok {
+		return nil, fmt.Errorf("xmldsig: unsupported signature method %s", methodAlgorithm)
+	}
+	signatureValueElement := signature.child(namespaceDSig, "SignatureValue")
+	if signatureValueElement == nil {
+		return nil, fmt.Errorf("xmldsig: no SignatureValue")
+	}
+	signatureValue, err := decodeBase64(signatureValueElement.text())
+	if err != nil {
+		return nil, fmt.Errorf("xmldsig: invalid SignatureValue: %s", err.Error())
+	}
+
+	// Only the certificates included in the signature are tried, if there are any
+	candidates := options.Certificates
+	if keyInfo := signature.child(namespaceDSig, "KeyInfo"); keyInfo != nil {
+		keyInfoCertificates := [][]byte{}
+		for _, x509Data := range keyInfo.children(namespaceDSig, "X509Data") {
+			for _, certElement := range x509Data.children(namespaceDSig, "X509Certificate") {
+				der, err := decodeBase64(certElement.text())
+				if err != nil {
+					return nil, fmt.Errorf("xmldsig: invalid X509Certificate: %s", err.Error())
+				}
+				keyInfoCertificates = append(keyInfoCertificates, der)
+			}
+		}
+		if len(keyInfoCertificates) > 0 {
+			candidates = nil
+			for _, certificate := range options.Certificates {
+				for _, der := range keyInfoCertificates {
+					if bytes.Equal(certificate.Raw, der) {
+						candidates = append(candidates, certificate)
+						break
+					}
+				}
+			}
+		}
+	}
+
+	h := method.Hash.New()
+	h.Write(signedInfoCanonicalizer.canonicalize(signedInfo))
+	digest := h.Sum(nil)
+	var signer *x509.Certificate
+	for _, certificate := range candidates {
+		if verifySignature(certificate, method, digest, signatureValue) {
+			signer = certificate
+			break
+		}
+	}
+	if signer == nil {
+		return nil, fmt.Errorf("xmldsig: signature not valid for any certificate")
+	}
+
+	references := signedInfo.children(namespaceDSig, "Reference")
+	if len(references) == 0 {
+		return nil, fmt.Errorf("xmldsig: no references")
+	}
+	coversDocument := false
+	for _, reference := range references {
+		uri, _ := reference.attr("URI")
+		if err := verifyReference(doc, signature, reference); err != nil {
+			return nil, fmt.Errorf("xmldsig: reference '%s': %s", uri, err.Error())
+		}
+		if uri == "" {
+			coversDocument = true
+		}
+	}
+	if !coversDocument {
+		return nil, fmt.Errorf("xmldsig: signature does not reference the document")
+	}
+
+	return signer, nil
+}
+
+// verifyReference will verify the digest of a single reference. The reference must either be to the entire document,
+// with the enveloped signature transform, or to an element by its ID.
+func verifyReference(doc *document, signature, reference *element) error {
+	uri, ok := reference.attr("URI")
+	if !ok {
+		return fmt.Errorf("no URI")
+	}
+
+	var target *element
+	if uri != "" {
+		if !strings.HasPrefix(uri, "#") {
+			return fmt.Errorf("unsupported URI")
+		}
+		matches := findElementsByID(doc.Root, uri[1:])
+		if len(matches) != 1 {
+			return fmt.Errorf("unexpected number of elements with ID. expected 1 got %d", len(matches))
+		}
+		target = matches[0]
+	}
+
+	c := canonicalizer{}
+	enveloped := false
+	if transforms := reference.child(namespaceDSig, "Transforms"); transforms != nil {
+		for _, transform := range transforms.children(namespaceDSig, "Transform") {
+			algorithm, _ := transform.attr("Algorithm")
+			if algorithm == transformEnveloped {
+				enveloped = true
+				continue
+			}
+			var err error
+			if c, err = newCanonicalizer(transform); err != nil {
+				return err
+			}
+		}
+	}
+	if uri == "" && !enveloped {
+		return fmt.Errorf("reference to the document without the enveloped signature transform")
+	}
+	if enveloped {
+		c.Exclude = signature
+	}
+
+	digestMethod := reference.child(namespaceDSig, "DigestMethod")
+	if digestMethod == nil {
+		return fmt.Errorf("no DigestMethod")
+	}
+	digestAlgorithm, _ := digestMethod.attr("Algorithm")
+	hash, ok := digestMethods[digestAlgorithm]
+	if !ok {
+		return fmt.Errorf("unsupported digest method %s", digestAlgorithm)
+	}
+	digestValueElement := reference.child(namespaceDSig, "DigestValue")
+	if digestValueElement == nil {
+		return fmt.Errorf("no DigestValue")
+	}
+	digestValue, err := decodeBase64(digestValueElement.text())
+	if err != nil {
+		return fmt.Errorf("invalid DigestValue: %s", err.Error())
+	}
+
+	var canonical []byte
+	if target == nil {
+		canonical = c.canonicalizeDocument(doc)
+	} else {
+		canonical = c.canonicalize(target)
+	}
+	h := hash.New()
+	h.Write(canonical)
+	if subtle.ConstantTimeCompare(h.Sum(nil), digestValue) != 1 {
+		return fmt.Errorf("digest does not match")
+	}
+	return nil
+}
+
+// newCanonicalizer returns a canonicalizer for the algorithm of the given CanonicalizationMethod or Transform element
+func newCanonicalizer(method *element) (canonicalizer, error) {
+	if method == nil {
+		return canonicalizer{}, fmt.Errorf("no canonicalization method")
+	}
+	algorithm, _ := method.attr("Algorithm")
+	switch algorithm {
+	case AlgorithmC14N:
+		return canonicalizer{}, nil
+	case AlgorithmExclusiveC14N:
+		c := canonicalizer{Exclusive: true}
+		for _, child := range method.Children {
+			if e, ok := child.(*element); ok && e.Local == "InclusiveNamespaces" && e.namespace() == AlgorithmExclusiveC14N {
+				prefixList, _ := e.attr("PrefixList")
+				c.InclusivePrefixes = strings.Fields(prefixList)
+			}
+		}
+		return c, nil
+	}
+	return canonicalizer{}, fmt.Errorf("unsupported canonicalization method %s", algorithm)
+}
+
+// findElementsByID returns every element with an Id, ID, or id attribute with the given value
+func findElementsByID(e *element, id string) []*element {
+	matches := []*element{}
+	for _, name := range []string{"Id", "ID", "id"} {
+		if value, ok := e.attr(name); ok && value == id {
+			matches = append(matches, e)
+			break
+		}
+	}
+	for _, child := range e.Children {
+		if c, ok := child.(*element); ok {
+			matches = append(matches, findElementsByID(c, id)...)
+		}
+	}
+	return matches
+}
+
+func verifySignature(certificate *x509.Certificate, method signatureMethod, digest, signature []byte) bool {
+	switch method.Type {
+	case "rsa":
+		key, ok := certificate.PublicKey.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(key, method.Hash, digest, signature) == nil
+	case "rsa-pss":
+		key, ok := certificate.PublicKey.(*rsa.PublicKey)
+		return ok && rsa.VerifyPSS(key, method.Hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto}) == nil
+	case "ecdsa":
+		// XML signatures use the concatenation of r and s rather than an ASN.1 structure
+		key, ok := certificate.PublicKey.(*ecdsa.PublicKey)
+		if !ok || len(signature)%2 != 0 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:len(signature)/2])
+		s := new(big.Int).SetBytes(signature[len(signature)/2:])
+		return ecdsa.Verify(key, digest, r, s)
+	}
+	return false
+}
+
+// decodeBase64 will decode base64 that may contain whitespace
+func decodeBase64(s string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s), ""))
+}
@@ -0,0 +1,312 @@
+package xmldsig
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The expected canonical forms in these tests were produced with xmllint --c14n and --exc-c14n
+func TestCanonicalizeDocument(t *testing.T) {
+	input := `<?xml version="1.0"?>
+<?xml-stylesheet href="doc.xsl" type="text/xsl"?>
+<doc>
+   <e1   />
+   <e2   ></e2>
+   <e3   name = "elem3"   id="elem3"   />
+   <e5 a:attr="out" b:attr="sorted" attr2="all" attr="I'm"
+      xmlns:b="http://www.ietf.org"
+      xmlns:a="http://www.w3.org"
+      xmlns="http://example.org"/>
+   <e6 xmlns="" xmlns:a="http://www.w3.org">
+      <e7 xmlns="http://www.ietf.org">
+         <e8 xmlns="" xmlns:a="http://www.w3.org">
+            <e9 xmlns="" xmlns:a="http://www.ietf.org"/>
+         </e8>
+      </e7>
+   </e6>
+   <text attr="tab&#9;lf&#10;quote&quot;lt&lt;amp&amp;gt&gt;" plain='single "quoted"'>A &lt; B &amp;&amp; C &gt; D&#13;<![CDATA[<cdata> & stuff]]></text>
+</doc>
+<?pi-after data?>
+`
+	expected := `<?xml-stylesheet href="doc.xsl" type="text/xsl"?>
+<doc>
+   <e1></e1>
+   <e2></e2>
+   <e3 id="elem3" name="elem3"></e3>
+   <e5 xmlns="http://example.org" xmlns:a="http://www.w3.org" xmlns:b="http://www.ietf.org" attr="I'm" attr2="all" b:attr="sorted" a:attr="out"></e5>
+   <e6 xmlns:a="http://www.w3.org">
+      <e7 xmlns="http://www.ietf.org">
+         <e8 xmlns="">
+            <e9 xmlns:a="http://www.ietf.org"></e9>
+         </e8>
+      </e7>
+   </e6>
+   <text attr="tab&#x9;lf&#xA;quote&quot;lt&lt;amp&amp;gt>" plain="single &quot;quoted&quot;">A &lt; B &amp;&amp; C &gt; D&#xD;&lt;cdata&gt; &amp; stuff</text>
+</doc>
+<?pi-after data?>`
+
+	doc, err := parseDocument([]byte(input))
+	if err != nil {
+		t.Fatalf("Error parsing document: %s", err.Error())
+	}
+	if canonical := string(canonicalizer{}.canonicalizeDocument(doc)); canonical != expected {
+		t.Errorf("Unexpected canonical form:\n%s\nexpected:\n%s", canonical, expected)
+	}
+}
+
+func TestCanonicalizeNamespaces(t *testing.T) {
+	input := `<n0:local xmlns:n0="foo:bar" xmlns:n3="ftp://example.org"><n1:elem2 xmlns:n1="http://example.net" xml:lang="en"><n3:stuff xmlns:n3="ftp://example.org"/><n4:stuff xmlns:n4="http://unused.example"/></n1:elem2></n0:local>`
+	doc, err := parseDocument([]byte(input))
+	if err != nil {
+		t.Fatalf("Error parsing document: %s", err.Error())
+	}
+	subtree := doc.Root.Children[0].(*element)
+
+	for _, test := range []struct {
+		name     string
+		c        canonicalizer
+		root     *element
+		expected string
+	}{
+		{
+			name:     "inclusive document",
+			c:        canonicalizer{},
+			root:     doc.Root,
+			expected: `<n0:local xmlns:n0="foo:bar" xmlns:n3="ftp://example.org"><n1:elem2 xmlns:n1="http://example.net" xml:lang="en"><n3:stuff></n3:stuff><n4:stuff xmlns:n4="http://unused.example"></n4:stuff></n1:elem2></n0:local>`,
+		},
+		{
+			name:     "exclusive document",
+			c:        canonicalizer{Exclusive: true},
+			root:     doc.Root,
+			expected: `<n0:local xmlns:n0="foo:bar"><n1:elem2 xmlns:n1="http://example.net" xml:lang="en"><n3:stuff xmlns:n3="ftp://example.org"></n3:stuff><n4:stuff xmlns:n4="http://unused.example"></n4:stuff></n1:elem2></n0:local>`,
+		},
+		{
+			name:     "inclusive subtree",
+			c:        canonicalizer{},
+			root:     subtree,
+			expected: `<n1:elem2 xmlns:n0="foo:bar" xmlns:n1="http://example.net" xmlns:n3="ftp://example.org" xml:lang="en"><n3:stuff></n3:stuff><n4:stuff xmlns:n4="http://unused.example"></n4:stuff></n1:elem2>`,
+		},
+		{
+			name:     "exclusive subtree",
+			c:        canonicalizer{Exclusive: true},
+			root:     subtree,
+			expected: `<n1:elem2 xmlns:n1="http://example.net" xml:lang="en"><n3:stuff xmlns:n3="ftp://example.org"></n3:stuff><n4:stuff xmlns:n4="http://unused.example"></n4:stuff></n1:elem2>`,
+		},
+		{
+			name:     "exclusive subtree with inclusive prefixes",
+			c:        canonicalizer{Exclusive: true, InclusivePrefixes: []string{"n0"}},
+			root:     subtree,
+			expected: `<n1:elem2 xmlns:n0="foo:bar" xmlns:n1="http://example.net" xml:lang="en"><n3:stuff xmlns:n3="ftp://example.org"></n3:stuff><n4:stuff xmlns:n4="http://unused.example"></n4:stuff></n1:elem2>`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if canonical := string(test.c.canonicalize(test.root)); canonical != test.expected {
+				t.Errorf("Unexpected canonical form:\n%s\nexpected:\n%s", canonical, test.expected)
+			}
+		})
+	}
+}
+
+func TestParseDocumentErrors(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		data string
+	}{
+		{name: "dtd", data: `<!DOCTYPE doc [<!ENTITY e "x">]><doc>&e;</doc>`},
+		{name: "no root", data: `<?xml version="1.0"?>`},
+		{name: "multiple roots", data: `<a></a><b></b>`},
+		{name: "unterminated", data: `<a><b></b>`},
+		{name: "text outside root", data: `<a></a>text`},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := parseDocument([]byte(test.data)); err == nil {
+				t.Errorf("No error seen")
+			}
+		})
+	}
+}
+
+// testSigner is a key and self-signed certificate used to sign test documents
+type testSigner struct {
+	key  crypto.Signer
+	cert *x509.Certificate
+}
+
+func newTestSigner(t *testing.T, key crypto.Signer) *testSigner {
+	t.Helper()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Trusted List Signer"},
+		NotBefore:    time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatalf("Error creating certificate: %s", err.Error())
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Error parsing certificate: %s", err.Error())
+	}
+	return &testSigner{key: key, cert: cert}
+}
+
+const testSignaturePlaceholder = "SIGNATURE_VALUE"
+
+// sign will add an enveloped signature to the end of the root element of the document. The document must end with
+// the closing tag of its root element.
+func (s *testSigner) sign(t *testing.T, unsigned, c14nAlgorithm string) string {
+	t.Helper()
+
+	doc, err := parseDocument([]byte(unsigned))
+	if err != nil {
+		t.Fatalf("Error parsing document: %s", err.Error())
+	}
+	c, err := newCanonicalizer(&element{Attrs: []attribute{{Local: "Algorithm", Value: c14nAlgorithm}}})
+	if err != nil {
+		t.Fatalf("Error creating canonicalizer: %s", err.Error())
+	}
+	digest := sha256.Sum256(c.canonicalizeDocument(doc))
+
+	signatureMethod := "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256"
+	if _, ok := s.key.(*rsa.PrivateKey); ok {
+		signatureMethod = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	}
+	signature := `<ds:Signature xmlns:ds="http://www.w3.org/2000/09/xmldsig#" Id="signature">
+  <ds:SignedInfo>
+    <ds:CanonicalizationMethod Algorithm="` + c14nAlgorithm + `"/>
+    <ds:SignatureMethod Algorithm="` + signatureMethod + `"/>
+    <ds:Reference URI="">
+      <ds:Transforms>
+        <ds:Transform Algorithm="http://www.w3.org/2000/09/xmldsig#enveloped-signature"/>
+        <ds:Transform Algorithm="` + c14nAlgorithm + `"/>
+      </ds:Transforms>
+      <ds:DigestMethod Algorithm="http://www.w3.org/2001/04/xmlenc#sha256"/>
+      <ds:DigestValue>` + base64.StdEncoding.EncodeToString(digest[:]) + `</ds:DigestValue>
+    </ds:Reference>
+  </ds:SignedInfo>
+  <ds:SignatureValue>` + testSignaturePlaceholder + `</ds:SignatureValue>
+  <ds:KeyInfo><ds:X509Data><ds:X509Certificate>` + base64.StdEncoding.EncodeToString(s.cert.Raw) + `</ds:X509Certificate></ds:X509Data></ds:KeyInfo>
+</ds:Signature>`
+	closing := strings.LastIndex(unsigned, "</")
+	signed := unsigned[:closing] + signature + unsigned[closing:]
+
+	// The signature is over the canonical form of SignedInfo within the signed document
+	signedDoc, err := parseDocument([]byte(signed))
+	if err != nil {
+		t.Fatalf("Error parsing signed document: %s", err.Error())
+	}
+	signedInfo := signedDoc.Root.child(namespaceDSig, "Signature").child(namespaceDSig, "SignedInfo")
+	signedInfoDigest := sha256.Sum256(c.canonicalize(signedInfo))
+
+	var signatureValue []byte
+	switch key := s.key.(type) {
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, signedInfoDigest[:])
+		if err != nil {
+			t.Fatalf("Error signing: %s", err.Error())
+		}
+		signatureValue = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case *rsa.PrivateKey:
+		signatureValue, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, signedInfoDigest[:])
+		if err != nil {
+			t.Fatalf("Error signing: %s", err.Error())
+		}
+	}
+	return strings.Replace(signed, testSignaturePlaceholder, base64.StdEncoding.EncodeToString(signatureValue), 1)
+}
+
+const testUnsignedDocument = `<?xml version="1.0" encoding="UTF-8"?>
+<tsl:TrustServiceStatusList xmlns:tsl="http://uri.etsi.org/02231/v2#" xmlns:unused="urn:unused" Id="tsl">
+  <tsl:SchemeInformation>
+    <tsl:SchemeTerritory>EU</tsl:SchemeTerritory>
+    <tsl:Note attr="a&#9;b">Value &amp; more</tsl:Note>
+  </tsl:SchemeInformation>
+</tsl:TrustServiceStatusList>`
+
+func TestVerify(t *testing.T) {
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %s", err.Error())
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating key: %s", err.Error())
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %s", err.Error())
+	}
+	other := newTestSigner(t, otherKey)
+
+	for _, test := range []struct {
+		name      string
+		key       crypto.Signer
+		algorithm string
+	}{
+		{name: "ecdsa inclusive", key: ecdsaKey, algorithm: AlgorithmC14N},
+		{name: "ecdsa exclusive", key: ecdsaKey, algorithm: AlgorithmExclusiveC14N},
+		{name: "rsa inclusive", key: rsaKey, algorithm: AlgorithmC14N},
+		{name: "rsa exclusive", key: rsaKey, algorithm: AlgorithmExclusiveC14N},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			signer := newTestSigner(t, test.key)
+			signed := signer.sign(t, testUnsignedDocument, test.algorithm)
+
+			cert, err := Verify([]byte(signed), VerifyOptions{Certificates: []*x509.Certificate{other.cert, signer.cert}})
+			if err != nil {
+				t.Fatalf("Error verifying signature: %s", err.Error())
+			}
+			if cert != signer.cert {
+				t.Errorf("Unexpected signing certificate")
+			}
+		})
+	}
+}
+
+func TestVerifyErrors(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %s", err.Error())
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %s", err.Error())
+	}
+	signer := newTestSigner(t, key)
+	other := newTestSigner(t, otherKey)
+	signed := signer.sign(t, testUnsignedDocument, AlgorithmExclusiveC14N)
+	signedInfoStart := strings.Index(signed, "<ds:SignedInfo>")
+
+	for _, test := range []struct {
+		name         string
+		data         string
+		certificates []*x509.Certificate
+	}{
+		{name: "no certificates", data: signed},
+		{name: "wrong certificate", data: signed, certificates: []*x509.Certificate{other.cert}},
+		{name: "modified content", data: strings.Replace(signed, ">EU<", ">DE<", 1), certificates: []*x509.Certificate{signer.cert}},
+		{name: "modified attribute", data: strings.Replace(signed, `Id="tsl"`, `Id="other"`, 1), certificates: []*x509.Certificate{signer.cert}},
+		{name: "modified signed info", data: signed[:signedInfoStart] + strings.Replace(signed[signedInfoStart:], `URI=""`, `URI="#tsl"`, 1), certificates: []*x509.Certificate{signer.cert}},
+		{name: "no signature", data: testUnsignedDocument, certificates: []*x509.Certificate{signer.cert}},
+		{name: "two signatures", data: strings.Replace(signed, "</tsl:TrustServiceStatusList>", signed[strings.Index(signed, "<ds:Signature"):strings.Index(signed, "</ds:Signature>")+len("</ds:Signature>")]+"</tsl:TrustServiceStatusList>", 1), certificates: []*x509.Certificate{signer.cert}},
+		{name: "dtd", data: strings.Replace(signed, `<?xml version="1.0" encoding="UTF-8"?>`, `<?xml version="1.0" encoding="UTF-8"?><!DOCTYPE tsl:TrustServiceStatusList>`, 1), certificates: []*x509.Certificate{signer.cert}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := Verify([]byte(test.data), VerifyOptions{Certificates: test.certificates}); err == nil {
+				t.Errorf("No error seen")
+			}
+		})
+	}
+}